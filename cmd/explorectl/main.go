@@ -0,0 +1,28 @@
+// Command explorectl calls the ExploreService from the command line. Run
+// it without arguments for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fleimkeipa/grpc-example/internal/explorectl"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := explorectl.Run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, explorectl.ErrUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "explorectl:", err)
+		os.Exit(1)
+	}
+}
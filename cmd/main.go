@@ -3,149 +3,802 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/fleimkeipa/grpc-example/internal/auth"
+	"github.com/fleimkeipa/grpc-example/internal/config"
+	"github.com/fleimkeipa/grpc-example/internal/descriptor"
+	"github.com/fleimkeipa/grpc-example/internal/gateway"
+	"github.com/fleimkeipa/grpc-example/internal/healthcheck"
+	"github.com/fleimkeipa/grpc-example/internal/importer"
+	"github.com/fleimkeipa/grpc-example/internal/limiter"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/metrics"
 	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/internal/schema"
 	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/internal/tlsutil"
+	"github.com/fleimkeipa/grpc-example/internal/tracing"
+	"github.com/fleimkeipa/grpc-example/internal/web"
 	pb "github.com/fleimkeipa/grpc-example/proto"
 
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
 )
 
 func main() {
-	db := initDB()
-	defer db.Close()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "migrate" {
+		migrate(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "import" {
+		importDecisions(args[1:])
+		return
+	}
+
+	cfg, logger := loadConfig(flag.NewFlagSet("explore", flag.ExitOnError), args)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
 
-	repo, err := repository.NewDecisionRepository(db)
+	m := metrics.New()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Config())
 	if err != nil {
-		log.Fatalf("failed to init repository: %v", err)
+		fatal("failed to init tracing", "error", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	// The monitor probes the repository, and the circuit breaker in front
+	// of it asks for a probe on every state change so health follows it
+	// at once.
+	healthServer := health.NewServer()
+	var repo store
+	monitor := healthcheck.NewMonitor(healthServer, func(ctx context.Context) error {
+		return probeRepository(ctx, repo)
+	}, "", pb.ExploreService_ServiceDesc.ServiceName)
+
+	repo = initRepository(ctx, cfg.DB, cfg.Server, m)
+	if cfg.Breaker.Enabled {
+		repo = withBreaker(repo, cfg.Breaker, monitor.Recheck)
 	}
 	defer repo.Close()
 
-	svc := server.NewExploreServer(repo)
+	svc := newExploreServer(cfg, repo, m)
 
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(loggingInterceptor()),
-	)
+	// The stats handler extracts W3C trace context from incoming metadata
+	// and starts a server span that repository spans are children of.
+	serverOpts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+	var authOpts []auth.InterceptorOption
+	tlsServer := initTLS(ctx, cfg.TLS)
+	if tlsServer != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsServer.Config())))
+		authOpts = append(authOpts, auth.PeerIdentities(tlsServer.Identity))
+	}
+
+	// Outermost first: metrics and the call log see the code the client
+	// gets, errors are sanitised after a panic has been recovered, and the
+	// request ID is set before either needs it as a correlation ID. Load is
+	// shed before tokens are verified.
+	interceptors := []grpc.UnaryServerInterceptor{
+		m.UnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(logger, cfg.Logging.SuccessSampleRate),
+		rpcerror.SanitizeInterceptor(),
+		rpcerror.RecoverInterceptor(),
+	}
+	if cfg.Limiter.Enabled {
+		interceptors = append(interceptors, initLimiter(cfg.Limiter, m).UnaryServerInterceptor())
+	}
+	if authCfg := cfg.Auth.Config(); authCfg.Enabled() {
+		verifier, err := auth.NewVerifier(authCfg)
+		if err != nil {
+			fatal("failed to init auth", "error", err)
+		}
+		authOpts = append(authOpts, auth.PublicMethods("/grpc.health.v1.Health/"))
+		interceptors = append(interceptors, auth.UnaryServerInterceptor(verifier, authOpts...))
+	}
+	interceptors = append(interceptors, readYourWritesInterceptor())
+	serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(interceptors...))
+
+	grpcServer := grpc.NewServer(serverOpts...)
 
 	pb.RegisterExploreServiceServer(grpcServer, svc)
 
-	port := getEnv("GRPC_PORT", "50051")
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	if cfg.GRPC.Reflection {
+		reflection.Register(grpcServer)
+	}
+	go monitor.Run(ctx, cfg.Health.CheckInterval)
+
+	lis, err := net.Listen("tcp", cfg.GRPC.Addr())
 	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+		fatal("failed to listen", "error", err)
 	}
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		log.Printf("Explore gRPC server is running on port %s", port)
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("Failed to serve: %v", err)
-		}
-	}()
+	webServer := serveGRPC(lis, cfg.GRPC, grpcServer, svc, interceptors, tlsServer)
+
+	metricsServer := serveMetrics(cfg.Metrics.Addr, m)
+	gatewayServer := serveGateway(ctx, cfg.HTTP.Addr, svc, interceptors, tlsServer)
 
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
+
+	// Report NOT_SERVING first and give load balancers time to notice
+	// before connections start closing.
+	healthServer.Shutdown()
+	time.Sleep(cfg.GRPC.ShutdownDrainDelay)
 
+	if webServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := webServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to stop gRPC-Web server", "error", err)
+		}
+		cancel()
+	}
+	if gatewayServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := gatewayServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to stop HTTP gateway", "error", err)
+		}
+		cancel()
+	}
 	grpcServer.GracefulStop()
 
-	log.Println("Server stopped")
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	slog.Info("server stopped")
+}
+
+// store is what main needs from either a single-database or a sharded
+// repository.
+type store interface {
+	repository.Decisions
+	repository.DecidedSource
+	Check(ctx context.Context) error
+	Close() error
+}
+
+// decidedCacheUser is a store that can skip lookups with a decided cache.
+type decidedCacheUser interface {
+	UseDecidedCache(c *repository.DecidedCache)
 }
 
-func initDB() *sql.DB {
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPass := getEnv("DB_PASS", "postgres")
-	dbName := getEnv("DB_NAME", "explore")
+// newExploreServer wraps repo in the caches enabled by cfg and returns the
+// service over it.
+func newExploreServer(cfg config.Config, repo store, m *metrics.Metrics) *server.ExploreServer {
+	var decisions repository.Decisions = repo
+	if cfg.Cache.MaxEntries > 0 {
+		decisions = repository.NewCachedDecisionRepository(repo, repository.CacheConfig{
+			MaxEntries:   cfg.Cache.MaxEntries,
+			CountTTL:     cfg.Cache.CountTTL,
+			ListTTL:      cfg.Cache.ListTTL,
+			FetchTimeout: cfg.Cache.FetchTimeout,
+		})
+	}
+
+	timeouts, err := cfg.Server.TimeoutPolicy()
+	if err != nil {
+		fatal("invalid timeout settings", "error", err)
+	}
 
-	dsn := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPass, dbName,
+	userIDs, err := server.NewUserIDValidator(
+		server.UserIDFormat(cfg.Server.UserIDFormat),
+		cfg.Server.UserIDPattern,
+		cfg.Server.UserIDMaxLength,
 	)
+	if err != nil {
+		fatal("invalid user ID validation settings", "error", err)
+	}
+
+	opts := []server.Option{
+		server.WithObserver(m),
+		server.WithUserIDValidator(userIDs),
+		server.WithTimeoutPolicy(timeouts),
+	}
+	if cfg.DecidedCache.MaxBytes > 0 {
+		decided := repository.NewDecidedCache(repo, repository.DecidedCacheConfig{
+			MaxBytes:          cfg.DecidedCache.MaxBytes,
+			FalsePositiveRate: cfg.DecidedCache.FalsePositiveRate,
+			MaxAge:            cfg.DecidedCache.MaxAge,
+			LoadTimeout:       cfg.DecidedCache.LoadTimeout,
+		})
+		m.WatchDecidedCache(decided)
+		if r, ok := repo.(decidedCacheUser); ok {
+			r.UseDecidedCache(decided)
+		}
+		opts = append(opts, server.WithDecidedCache(decided))
+	}
+
+	return server.NewExploreServer(decisions, opts...)
+}
+
+// initRepository returns a repository over the shards listed in
+// db.shard_dsns, or over the primary database and its replicas otherwise.
+func initRepository(ctx context.Context, cfg config.DB, srv config.Server, m *metrics.Metrics) store {
+	repoOpts := []repository.RepositoryOption{
+		repository.WithPageSize(srv.PageSize),
+		repository.WithRetryPolicy(cfg.RetryPolicy()),
+		repository.WithStatementTimeout(cfg.StatementTimeout),
+	}
 
-	db, err := sql.Open("postgres", dsn)
+	if len(cfg.ShardDSNs) > 0 {
+		return initShards(ctx, cfg, repoOpts, m)
+	}
+
+	db := initDB(cfg)
+	go maintainPartitions(ctx, db, cfg)
+	m.WatchDB("primary", db)
+
+	replicas := initReplicas(cfg)
+	for i, replica := range replicas {
+		m.WatchDB(fmt.Sprintf("replica_%d", i), replica)
+	}
+
+	cluster := repository.NewCluster(db, replicas...)
+	go cluster.Run(ctx, cfg.ReplicaHealthInterval)
+
+	repo, err := repository.NewReplicatedDecisionRepository(cluster, repoOpts...)
 	if err != nil {
-		log.Fatalf("DB connection failed: %v", err)
+		fatal("failed to init repository", "error", err)
 	}
 
-	db.SetMaxOpenConns(25)                  // Max connections
-	db.SetMaxIdleConns(10)                  // Idle connections
-	db.SetConnMaxLifetime(5 * time.Minute)  // Connection lifetime
-	db.SetConnMaxIdleTime(10 * time.Minute) // Idle timeout
+	return &clusterStore{DecisionRepository: repo, cluster: cluster, db: db}
+}
+
+// clusterStore closes the databases behind a single-database repository
+// along with its statements.
+type clusterStore struct {
+	*repository.DecisionRepository
+	cluster *repository.Cluster
+	db      *sql.DB
+}
+
+func (s *clusterStore) Close() error {
+	s.DecisionRepository.Close()
+	s.cluster.Close()
+	return s.db.Close()
+}
+
+// withBreaker puts a circuit breaker in front of repo that calls changed
+// after every state transition.
+func withBreaker(repo store, cfg config.Breaker, changed func()) store {
+	breakerCfg := cfg.Config()
+	breakerCfg.OnStateChange = func(from, to repository.BreakerState) {
+		changed()
+	}
+
+	return &breakerStore{CircuitBreaker: repository.NewCircuitBreaker(repo, breakerCfg), next: repo}
+}
+
+// breakerStore closes the repository behind a circuit breaker.
+type breakerStore struct {
+	*repository.CircuitBreaker
+	next store
+}
+
+func (s *breakerStore) Close() error {
+	return s.next.Close()
+}
+
+func (s *breakerStore) UseDecidedCache(c *repository.DecidedCache) {
+	if r, ok := s.next.(decidedCacheUser); ok {
+		r.UseDecidedCache(c)
+	}
+}
+
+// probeRepository checks repo for the health monitor. An open circuit
+// breaker is conclusive: the breaker has already seen enough failures.
+func probeRepository(ctx context.Context, repo store) error {
+	err := repo.Check(ctx)
+	if errors.Is(err, repository.ErrCircuitOpen) {
+		return healthcheck.Conclusive(err)
+	}
+	return err
+}
+
+func initShards(ctx context.Context, cfg config.DB, repoOpts []repository.RepositoryOption, m *metrics.Metrics) store {
+	shardMap := repository.EvenShardMap(1024, len(cfg.ShardDSNs))
+	if cfg.ShardMap != "" {
+		var err error
+		if shardMap, err = repository.LoadShardMap(cfg.ShardMap); err != nil {
+			fatal("failed to load shard map", "error", err)
+		}
+	}
+
+	var dbs []*sql.DB
+	var shards []*repository.DecisionRepository
+	for i, dsn := range cfg.ShardDSNs {
+		db := connectDB(dsn, cfg)
+		go maintainPartitions(ctx, db, cfg)
+		m.WatchDB(fmt.Sprintf("shard_%d", i), db)
+		repo, err := repository.NewDecisionRepository(db, repoOpts...)
+		if err != nil {
+			fatal("failed to init shard repository", "error", err)
+		}
+		dbs = append(dbs, db)
+		shards = append(shards, repo)
+	}
+
+	repo, err := repository.NewShardedDecisionRepository(shards, shardMap)
+	if err != nil {
+		fatal("invalid shard map", "error", err)
+	}
+
+	slog.Info("sharding decisions across PostgreSQL instances", "shards", len(shards))
+
+	return &shardedStore{ShardedDecisionRepository: repo, dbs: dbs}
+}
+
+type shardedStore struct {
+	*repository.ShardedDecisionRepository
+	dbs []*sql.DB
+}
+
+func (s *shardedStore) Close() error {
+	s.ShardedDecisionRepository.Close()
+	for _, db := range s.dbs {
+		db.Close()
+	}
+	return nil
+}
+
+func initDB(cfg config.DB) *sql.DB {
+	return connectDB(cfg.DSN(), cfg)
+}
+
+// connectDB opens dsn, waits for it to answer and applies the schema.
+func connectDB(dsn string, cfg config.DB) *sql.DB {
+	db := openDB(dsn, cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := db.PingContext(ctx); err != nil {
-		log.Fatalf("DB ping failed: %v", err)
+		fatal("database ping failed", "error", err)
 	}
 
-	log.Println("Connected to PostgreSQL")
+	slog.Info("connected to PostgreSQL")
 
-	createTable(db)
+	applySchema(db, cfg)
 
 	return db
 }
 
-func createTable(db *sql.DB) {
-	schema := `
-	CREATE TABLE IF NOT EXISTS decisions (
-		actor_user_id TEXT NOT NULL,
-		recipient_user_id TEXT NOT NULL,
-		liked_recipient BOOLEAN NOT NULL,
-		created_at TIMESTAMPTZ DEFAULT NOW(),
-		updated_at TIMESTAMPTZ DEFAULT NOW(),
-		PRIMARY KEY (actor_user_id, recipient_user_id)
-	);
-	CREATE INDEX IF NOT EXISTS idx_decisions_created_at ON decisions (created_at DESC);
-	CREATE INDEX IF NOT EXISTS idx_decisions_updated_at ON decisions (updated_at DESC);
-	CREATE INDEX IF NOT EXISTS idx_recipient_user_id ON decisions (recipient_user_id) WHERE liked_recipient = true;
-	CREATE INDEX IF NOT EXISTS idx_actor_user_id ON decisions (actor_user_id) WHERE liked_recipient = true;
-	`
-	if _, err := db.Exec(schema); err != nil {
-		log.Fatalf("DB migration failed: %v", err)
+// initReplicas opens the replica DSNs. Replicas are not pinged here: one
+// that is down at startup is simply taken out of rotation by the first
+// health check or failed query.
+func initReplicas(cfg config.DB) []*sql.DB {
+	var replicas []*sql.DB
+	for _, dsn := range cfg.ReplicaDSNs {
+		replicas = append(replicas, openDB(dsn, cfg))
 	}
+
+	if len(replicas) > 0 {
+		slog.Info("routing reads to PostgreSQL replicas", "replicas", len(replicas))
+	}
+
+	return replicas
 }
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+func openDB(dsn string, cfg config.DB) *sql.DB {
+	connector, err := repository.NewConnector(dsn, cfg.StatementTimeout)
+	if err != nil {
+		fatal("database connection failed", "error", err)
 	}
+	db := sql.OpenDB(connector)
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	return fallback
+	return db
 }
 
-func loggingInterceptor() grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
-		handler grpc.UnaryHandler) (any, error) {
-		start := time.Now()
+// initLimiter returns the adaptive concurrency limiter, with its state
+// exported to m. Health checks are never shed.
+func initLimiter(cfg config.Limiter, m *metrics.Metrics) *limiter.Limiter {
+	limiterCfg, err := cfg.Config()
+	if err != nil {
+		fatal("invalid limiter settings", "error", err)
+	}
 
-		resp, err := handler(ctx, req)
+	l := limiter.New(limiterCfg,
+		limiter.WithObserver(m),
+		limiter.ExemptMethods("/grpc.health.v1.Health/"),
+	)
+	m.WatchLimiter(l)
 
-		duration := time.Since(start)
-		code := codes.OK
-		message := "Success"
-		if err != nil {
-			stats := status.Convert(err)
-			code = stats.Code()
-			message = stats.Message()
+	return l
+}
+
+// initTLS loads the listener certificates and watches them for changes. It
+// returns nil to serve plaintext when no certificate is configured.
+func initTLS(ctx context.Context, cfg config.TLS) *tlsutil.Server {
+	if cfg.CertFile == "" {
+		return nil
+	}
+
+	tlsServer, err := tlsutil.NewServer(tlsutil.ServerConfig{
+		CertFile:       cfg.CertFile,
+		KeyFile:        cfg.KeyFile,
+		ClientCAFile:   cfg.ClientCAFile,
+		ClientAuth:     tlsutil.ClientAuth(cfg.ClientAuth),
+		AllowedSANs:    cfg.SANs(),
+		ReloadInterval: cfg.ReloadInterval,
+	})
+	if err != nil {
+		fatal("failed to init TLS", "error", err)
+	}
+	go tlsServer.Watch(ctx)
+
+	return tlsServer
+}
+
+// serveMetrics exposes /metrics and the schema at /descriptor on addr. An
+// empty address disables the listener.
+func serveMetrics(addr string, m *metrics.Metrics) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	mux.Handle("/descriptor", descriptor.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		slog.Info("serving metrics", "addr", addr, "path", "/metrics")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("failed to serve metrics", "error", err)
+		}
+	}()
+
+	return srv
+}
+
+// serveGRPC serves grpcServer on lis. With gRPC-Web on, lis is served over
+// HTTP instead, with HTTP/2 over cleartext when TLS is off, so browsers can
+// call the service over gRPC-Web and Connect on the same port; the HTTP
+// server is returned to be shut down.
+func serveGRPC(lis net.Listener, cfg config.GRPC, grpcServer *grpc.Server, svc pb.ExploreServiceServer,
+	interceptors []grpc.UnaryServerInterceptor, tlsServer *tlsutil.Server) *http.Server {
+	if !cfg.Web {
+		go func() {
+			slog.Info("explore gRPC server is running", "port", cfg.Port)
+			if err := grpcServer.Serve(lis); err != nil {
+				fatal("failed to serve", "error", err)
+			}
+		}()
+		return nil
+	}
+
+	handler, err := web.NewHandler(grpcServer, svc, cfg.CORS(), interceptors...)
+	if err != nil {
+		fatal("failed to init gRPC-Web", "error", err)
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetHTTP2(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+
+	go func() {
+		slog.Info("explore gRPC server is running", "port", cfg.Port, "web", true)
+		var err error
+		if tlsServer != nil {
+			srv.TLSConfig = tlsServer.HTTPConfig()
+			err = srv.ServeTLS(lis, "", "")
+		} else {
+			err = srv.Serve(lis)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("failed to serve", "error", err)
+		}
+	}()
+
+	return srv
+}
+
+// serveGateway serves svc as JSON over HTTP on addr, through the same
+// interceptors as gRPC and with the same certificate if TLS is on. An empty
+// address disables the gateway.
+func serveGateway(ctx context.Context, addr string, svc pb.ExploreServiceServer,
+	interceptors []grpc.UnaryServerInterceptor, tlsServer *tlsutil.Server) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	handler, err := gateway.NewHandler(ctx, svc, interceptors...)
+	if err != nil {
+		fatal("failed to init HTTP gateway", "error", err)
+	}
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		slog.Info("serving HTTP/JSON gateway", "addr", addr)
+		var err error
+		if tlsServer != nil {
+			srv.TLSConfig = tlsServer.HTTPConfig()
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("failed to serve HTTP gateway", "error", err)
+		}
+	}()
+
+	return srv
+}
+
+func applySchema(db *sql.DB, cfg config.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := schema.Apply(ctx, db, schemaOptions(cfg)); err != nil {
+		fatal("database migration failed", "error", err)
+	}
+}
+
+func schemaOptions(cfg config.DB) schema.Options {
+	return schema.Options{
+		HashPartitions: cfg.HashPartitions,
+		TimePartitions: cfg.TimePartitions,
+		MonthsAhead:    cfg.TimePartitionsAhead,
+	}
+}
+
+// maintainPartitions keeps future monthly partitions created while the
+// server runs.
+func maintainPartitions(ctx context.Context, db *sql.DB, cfg config.DB) {
+	opts := schemaOptions(cfg)
+	if !opts.TimePartitions {
+		return
+	}
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := schema.EnsureTimePartitions(ctx, db, opts, now); err != nil {
+				slog.Error("failed to create time partitions", "error", err)
+			}
+		}
+	}
+}
+
+// migrate converts the decisions table of the primary database, or of every
+// shard, to the partitioned layout selected by db.hash_partitions and
+// db.time_partitions.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	batchSize := fs.Int("batch-size", 5000, "rows copied per backfill batch")
+	cfg, _ := loadConfig(fs, args)
+
+	opts := schemaOptions(cfg.DB)
+	if opts.HashPartitions <= 0 {
+		fatal("set db.hash_partitions (DB_HASH_PARTITIONS) to the number of hash partitions to convert to")
+	}
+
+	dsns := cfg.DB.ShardDSNs
+	if len(dsns) == 0 {
+		dsns = []string{cfg.DB.DSN()}
+	}
+
+	// the backfill and the swap run for as long as they need
+	cfg.DB.StatementTimeout = 0
+	for _, dsn := range dsns {
+		db := connectDB(dsn, cfg.DB)
+		if err := schema.ConvertToPartitioned(context.Background(), db, opts, *batchSize); err != nil {
+			fatal("partition migration failed", "error", err)
+		}
+		db.Close()
+	}
+}
+
+// importDecisions loads decisions with their timestamps from a JSONL or CSV
+// file, or stdin for "-", into the primary database or the shards. User IDs
+// are validated as server.user_id_format says. Interrupting it stops after
+// the batch in flight; with -checkpoint a re-run carries on from there.
+func importDecisions(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s import [flags] <file.jsonl|file.csv|->\n\nflags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "", "jsonl or csv; by default taken from the file extension")
+	batchSize := fs.Int("batch-size", importer.DefaultBatchSize, "records copied per transaction")
+	checkpoint := fs.String("checkpoint", "", "file to save progress to after every batch and resume from")
+	dryRun := fs.Bool("dry-run", false, "validate every record without writing")
+	maxErrors := fs.Int64("max-errors", 0, "invalid records to log and skip before failing")
+	progress := fs.Duration("progress", 10*time.Second, "how often to log progress")
+	cfg, _ := loadConfig(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	importFormat := importer.Format(*format)
+	if importFormat == "" {
+		var err error
+		if importFormat, err = importer.FormatOf(path); err != nil {
+			fatal("set -format", "error", err)
+		}
+	}
+
+	userIDs, err := server.NewUserIDValidator(
+		server.UserIDFormat(cfg.Server.UserIDFormat),
+		cfg.Server.UserIDPattern,
+		cfg.Server.UserIDMaxLength,
+	)
+	if err != nil {
+		fatal("invalid user ID validation settings", "error", err)
+	}
+
+	src := os.Stdin
+	if path != "-" {
+		if src, err = os.Open(path); err != nil {
+			fatal("failed to open import file", "error", err)
+		}
+		defer src.Close()
+	}
+
+	// A dry run doesn't connect, and the shard map is only used with shards.
+	var dbs []*sql.DB
+	shardMap := repository.EvenShardMap(1024, max(len(cfg.DB.ShardDSNs), 1))
+	if !*dryRun {
+		// batches are merged in statements that run for as long as they need
+		cfg.DB.StatementTimeout = 0
+		dsns := cfg.DB.ShardDSNs
+		if len(dsns) == 0 {
+			dsns = []string{cfg.DB.DSN()}
+		} else if cfg.DB.ShardMap != "" {
+			if shardMap, err = repository.LoadShardMap(cfg.DB.ShardMap); err != nil {
+				fatal("failed to load shard map", "error", err)
+			}
+		}
+		for _, dsn := range dsns {
+			db := connectDB(dsn, cfg.DB)
+			defer db.Close()
+			dbs = append(dbs, db)
+		}
+	}
+
+	im, err := importer.New(dbs, shardMap, importer.Config{
+		Format:           importFormat,
+		UserIDs:          userIDs,
+		BatchSize:        *batchSize,
+		MaxErrors:        *maxErrors,
+		DryRun:           *dryRun,
+		Checkpoint:       *checkpoint,
+		ProgressInterval: *progress,
+	})
+	if err != nil {
+		fatal("failed to init import", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := im.Run(ctx, path, src)
+	if err != nil {
+		fatal("import failed", "error", err)
+	}
+	if report.Inserted+report.Updated > 0 {
+		warnStaleCaches(cfg)
+	}
+}
+
+// warnStaleCaches says how long running servers configured like cfg may
+// serve what they cached before an import. The caches live in each
+// server's memory and are only invalidated by writes made through it.
+func warnStaleCaches(cfg config.Config) {
+	args := []any{"component", "import"}
+	if cfg.Cache.MaxEntries > 0 {
+		args = append(args, "count_ttl", cfg.Cache.CountTTL, "list_ttl", cfg.Cache.ListTTL)
+	}
+	if cfg.DecidedCache.MaxBytes > 0 {
+		if cfg.DecidedCache.MaxAge > 0 {
+			args = append(args, "decided_cache_max_age", cfg.DecidedCache.MaxAge)
+		} else {
+			args = append(args, "decided_cache_max_age", "until restart")
+		}
+	}
+	if len(args) > 2 {
+		slog.Warn("running servers don't see the imported decisions until their caches expire; restart them to see them at once", args...)
+	}
+}
+
+// loadConfig loads the configuration with fs's flags parsed from args and
+// installs the logger it configures. With -print-config it prints the
+// configuration and exits instead.
+func loadConfig(fs *flag.FlagSet, args []string) (config.Config, *slog.Logger) {
+	loaded, err := config.Load(fs, args)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+
+	if loaded.PrintConfig {
+		if err := config.Print(os.Stdout, loaded.Config); err != nil {
+			fatal("failed to print configuration", "error", err)
 		}
+		os.Exit(0)
+	}
 
-		log.Printf("[gRPC] %s - %s - %s - %v", info.FullMethod, code, message, duration)
+	logger := initLogger(loaded.Config.Logging)
+	for _, msg := range loaded.Deprecated {
+		logger.Warn(msg)
+	}
+
+	return loaded.Config, logger
+}
+
+// initLogger installs the configured slog logger as the default, so the
+// standard log package writes through it too.
+func initLogger(cfg config.Logging) *slog.Logger {
+	logger, err := logging.New(os.Stderr, cfg.Config())
+	if err != nil {
+		fatal("failed to init logging", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	return logger
+}
+
+// fatal logs msg with args at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// readYourWritesInterceptor forces primary reads for calls carrying the
+// x-read-your-writes metadata, so a client can read back its own PutDecision
+// without waiting for replication.
+func readYourWritesInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get("x-read-your-writes"); len(v) > 0 && v[0] == "true" {
+				ctx = repository.WithPrimaryReads(ctx)
+			}
+		}
 
-		return resp, err
+		return handler(ctx, req)
 	}
 }
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Scopes that let a caller act on behalf of any user.
+const (
+	ScopeService = "service"
+	ScopeAdmin   = "admin"
+)
+
+// Caller is the authenticated identity behind a request.
+type Caller struct {
+	Subject string
+	Scopes  []string
+}
+
+// HasScope reports whether the caller was granted scope.
+func (c Caller) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// Privileged reports whether the caller may act on behalf of other users.
+func (c Caller) Privileged() bool {
+	return c.HasScope(ScopeService) || c.HasScope(ScopeAdmin)
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying caller.
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored by the auth interceptor. ok is
+// false when authentication is disabled.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(Caller)
+	return caller, ok
+}
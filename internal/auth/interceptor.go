@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// InterceptorOption customises UnaryServerInterceptor.
+type InterceptorOption func(*interceptorOptions)
+
+type interceptorOptions struct {
+	publicPrefixes []string
+	peerIdentity   func(ctx context.Context) (string, bool)
+}
+
+// PublicMethods lets methods whose full name starts with one of prefixes
+// through unauthenticated.
+func PublicMethods(prefixes ...string) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.publicPrefixes = append(o.publicPrefixes, prefixes...)
+	}
+}
+
+// PeerIdentities authenticates calls without a bearer token through the
+// connection itself, e.g. a verified mTLS client certificate. Such callers
+// are services and get the service scope.
+func PeerIdentities(identity func(ctx context.Context) (string, bool)) InterceptorOption {
+	return func(o *interceptorOptions) {
+		o.peerIdentity = identity
+	}
+}
+
+// UnaryServerInterceptor rejects calls without a valid bearer token and
+// stores the token's caller in the context.
+func UnaryServerInterceptor(v *Verifier, opts ...InterceptorOption) grpc.UnaryServerInterceptor {
+	var o interceptorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		for _, prefix := range o.publicPrefixes {
+			if strings.HasPrefix(info.FullMethod, prefix) {
+				return handler(ctx, req)
+			}
+		}
+
+		token, ok := bearerToken(ctx)
+		if !ok {
+			if o.peerIdentity != nil {
+				if id, ok := o.peerIdentity(ctx); ok {
+					return handler(authenticated(ctx, Caller{Subject: id, Scopes: []string{ScopeService}}), req)
+				}
+			}
+			return nil, apierror.New(codes.Unauthenticated, apierror.ReasonUnauthenticated, "missing bearer token")
+		}
+
+		caller, err := v.Verify(token)
+		if err != nil {
+			return nil, apierror.New(codes.Unauthenticated, apierror.ReasonUnauthenticated, "invalid bearer token")
+		}
+
+		return handler(authenticated(ctx, caller), req)
+	}
+}
+
+// authenticated stores caller in ctx and adds its subject to the request
+// logger.
+func authenticated(ctx context.Context, caller Caller) context.Context {
+	ctx = WithCaller(ctx, caller)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(logging.KeySubject, caller.Subject))
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok && token != "" {
+			return token, true
+		}
+	}
+
+	return "", false
+}
+
+// Authorize allows the call if authentication is disabled, the caller is
+// userID, or the caller holds a service or admin scope.
+func Authorize(ctx context.Context, userID string) error {
+	caller, ok := CallerFromContext(ctx)
+	if !ok || caller.Subject == userID || caller.Privileged() {
+		return nil
+	}
+
+	return apierror.New(codes.PermissionDenied, apierror.ReasonPermissionDenied, "caller may not act on behalf of another user")
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config selects the keys tokens are verified against. Any combination of
+// sources may be set; a token is accepted if it verifies with one of them.
+type Config struct {
+	// HMACSecret verifies HS256 tokens.
+	HMACSecret []byte
+	// RSAPublicKeyFile is a PEM encoded public key verifying RS256 tokens.
+	RSAPublicKeyFile string
+	// JWKSFile is a JSON Web Key Set whose RSA keys verify RS256 tokens,
+	// selected by the token's kid header.
+	JWKSFile string
+	// Issuer and Audience, when set, must match the token's claims.
+	Issuer   string
+	Audience string
+}
+
+// Enabled reports whether any key source is configured.
+func (c Config) Enabled() bool {
+	return len(c.HMACSecret) > 0 || c.RSAPublicKeyFile != "" || c.JWKSFile != ""
+}
+
+// Verifier validates bearer tokens and extracts the caller they identify.
+type Verifier struct {
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+	jwks       map[string]*rsa.PublicKey
+	parser     *jwt.Parser
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	v := &Verifier{hmacSecret: cfg.HMACSecret}
+
+	if cfg.RSAPublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RSA public key: %w", err)
+		}
+		if v.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+			return nil, fmt.Errorf("failed to parse RSA public key: %w", err)
+		}
+	}
+
+	if cfg.JWKSFile != "" {
+		var err error
+		if v.jwks, err = loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var methods []string
+	if len(v.hmacSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.rsaKey != nil || len(v.jwks) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no verification keys configured")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// Verify checks the token's signature and claims and returns its caller.
+func (v *Verifier) Verify(token string) (Caller, error) {
+	var c claims
+	if _, err := v.parser.ParseWithClaims(token, &c, v.key); err != nil {
+		return Caller{}, err
+	}
+	if c.Subject == "" {
+		return Caller{}, fmt.Errorf("token has no subject")
+	}
+
+	scopes := append(strings.Fields(c.Scope), c.Scp...)
+	return Caller{Subject: c.Subject, Scopes: scopes}, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (any, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.hmacSecret, nil
+	case jwt.SigningMethodRS256.Alg():
+		if kid, _ := token.Header["kid"].(string); kid != "" {
+			if key, ok := v.jwks[kid]; ok {
+				return key, nil
+			}
+		}
+		if v.rsaKey != nil {
+			return v.rsaKey, nil
+		}
+		return nil, fmt.Errorf("no key for kid %v", token.Header["kid"])
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+}
+
+// loadJWKS reads the RSA signing keys from a JSON Web Key Set file.
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for JWKS key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for JWKS key %q: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS %s has no RSA signing keys", path)
+	}
+
+	return keys, nil
+}
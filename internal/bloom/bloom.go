@@ -0,0 +1,96 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a fixed-size Bloom filter over string keys. It is not safe for
+// concurrent use; callers are expected to guard it themselves.
+type Filter struct {
+	bits     []uint64
+	m        uint64 // number of bits
+	k        uint64 // number of hash functions
+	capacity int
+	count    int
+}
+
+// New sizes a filter for capacity items at the given false-positive rate.
+func New(capacity int, fpRate float64) *Filter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// Add inserts key into the filter.
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+// Test reports whether key may be in the filter. A false result means the
+// key was definitely never added.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Saturated reports whether more items were added than the filter was sized
+// for, meaning the configured false-positive rate no longer holds.
+func (f *Filter) Saturated() bool {
+	return f.count > f.capacity
+}
+
+// Len returns the number of Add calls made on the filter.
+func (f *Filter) Len() int {
+	return f.count
+}
+
+// SizeBytes returns the memory held by the bit array.
+func (f *Filter) SizeBytes() int {
+	return len(f.bits) * 8
+}
+
+// hashes derives two independent hashes for double hashing
+// (Kirsch–Mitzenmacher), so only one pass over the key is needed.
+func hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	h1 := sum & 0xffffffff
+	h2 := sum >> 32
+	if h2 == 0 {
+		h2 = 1
+	}
+	return h1, h2
+}
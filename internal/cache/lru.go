@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a concurrency-safe least-recently-used cache bounded by the total
+// cost of its entries rather than their count.
+type LRU[K comparable, V any] struct {
+	mu      sync.Mutex
+	maxCost int64
+	cost    int64
+	ll      *list.List
+	items   map[K]*list.Element
+	onEvict func(key K, value V)
+}
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+}
+
+// NewLRU creates a cache that evicts least recently used entries once the
+// summed cost exceeds maxCost. onEvict, when non-nil, is called for every
+// entry removed to make room; it must not call back into the cache.
+func NewLRU[K comparable, V any](maxCost int64, onEvict func(key K, value V)) *LRU[K, V] {
+	return &LRU[K, V]{
+		maxCost: maxCost,
+		ll:      list.New(),
+		items:   make(map[K]*list.Element),
+		onEvict: onEvict,
+	}
+}
+
+// Get returns the value stored for key and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*entry[K, V]).value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Set stores value under key with the given cost, evicting older entries as
+// needed. Entries costlier than the whole budget are not stored.
+func (c *LRU[K, V]) Set(key K, value V, cost int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		c.cost += cost - e.cost
+		e.value = value
+		e.cost = cost
+		c.ll.MoveToFront(el)
+	} else {
+		if cost > c.maxCost {
+			return
+		}
+		c.items[key] = c.ll.PushFront(&entry[K, V]{key: key, value: value, cost: cost})
+		c.cost += cost
+	}
+
+	for c.cost > c.maxCost {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		e := c.removeElement(oldest)
+		if c.onEvict != nil {
+			c.onEvict(e.key, e.value)
+		}
+	}
+}
+
+// Update runs fn against the value stored for key while holding the cache
+// lock, so in-place mutations do not race with readers. It returns false if
+// the key is absent.
+func (c *LRU[K, V]) Update(key K, fn func(value V)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	fn(el.Value.(*entry[K, V]).value)
+	return true
+}
+
+// Delete removes key from the cache without invoking onEvict.
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// Cost returns the summed cost of all cached entries.
+func (c *LRU[K, V]) Cost() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cost
+}
+
+func (c *LRU[K, V]) removeElement(el *list.Element) *entry[K, V] {
+	e := c.ll.Remove(el).(*entry[K, V])
+	delete(c.items, e.key)
+	c.cost -= e.cost
+	return e
+}
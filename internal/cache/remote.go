@@ -0,0 +1,14 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Remote is a shared cache such as Redis or Memcached that sits behind the
+// in-process LRU. Implementations report a missing key as (nil, false, nil).
+type Remote interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,576 @@
+// Package config loads the service settings from defaults, a YAML or TOML
+// file, environment variables and command-line flags, in increasing order
+// of precedence.
+//
+// Every setting is a field below tagged with its file key (yaml), its
+// environment variable (env) and a description used for its flag. The flag
+// name is the dotted file path, e.g. -db.host for DB_HOST. Fields tagged
+// secret are redacted when the configuration is printed.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/auth"
+	"github.com/fleimkeipa/grpc-example/internal/limiter"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/internal/tlsutil"
+	"github.com/fleimkeipa/grpc-example/internal/tracing"
+	"github.com/fleimkeipa/grpc-example/internal/web"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc/codes"
+)
+
+type Config struct {
+	GRPC         GRPC         `yaml:"grpc"`
+	HTTP         HTTP         `yaml:"http"`
+	DB           DB           `yaml:"db"`
+	Breaker      Breaker      `yaml:"breaker"`
+	Server       Server       `yaml:"server"`
+	Cache        Cache        `yaml:"cache"`
+	DecidedCache DecidedCache `yaml:"decided_cache"`
+	Auth         Auth         `yaml:"auth"`
+	TLS          TLS          `yaml:"tls"`
+	Limiter      Limiter      `yaml:"limiter"`
+	Health       Health       `yaml:"health"`
+	Metrics      Metrics      `yaml:"metrics"`
+	Logging      Logging      `yaml:"logging"`
+	Tracing      Tracing      `yaml:"tracing"`
+}
+
+type GRPC struct {
+	Port               int           `yaml:"port" env:"GRPC_PORT" desc:"gRPC listen port"`
+	ShutdownDrainDelay time.Duration `yaml:"shutdown_drain_delay" env:"SHUTDOWN_DRAIN_DELAY" desc:"time between reporting NOT_SERVING and closing connections"`
+
+	Reflection bool `yaml:"reflection" env:"GRPC_REFLECTION" desc:"register gRPC server reflection, v1 and v1alpha"`
+
+	Web                bool          `yaml:"web" env:"GRPC_WEB_ENABLED" desc:"also serve gRPC-Web and Connect on the gRPC port"`
+	CORSAllowedOrigins []string      `yaml:"cors_allowed_origins" env:"GRPC_CORS_ALLOWED_ORIGINS" desc:"comma-separated browser origins allowed to call gRPC-Web and Connect, * for any"`
+	CORSMaxAge         time.Duration `yaml:"cors_max_age" env:"GRPC_CORS_MAX_AGE" desc:"time browsers may cache a CORS preflight"`
+}
+
+type HTTP struct {
+	Addr string `yaml:"addr" env:"HTTP_ADDR" desc:"HTTP/JSON gateway listen address, empty disables"`
+}
+
+type DB struct {
+	Host        string `yaml:"host" env:"DB_HOST" desc:"PostgreSQL host"`
+	Port        int    `yaml:"port" env:"DB_PORT" desc:"PostgreSQL port"`
+	User        string `yaml:"user" env:"DB_USER" desc:"PostgreSQL user"`
+	Password    string `yaml:"password" env:"DB_PASSWORD,DB_PASS" secret:"true" desc:"PostgreSQL password"`
+	Name        string `yaml:"name" env:"DB_NAME" desc:"PostgreSQL database"`
+	SSLMode     string `yaml:"sslmode" env:"DB_SSLMODE" desc:"libpq sslmode"`
+	SSLRootCert string `yaml:"sslrootcert" env:"DB_SSLROOTCERT" desc:"CA certificate verifying the server"`
+	SSLCert     string `yaml:"sslcert" env:"DB_SSLCERT" desc:"client certificate"`
+	SSLKey      string `yaml:"sslkey" env:"DB_SSLKEY" desc:"client certificate key"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" desc:"connections per database pool"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" desc:"idle connections kept per pool"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" desc:"time after which a connection is replaced"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" desc:"time after which an idle connection is closed"`
+	// StatementTimeout applies to the server's connections only; migrate
+	// and import statements run for as long as they need. Calls with a
+	// longer budget raise it for themselves.
+	StatementTimeout time.Duration `yaml:"statement_timeout" env:"DB_STATEMENT_TIMEOUT" desc:"statement_timeout of server connections, 0 leaves the database's"`
+
+	RetryMaxAttempts int           `yaml:"retry_max_attempts" env:"DB_RETRY_MAX_ATTEMPTS" desc:"attempts per query on transient failures, 1 disables retries"`
+	RetryBaseDelay   time.Duration `yaml:"retry_base_delay" env:"DB_RETRY_BASE_DELAY" desc:"backoff before the first retry"`
+	RetryMaxDelay    time.Duration `yaml:"retry_max_delay" env:"DB_RETRY_MAX_DELAY" desc:"longest backoff between retries"`
+
+	HashPartitions      int  `yaml:"hash_partitions" env:"DB_HASH_PARTITIONS" desc:"hash partitions of the decisions table, 0 for a plain table"`
+	TimePartitions      bool `yaml:"time_partitions" env:"DB_TIME_PARTITIONS" desc:"sub-partition by month of creation"`
+	TimePartitionsAhead int  `yaml:"time_partitions_ahead" env:"DB_TIME_PARTITIONS_AHEAD" desc:"monthly partitions created in advance"`
+
+	// DSNs may embed passwords, so they are treated as secrets.
+	ReplicaDSNs           []string      `yaml:"replica_dsns" env:"DB_REPLICA_DSNS" secret:"true" desc:"comma-separated read replica DSNs"`
+	ReplicaHealthInterval time.Duration `yaml:"replica_health_interval" env:"DB_REPLICA_HEALTH_INTERVAL" desc:"interval between replica health checks"`
+	ShardDSNs             []string      `yaml:"shard_dsns" env:"DB_SHARD_DSNS" secret:"true" desc:"comma-separated shard DSNs in shard order"`
+	ShardMap              string        `yaml:"shard_map" env:"DB_SHARD_MAP" desc:"JSON file mapping slots to shards"`
+}
+
+// RetryPolicy returns the repository retry settings.
+func (c DB) RetryPolicy() repository.RetryPolicy {
+	return repository.RetryPolicy{
+		MaxAttempts: c.RetryMaxAttempts,
+		BaseDelay:   c.RetryBaseDelay,
+		MaxDelay:    c.RetryMaxDelay,
+	}
+}
+
+// DSN returns the libpq connection string of the primary database.
+func (c DB) DSN() string {
+	dsn := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.Name, c.SSLMode,
+	)
+
+	for _, opt := range []struct{ key, value string }{
+		{"sslrootcert", c.SSLRootCert},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+	} {
+		if opt.value != "" {
+			dsn += fmt.Sprintf(" %s=%s", opt.key, opt.value)
+		}
+	}
+
+	return dsn
+}
+
+type Breaker struct {
+	Enabled           bool          `yaml:"enabled" env:"BREAKER_ENABLED" desc:"fail fast while the database is failing"`
+	FailureRate       float64       `yaml:"failure_rate" env:"BREAKER_FAILURE_RATE" desc:"share of failed or slow calls that opens the breaker"`
+	MinRequests       int           `yaml:"min_requests" env:"BREAKER_MIN_REQUESTS" desc:"calls within the window before the failure rate counts"`
+	Window            time.Duration `yaml:"window" env:"BREAKER_WINDOW" desc:"period the failure rate is measured over"`
+	SlowCallThreshold time.Duration `yaml:"slow_call_threshold" env:"BREAKER_SLOW_CALL_THRESHOLD" desc:"latency counted as a failure, 0 counts only errors"`
+	OpenTimeout       time.Duration `yaml:"open_timeout" env:"BREAKER_OPEN_TIMEOUT" desc:"time the breaker stays open before trial calls"`
+	HalfOpenProbes    int           `yaml:"half_open_probes" env:"BREAKER_HALF_OPEN_PROBES" desc:"trial calls that must succeed to close the breaker"`
+}
+
+// Config returns the circuit breaker settings.
+func (c Breaker) Config() repository.BreakerConfig {
+	return repository.BreakerConfig{
+		FailureRate:       c.FailureRate,
+		MinRequests:       c.MinRequests,
+		Window:            c.Window,
+		SlowCallThreshold: c.SlowCallThreshold,
+		OpenTimeout:       c.OpenTimeout,
+		HalfOpenProbes:    c.HalfOpenProbes,
+	}
+}
+
+type Server struct {
+	RequestTimeout   time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT" desc:"time each RPC may take"`
+	MethodTimeouts   []string      `yaml:"method_timeouts" env:"METHOD_TIMEOUTS" desc:"comma-separated Method=duration overrides of request_timeout"`
+	MinRequestBudget time.Duration `yaml:"min_request_budget" env:"MIN_REQUEST_BUDGET" desc:"least time a call must have left to start"`
+	PageSize         int           `yaml:"page_size" env:"PAGE_SIZE" desc:"likers returned per page"`
+	UserIDFormat     string        `yaml:"user_id_format" env:"USER_ID_FORMAT" desc:"numeric, uuid, ulid or regex"`
+	UserIDPattern    string        `yaml:"user_id_pattern" env:"USER_ID_PATTERN" desc:"pattern for the regex user ID format"`
+	UserIDMaxLength  int           `yaml:"user_id_max_length" env:"USER_ID_MAX_LENGTH" desc:"longest accepted user ID"`
+}
+
+type Cache struct {
+	MaxEntries   int           `yaml:"max_entries" env:"CACHE_MAX_ENTRIES" desc:"read-through cache entries, 0 disables"`
+	CountTTL     time.Duration `yaml:"count_ttl" env:"CACHE_COUNT_TTL" desc:"lifetime of cached counts"`
+	ListTTL      time.Duration `yaml:"list_ttl" env:"CACHE_LIST_TTL" desc:"lifetime of cached pages"`
+	FetchTimeout time.Duration `yaml:"fetch_timeout" env:"CACHE_FETCH_TIMEOUT" desc:"limit on a fetch shared by concurrent misses"`
+}
+
+type DecidedCache struct {
+	MaxBytes          int64         `yaml:"max_bytes" env:"DECIDED_CACHE_MAX_BYTES" desc:"decided-set cache size, 0 disables"`
+	FalsePositiveRate float64       `yaml:"false_positive_rate" env:"DECIDED_CACHE_FP_RATE" desc:"bloom filter false positive rate"`
+	MaxAge            time.Duration `yaml:"max_age" env:"DECIDED_CACHE_MAX_AGE" desc:"age at which a filter is rebuilt, 0 keeps it"`
+	LoadTimeout       time.Duration `yaml:"load_timeout" env:"DECIDED_CACHE_LOAD_TIMEOUT" desc:"limit on building a filter shared by concurrent misses"`
+}
+
+type Auth struct {
+	HS256Secret    string `yaml:"hs256_secret" env:"AUTH_HS256_SECRET" secret:"true" desc:"HMAC secret verifying HS256 tokens"`
+	RS256PublicKey string `yaml:"rs256_public_key_file" env:"AUTH_RS256_PUBLIC_KEY_FILE" desc:"PEM public key verifying RS256 tokens"`
+	JWKSFile       string `yaml:"jwks_file" env:"AUTH_JWKS_FILE" desc:"JSON Web Key Set verifying RS256 tokens"`
+	Issuer         string `yaml:"issuer" env:"AUTH_ISSUER" desc:"required token issuer"`
+	Audience       string `yaml:"audience" env:"AUTH_AUDIENCE" desc:"required token audience"`
+}
+
+// Config returns the verifier settings. Authentication is disabled when
+// none of its keys is set.
+func (c Auth) Config() auth.Config {
+	return auth.Config{
+		HMACSecret:       []byte(c.HS256Secret),
+		RSAPublicKeyFile: c.RS256PublicKey,
+		JWKSFile:         c.JWKSFile,
+		Issuer:           c.Issuer,
+		Audience:         c.Audience,
+	}
+}
+
+type TLS struct {
+	CertFile       string        `yaml:"cert_file" env:"TLS_CERT_FILE" desc:"listener certificate, empty serves plaintext"`
+	KeyFile        string        `yaml:"key_file" env:"TLS_KEY_FILE" desc:"listener certificate key"`
+	ClientCAFile   string        `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" desc:"CA verifying client certificates"`
+	ClientAuth     string        `yaml:"client_auth" env:"TLS_CLIENT_AUTH" desc:"none, request or require"`
+	AllowedSANs    []string      `yaml:"allowed_sans" env:"TLS_ALLOWED_SANS" desc:"comma-separated client SANs, each optionally =identity"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" desc:"interval between certificate reload checks"`
+}
+
+type Limiter struct {
+	Enabled          bool          `yaml:"enabled" env:"LIMITER_ENABLED" desc:"shed load with an adaptive concurrency limit"`
+	InitialLimit     int           `yaml:"initial_limit" env:"LIMITER_INITIAL_LIMIT" desc:"concurrent calls admitted at startup"`
+	MinLimit         int           `yaml:"min_limit" env:"LIMITER_MIN_LIMIT" desc:"lowest the limit may shrink to"`
+	MaxLimit         int           `yaml:"max_limit" env:"LIMITER_MAX_LIMIT" desc:"highest the limit may grow to"`
+	Backoff          float64       `yaml:"backoff" env:"LIMITER_BACKOFF" desc:"factor applied to the limit on each overloaded call"`
+	LatencyThreshold time.Duration `yaml:"latency_threshold" env:"LIMITER_LATENCY_THRESHOLD" desc:"latency counted as overload, 0 counts only failures"`
+	RejectCode       string        `yaml:"reject_code" env:"LIMITER_REJECT_CODE" desc:"resource_exhausted or unavailable"`
+	RetryAfter       time.Duration `yaml:"retry_after" env:"LIMITER_RETRY_AFTER" desc:"retry delay suggested to shed callers"`
+	Priorities       []string      `yaml:"priorities" env:"LIMITER_PRIORITIES" desc:"comma-separated Method=low|normal|high, shed lowest first"`
+}
+
+// Config parses the limiter settings.
+func (c Limiter) Config() (limiter.Config, error) {
+	cfg := limiter.Config{
+		InitialLimit:     c.InitialLimit,
+		MinLimit:         c.MinLimit,
+		MaxLimit:         c.MaxLimit,
+		Backoff:          c.Backoff,
+		LatencyThreshold: c.LatencyThreshold,
+		RetryAfter:       c.RetryAfter,
+		Priorities:       make(map[string]limiter.Priority),
+	}
+
+	switch c.RejectCode {
+	case "resource_exhausted":
+		cfg.RejectCode = codes.ResourceExhausted
+	case "unavailable":
+		cfg.RejectCode = codes.Unavailable
+	default:
+		return cfg, fmt.Errorf("unknown reject code %q, want resource_exhausted or unavailable", c.RejectCode)
+	}
+
+	for _, entry := range c.Priorities {
+		method, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return cfg, fmt.Errorf("%q is not Method=priority", entry)
+		}
+		if !isRPC(method) {
+			return cfg, fmt.Errorf("unknown method %q", method)
+		}
+		p, err := limiter.ParsePriority(value)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", method, err)
+		}
+		cfg.Priorities[method] = p
+	}
+
+	return cfg, nil
+}
+
+type Health struct {
+	CheckInterval time.Duration `yaml:"check_interval" env:"HEALTH_CHECK_INTERVAL" desc:"interval between database health probes"`
+}
+
+type Metrics struct {
+	Addr string `yaml:"addr" env:"METRICS_ADDR" desc:"admin listener address for /metrics and /descriptor, empty disables"`
+}
+
+type Logging struct {
+	Format            string  `yaml:"format" env:"LOG_FORMAT" desc:"json or text"`
+	Level             string  `yaml:"level" env:"LOG_LEVEL" desc:"debug, info, warn or error"`
+	SuccessSampleRate float64 `yaml:"success_sample_rate" env:"LOG_SUCCESS_SAMPLE_RATE" desc:"fraction of successful calls logged"`
+	RedactUserIDs     bool    `yaml:"redact_user_ids" env:"LOG_REDACT_USER_IDS" desc:"replace user IDs with a keyed hash"`
+	RedactKey         string  `yaml:"redact_key" env:"LOG_REDACT_KEY" secret:"true" desc:"key of the user ID hash, empty picks one per process"`
+}
+
+// Config returns the logger settings. Level must have been validated.
+func (c Logging) Config() logging.Config {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+
+	return logging.Config{
+		Format:        logging.Format(c.Format),
+		Level:         level,
+		RedactUserIDs: c.RedactUserIDs,
+		RedactKey:     []byte(c.RedactKey),
+	}
+}
+
+type Tracing struct {
+	Exporter     string  `yaml:"exporter" env:"TRACING_EXPORTER" desc:"otlp, stdout or file, empty disables"`
+	ServiceName  string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" desc:"service.name resource attribute"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT" desc:"OTLP gRPC collector address"`
+	OTLPInsecure bool    `yaml:"otlp_insecure" env:"TRACING_OTLP_INSECURE" desc:"connect to the collector without TLS"`
+	File         string  `yaml:"file" env:"TRACING_FILE" desc:"span output file for the file exporter"`
+	SampleRatio  float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" desc:"fraction of new traces sampled"`
+}
+
+func (c Tracing) Config() tracing.Config {
+	return tracing.Config{
+		Exporter:    tracing.Exporter(c.Exporter),
+		ServiceName: c.ServiceName,
+		Endpoint:    c.OTLPEndpoint,
+		Insecure:    c.OTLPInsecure,
+		File:        c.File,
+		SampleRatio: c.SampleRatio,
+	}
+}
+
+// TimeoutPolicy parses the request timeouts.
+func (c Server) TimeoutPolicy() (server.TimeoutPolicy, error) {
+	policy := server.TimeoutPolicy{
+		Default:   c.RequestTimeout,
+		Methods:   make(map[string]time.Duration),
+		MinBudget: c.MinRequestBudget,
+	}
+
+	for _, entry := range c.MethodTimeouts {
+		method, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return policy, fmt.Errorf("%q is not Method=duration", entry)
+		}
+		if !isRPC(method) {
+			return policy, fmt.Errorf("unknown method %q", method)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", method, err)
+		}
+		if d <= 0 {
+			return policy, fmt.Errorf("%s: timeout must be positive", method)
+		}
+		policy.Methods[method] = d
+	}
+
+	return policy, nil
+}
+
+func isRPC(method string) bool {
+	for _, m := range pb.ExploreService_ServiceDesc.Methods {
+		if m.MethodName == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Default returns the settings used where no source sets a value.
+func Default() Config {
+	return Config{
+		GRPC: GRPC{
+			Port:               50051,
+			ShutdownDrainDelay: 5 * time.Second,
+			Reflection:         true,
+			CORSMaxAge:         time.Hour,
+		},
+		HTTP: HTTP{
+			Addr: ":8080",
+		},
+		DB: DB{
+			Host:                  "localhost",
+			Port:                  5432,
+			User:                  "postgres",
+			Password:              "postgres",
+			Name:                  "explore",
+			SSLMode:               "disable",
+			MaxOpenConns:          25,
+			MaxIdleConns:          10,
+			ConnMaxLifetime:       5 * time.Minute,
+			ConnMaxIdleTime:       10 * time.Minute,
+			StatementTimeout:      server.DefaultRequestTimeout,
+			RetryMaxAttempts:      repository.DefaultRetryPolicy.MaxAttempts,
+			RetryBaseDelay:        repository.DefaultRetryPolicy.BaseDelay,
+			RetryMaxDelay:         repository.DefaultRetryPolicy.MaxDelay,
+			TimePartitionsAhead:   3,
+			ReplicaHealthInterval: 5 * time.Second,
+		},
+		Breaker: Breaker{
+			Enabled:           true,
+			FailureRate:       0.5,
+			MinRequests:       20,
+			Window:            10 * time.Second,
+			SlowCallThreshold: 2 * time.Second,
+			OpenTimeout:       5 * time.Second,
+			HalfOpenProbes:    3,
+		},
+		Server: Server{
+			RequestTimeout:   server.DefaultRequestTimeout,
+			MinRequestBudget: 10 * time.Millisecond,
+			PageSize:         repository.DefaultPageSize,
+			UserIDFormat:     string(server.UserIDNumeric),
+			UserIDMaxLength:  server.DefaultMaxUserIDLength,
+		},
+		Cache: Cache{
+			CountTTL:     5 * time.Second,
+			ListTTL:      5 * time.Second,
+			FetchTimeout: 5 * time.Second,
+		},
+		DecidedCache: DecidedCache{
+			FalsePositiveRate: 0.01,
+			MaxAge:            time.Minute,
+			LoadTimeout:       5 * time.Second,
+		},
+		TLS: TLS{
+			ClientAuth:     string(tlsutil.ClientAuthNone),
+			ReloadInterval: 30 * time.Second,
+		},
+		Limiter: Limiter{
+			Enabled:          true,
+			InitialLimit:     20,
+			MinLimit:         5,
+			MaxLimit:         200,
+			Backoff:          0.9,
+			LatencyThreshold: time.Second,
+			RejectCode:       "resource_exhausted",
+			RetryAfter:       time.Second,
+			// Reads of likes can be retried or served late; losing a
+			// decision is what users notice.
+			Priorities: []string{"PutDecision=high", "ListLikedYou=low", "ListNewLikedYou=low"},
+		},
+		Health: Health{
+			CheckInterval: 5 * time.Second,
+		},
+		Metrics: Metrics{
+			Addr: ":9090",
+		},
+		Logging: Logging{
+			Format:            string(logging.FormatJSON),
+			Level:             "info",
+			SuccessSampleRate: 1,
+		},
+		Tracing: Tracing{
+			ServiceName: "explore-service",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.GRPC.Port > 0 && c.GRPC.Port <= 65535, "grpc.port", "must be between 1 and 65535")
+	check(c.GRPC.ShutdownDrainDelay >= 0, "grpc.shutdown_drain_delay", "must not be negative")
+	check(c.GRPC.CORSMaxAge >= 0, "grpc.cors_max_age", "must not be negative")
+	for _, origin := range c.GRPC.CORSAllowedOrigins {
+		u, err := url.Parse(origin)
+		check(origin == "*" || err == nil && u.Scheme != "" && u.Host != "" && u.Path == "",
+			"grpc.cors_allowed_origins", "%q is not * or an origin like https://example.com", origin)
+	}
+
+	check(c.DB.Host != "", "db.host", "must be set")
+	check(c.DB.Port > 0 && c.DB.Port <= 65535, "db.port", "must be between 1 and 65535")
+	check(c.DB.Name != "", "db.name", "must be set")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns", "must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns,
+		"db.max_idle_conns", "must not exceed db.max_open_conns (%d)", c.DB.MaxOpenConns)
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime", "must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time", "must not be negative")
+	check(c.DB.StatementTimeout >= 0, "db.statement_timeout", "must not be negative")
+	check(c.DB.RetryMaxAttempts >= 1, "db.retry_max_attempts", "must be at least 1")
+	check(c.DB.RetryBaseDelay > 0, "db.retry_base_delay", "must be positive")
+	check(c.DB.RetryMaxDelay >= c.DB.RetryBaseDelay, "db.retry_max_delay", "must not be below db.retry_base_delay")
+	check(c.DB.HashPartitions >= 0, "db.hash_partitions", "must not be negative")
+	check(c.DB.TimePartitionsAhead >= 0, "db.time_partitions_ahead", "must not be negative")
+	check(c.DB.ReplicaHealthInterval > 0, "db.replica_health_interval", "must be positive")
+	check(len(c.DB.ShardDSNs) == 0 || len(c.DB.ReplicaDSNs) == 0,
+		"db.replica_dsns", "can't be combined with db.shard_dsns")
+
+	if c.Breaker.Enabled {
+		check(c.Breaker.FailureRate > 0 && c.Breaker.FailureRate <= 1, "breaker.failure_rate", "must be between 0 and 1")
+		check(c.Breaker.MinRequests >= 1, "breaker.min_requests", "must be at least 1")
+		check(c.Breaker.Window > 0, "breaker.window", "must be positive")
+		check(c.Breaker.SlowCallThreshold >= 0, "breaker.slow_call_threshold", "must not be negative")
+		check(c.Breaker.OpenTimeout > 0, "breaker.open_timeout", "must be positive")
+		check(c.Breaker.HalfOpenProbes >= 1, "breaker.half_open_probes", "must be at least 1")
+	}
+
+	check(c.Server.RequestTimeout > 0, "server.request_timeout", "must be positive")
+	if _, err := c.Server.TimeoutPolicy(); err != nil {
+		check(false, "server.method_timeouts", "%v", err)
+	}
+	check(c.Server.MinRequestBudget >= 0 && c.Server.MinRequestBudget < c.Server.RequestTimeout,
+		"server.min_request_budget", "must be between 0 and server.request_timeout")
+	check(c.Server.PageSize > 0 && c.Server.PageSize <= 1000, "server.page_size", "must be between 1 and 1000")
+	if _, err := server.NewUserIDValidator(server.UserIDFormat(c.Server.UserIDFormat),
+		c.Server.UserIDPattern, c.Server.UserIDMaxLength); err != nil {
+		check(false, "server.user_id_format", "%v", err)
+	}
+
+	check(c.Cache.MaxEntries >= 0, "cache.max_entries", "must not be negative")
+	check(c.Cache.MaxEntries == 0 || c.Cache.CountTTL > 0, "cache.count_ttl", "must be positive")
+	check(c.Cache.MaxEntries == 0 || c.Cache.ListTTL > 0, "cache.list_ttl", "must be positive")
+	check(c.Cache.MaxEntries == 0 || c.Cache.FetchTimeout > 0, "cache.fetch_timeout", "must be positive")
+
+	check(c.DecidedCache.MaxBytes >= 0, "decided_cache.max_bytes", "must not be negative")
+	check(c.DecidedCache.MaxBytes == 0 || c.DecidedCache.FalsePositiveRate > 0 && c.DecidedCache.FalsePositiveRate < 1,
+		"decided_cache.false_positive_rate", "must be between 0 and 1")
+	check(c.DecidedCache.MaxAge >= 0, "decided_cache.max_age", "must not be negative")
+	check(c.DecidedCache.MaxBytes == 0 || c.DecidedCache.LoadTimeout > 0, "decided_cache.load_timeout", "must be positive")
+
+	switch tlsutil.ClientAuth(c.TLS.ClientAuth) {
+	case tlsutil.ClientAuthNone, tlsutil.ClientAuthRequest, tlsutil.ClientAuthRequire:
+	default:
+		check(false, "tls.client_auth", "must be none, request or require")
+	}
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls.key_file", "must be set with tls.cert_file")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive")
+
+	if c.Limiter.Enabled {
+		check(c.Limiter.MinLimit >= 1, "limiter.min_limit", "must be at least 1")
+		check(c.Limiter.MaxLimit >= c.Limiter.MinLimit, "limiter.max_limit", "must not be below limiter.min_limit")
+		check(c.Limiter.InitialLimit >= c.Limiter.MinLimit && c.Limiter.InitialLimit <= c.Limiter.MaxLimit,
+			"limiter.initial_limit", "must be between limiter.min_limit and limiter.max_limit")
+		check(c.Limiter.Backoff > 0 && c.Limiter.Backoff < 1, "limiter.backoff", "must be between 0 and 1")
+		check(c.Limiter.LatencyThreshold >= 0, "limiter.latency_threshold", "must not be negative")
+		check(c.Limiter.RetryAfter > 0, "limiter.retry_after", "must be positive")
+		switch c.Limiter.RejectCode {
+		case "resource_exhausted", "unavailable":
+			if _, err := c.Limiter.Config(); err != nil {
+				check(false, "limiter.priorities", "%v", err)
+			}
+		default:
+			check(false, "limiter.reject_code", "must be resource_exhausted or unavailable")
+		}
+	}
+
+	check(c.Health.CheckInterval > 0, "health.check_interval", "must be positive")
+
+	switch logging.Format(c.Logging.Format) {
+	case logging.FormatJSON, logging.FormatText:
+	default:
+		check(false, "logging.format", "must be json or text")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		check(false, "logging.level", "must be debug, info, warn or error")
+	}
+	check(c.Logging.SuccessSampleRate >= 0 && c.Logging.SuccessSampleRate <= 1,
+		"logging.success_sample_rate", "must be between 0 and 1")
+
+	switch tracing.Exporter(c.Tracing.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		check(c.Tracing.File != "", "tracing.file", "must be set for the file exporter")
+	default:
+		check(false, "tracing.exporter", "must be otlp, stdout, file or empty")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio", "must be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+// Addr is the gRPC listen address.
+func (c GRPC) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// CORS is the browser access allowed to gRPC-Web and Connect.
+func (c GRPC) CORS() web.CORS {
+	return web.CORS{AllowedOrigins: c.CORSAllowedOrigins, MaxAge: c.CORSMaxAge}
+}
+
+// SANs parses AllowedSANs into the SAN to identity map tlsutil expects.
+func (c TLS) SANs() map[string]string {
+	sans := make(map[string]string)
+	for _, entry := range c.AllowedSANs {
+		san, identity, ok := strings.Cut(entry, "=")
+		if !ok {
+			identity = san
+		}
+		sans[san] = identity
+	}
+	return sans
+}
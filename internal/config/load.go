@@ -0,0 +1,257 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the configuration file when -config is not given.
+const FileEnv = "CONFIG_FILE"
+
+// Loaded is the result of Load.
+type Loaded struct {
+	Config Config
+
+	// File is the configuration file read, if any.
+	File string
+	// PrintConfig is set by -print-config: the caller should print the
+	// configuration with Print and exit instead of starting.
+	PrintConfig bool
+	// Deprecated lists deprecated environment variables that were used,
+	// with the name that replaces them.
+	Deprecated []string
+}
+
+// setting is one leaf field of Config.
+type setting struct {
+	key    string   // dotted file key and flag name, e.g. db.host
+	env    []string // environment variables, preferred name first
+	secret bool
+	desc   string
+	value  reflect.Value
+}
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and the flags in args, later sources
+// overriding earlier ones. It registers its flags on fs, which may carry
+// other flags of the caller, and parses args with it. The result is
+// validated.
+func Load(fs *flag.FlagSet, args []string) (Loaded, error) {
+	loaded := Loaded{Config: Default()}
+	settings := settingsOf(&loaded.Config)
+
+	// Flags are collected first and applied last, so they override the
+	// file and environment regardless of where -config appears.
+	type flagValue struct{ key, value string }
+	var flagValues []flagValue
+	for _, s := range settings {
+		key := s.key
+		usage := s.desc
+		if len(s.env) > 0 {
+			usage += " (" + s.env[0] + ")"
+		}
+		fs.Func(key, usage, func(v string) error {
+			flagValues = append(flagValues, flagValue{key, v})
+			return nil
+		})
+	}
+	file := fs.String("config", os.Getenv(FileEnv), "YAML or TOML configuration file ("+FileEnv+")")
+	fs.BoolVar(&loaded.PrintConfig, "print-config", false, "print the configuration with secrets redacted and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return loaded, err
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	if *file != "" {
+		values, err := readFile(*file)
+		if err != nil {
+			return loaded, err
+		}
+		for _, key := range sortedKeys(values) {
+			s, ok := byKey[key]
+			if !ok {
+				return loaded, fmt.Errorf("%s: unknown setting %q", *file, key)
+			}
+			if err := s.set(values[key]); err != nil {
+				return loaded, fmt.Errorf("%s: %s: %w", *file, key, err)
+			}
+		}
+		loaded.File = *file
+	}
+
+	for _, s := range settings {
+		for i, name := range s.env {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := s.set(v); err != nil {
+				return loaded, fmt.Errorf("%s: %w", name, err)
+			}
+			if i > 0 {
+				loaded.Deprecated = append(loaded.Deprecated, fmt.Sprintf("%s is deprecated, use %s", name, s.env[0]))
+			}
+			break
+		}
+	}
+
+	for _, f := range flagValues {
+		if err := byKey[f.key].set(f.value); err != nil {
+			return loaded, fmt.Errorf("-%s: %w", f.key, err)
+		}
+	}
+
+	return loaded, loaded.Config.Validate()
+}
+
+// settingsOf lists the leaf fields of c in declaration order.
+func settingsOf(c *Config) []setting {
+	var settings []setting
+
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeFor[time.Duration]() {
+				walk(key+".", v.Field(i))
+				continue
+			}
+
+			var env []string
+			if tag := field.Tag.Get("env"); tag != "" {
+				env = strings.Split(tag, ",")
+			}
+			settings = append(settings, setting{
+				key:    key,
+				env:    env,
+				secret: field.Tag.Get("secret") == "true",
+				desc:   field.Tag.Get("desc"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+
+	return settings
+}
+
+// set parses raw into the field. Lists are comma-separated.
+func (s setting) set(raw string) error {
+	v := s.value
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(raw)
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %s of %s", v.Type(), s.key))
+	}
+	return nil
+}
+
+// readFile decodes a YAML or TOML file, chosen by extension, into its
+// settings keyed by dotted path, in the textual form the environment uses.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unsupported configuration file type %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten(values, "", doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, doc map[string]any) error {
+	for k, v := range doc {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(values, key+".", v); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			return fmt.Errorf("%s: missing value", key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces set secrets in printed configuration.
+const redacted = "REDACTED"
+
+// Print writes c to w as a YAML configuration file, with secrets redacted.
+// Its output can be loaded back with -config once secrets are filled in.
+func Print(w io.Writer, c Config) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range settingsOf(&c) {
+		section, name, _ := strings.Cut(s.key, ".")
+		node, ok := sections[section]
+		if !ok {
+			node = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = node
+			root.Content = append(root.Content, scalar(section, "!!str"), node)
+		}
+
+		value := s.node()
+		if s.secret && !s.value.IsZero() {
+			value = scalar(redacted, "!!str")
+		}
+		if len(s.env) > 0 {
+			value.LineComment = s.env[0]
+		}
+		node.Content = append(node.Content, scalar(name, "!!str"), value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func (s setting) node() *yaml.Node {
+	switch v := s.value.Interface().(type) {
+	case string:
+		return scalar(v, "!!str")
+	case []string:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range v {
+			seq.Content = append(seq.Content, scalar(item, "!!str"))
+		}
+		return seq
+	case time.Duration:
+		return scalar(v.String(), "!!str")
+	case bool:
+		return scalar(strconv.FormatBool(v), "!!bool")
+	case float64:
+		return scalar(strconv.FormatFloat(v, 'g', -1, 64), "!!float")
+	default:
+		return scalar(fmt.Sprint(v), "!!int")
+	}
+}
+
+func scalar(value, tag string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
@@ -0,0 +1,68 @@
+// Package descriptor exposes the compiled ExploreService schema, as
+// protoc --descriptor_set_out --include_imports would write it, for tools
+// and schema registries that can't use server reflection.
+package descriptor
+
+import (
+	"net/http"
+
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Set returns explore.proto and every file it imports, each after its
+// dependencies.
+func Set() *descriptorpb.FileDescriptorSet {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(pb.File_proto_explore_proto)
+
+	return set
+}
+
+// Handler serves Set in the binary encoding, or as JSON with
+// ?format=json.
+func Handler() http.Handler {
+	set := Set()
+	binary, err := proto.Marshal(set)
+	if err != nil {
+		panic(err) // descriptors of linked-in files always marshal
+	}
+	json, err := protojson.MarshalOptions{Multiline: true}.Marshal(set)
+	if err != nil {
+		panic(err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("format") {
+		case "", "binary":
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.Header().Set("Content-Disposition", `attachment; filename="explore.binpb"`)
+			w.Write(binary)
+		case "json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(json)
+		default:
+			http.Error(w, `format must be "binary" or "json"`, http.StatusBadRequest)
+		}
+	})
+}
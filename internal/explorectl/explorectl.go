@@ -0,0 +1,329 @@
+// Package explorectl implements the explorectl command, which calls the
+// ExploreService from a shell:
+//
+//	explorectl decide [flags] <actor> <recipient> like|pass
+//	explorectl likes [flags] <recipient>
+//	explorectl new-likes [flags] <recipient>
+//	explorectl count [flags] <recipient>
+//	explorectl call [flags] <method> [request JSON]
+//
+// Listings follow next_pagination_token to the last page. call reaches any
+// RPC of the service, including ones added after this command was written.
+package explorectl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ErrUsage is returned, after the usage was printed, for bad arguments.
+var ErrUsage = errors.New("usage")
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	nargs   func(n int) bool
+	run     func(ctx context.Context, e *env, args []string) error
+}
+
+var commands = []command{
+	{
+		name: "decide", args: "<actor> <recipient> like|pass",
+		summary: "record whether actor likes recipient",
+		nargs:   func(n int) bool { return n == 3 },
+		run:     decide,
+	},
+	{
+		name: "likes", args: "<recipient>",
+		summary: "list everyone who liked recipient",
+		nargs:   func(n int) bool { return n == 1 },
+		run: func(ctx context.Context, e *env, args []string) error {
+			return listLikes(ctx, e, args[0], e.client.ListLikedYou)
+		},
+	},
+	{
+		name: "new-likes", args: "<recipient>",
+		summary: "list likers recipient hasn't decided on",
+		nargs:   func(n int) bool { return n == 1 },
+		run: func(ctx context.Context, e *env, args []string) error {
+			return listLikes(ctx, e, args[0], e.client.ListNewLikedYou)
+		},
+	},
+	{
+		name: "count", args: "<recipient>",
+		summary: "count everyone who liked recipient",
+		nargs:   func(n int) bool { return n == 1 },
+		run:     count,
+	},
+	{
+		name: "call", args: "<method> [request JSON, - for stdin]",
+		summary: "call any RPC with a JSON request and print the JSON response",
+		nargs:   func(n int) bool { return n == 1 || n == 2 },
+		run:     call,
+	},
+}
+
+// env is what a command runs with.
+type env struct {
+	conn    *grpc.ClientConn
+	client  pb.ExploreServiceClient
+	timeout time.Duration
+	format  string
+	limit   int
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// Run runs the command line args, without the program name. Errors from
+// the server are returned with their code, reason and details spelled out.
+func Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return ErrUsage
+		}
+		return nil
+	}
+
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == args[0] })
+	if i < 0 {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return ErrUsage
+	}
+	cmd := commands[i]
+
+	fs := flag.NewFlagSet("explorectl "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: explorectl %s [flags] %s\n\n%s.\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	var conn connFlags
+	conn.register(fs)
+	e := &env{stdin: stdin, stdout: stdout}
+	fs.StringVar(&e.format, "o", "table", "output format: "+strings.Join(Formats, ", "))
+	fs.IntVar(&e.limit, "limit", 0, "stop listings after this many rows, 0 for all")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return ErrUsage
+	}
+	if !cmd.nargs(fs.NArg()) || !slices.Contains(Formats, e.format) {
+		fs.Usage()
+		return ErrUsage
+	}
+
+	profile, err := conn.resolve(fs)
+	if err != nil {
+		return err
+	}
+	e.timeout = profile.Timeout
+	e.conn, err = profile.dial()
+	if err != nil {
+		return err
+	}
+	defer e.conn.Close()
+	e.client = pb.NewExploreServiceClient(e.conn)
+
+	return describe(cmd.run(ctx, e, fs.Args()))
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: explorectl <command> [flags] [args]\n\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w, "\nRun explorectl <command> -h for its flags.")
+}
+
+func decide(ctx context.Context, e *env, args []string) error {
+	var liked bool
+	switch args[2] {
+	case "like":
+		liked = true
+	case "pass":
+	default:
+		return fmt.Errorf("decision must be like or pass, not %q", args[2])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	resp, err := e.client.PutDecision(ctx, &pb.PutDecisionRequest{
+		ActorUserId:     args[0],
+		RecipientUserId: args[1],
+		LikedRecipient:  liked,
+	})
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(e.format, e.stdout, false, "mutual_likes")
+	if err != nil {
+		return err
+	}
+	if err := p.row(resp.GetMutualLikes()); err != nil {
+		return err
+	}
+	return p.close()
+}
+
+func count(ctx context.Context, e *env, args []string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	resp, err := e.client.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: args[0]})
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(e.format, e.stdout, false, "count")
+	if err != nil {
+		return err
+	}
+	if err := p.row(resp.GetCount()); err != nil {
+		return err
+	}
+	return p.close()
+}
+
+type listFunc func(ctx context.Context, req *pb.ListLikedYouRequest, opts ...grpc.CallOption) (*pb.ListLikedYouResponse, error)
+
+// listLikes prints every page of list for recipient, each fetched under
+// its own timeout, until the last page or the -limit.
+func listLikes(ctx context.Context, e *env, recipient string, list listFunc) error {
+	p, err := newPrinter(e.format, e.stdout, true, "actor_id", "liked_at", "unix_timestamp")
+	if err != nil {
+		return err
+	}
+
+	printed := 0
+	req := &pb.ListLikedYouRequest{RecipientUserId: recipient}
+	for {
+		pageCtx, cancel := context.WithTimeout(ctx, e.timeout)
+		resp, err := list(pageCtx, req)
+		cancel()
+		if err != nil {
+			// print what was fetched before reporting the error
+			p.close()
+			return err
+		}
+
+		for _, l := range resp.GetLikers() {
+			if e.limit > 0 && printed == e.limit {
+				return p.close()
+			}
+			ts := int64(l.GetUnixTimestamp())
+			if err := p.row(l.GetActorId(), time.Unix(ts, 0).UTC().Format(time.RFC3339), ts); err != nil {
+				return err
+			}
+			printed++
+		}
+
+		token := resp.GetNextPaginationToken()
+		if token == "" || e.limit > 0 && printed == e.limit {
+			return p.close()
+		}
+		req.PaginationToken = &token
+	}
+}
+
+// call invokes any unary RPC of the service by name, building the request
+// from JSON with its descriptor, so it needs no change for new RPCs.
+func call(ctx context.Context, e *env, args []string) error {
+	service := pb.File_proto_explore_proto.Services().ByName("ExploreService")
+	method := service.Methods().ByName(protoreflect.Name(args[0]))
+	if method == nil {
+		var names []string
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			names = append(names, string(methods.Get(i).Name()))
+		}
+		return fmt.Errorf("unknown method %q, want one of %s", args[0], strings.Join(names, ", "))
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return fmt.Errorf("%s is a streaming RPC, which call doesn't support", args[0])
+	}
+
+	body := []byte("{}")
+	if len(args) == 2 {
+		body = []byte(args[1])
+		if args[1] == "-" {
+			var err error
+			if body, err = io.ReadAll(e.stdin); err != nil {
+				return err
+			}
+		}
+	}
+	req := dynamicpb.NewMessage(method.Input())
+	if err := protojson.Unmarshal(body, req); err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	resp := dynamicpb.NewMessage(method.Output())
+	fullMethod := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+	if err := e.conn.Invoke(ctx, fullMethod, req, resp); err != nil {
+		return err
+	}
+
+	out, err := protojson.MarshalOptions{Multiline: true, UseProtoNames: true, EmitUnpopulated: true}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.stdout, "%s\n", out)
+	return err
+}
+
+// describe spells out the code, reason, field violations, metadata and
+// retry delay of an error from the server.
+func describe(err error) error {
+	if err == nil {
+		return nil
+	}
+	d, ok := apierror.Parse(err)
+	if !ok {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", d.Code, d.Message)
+	if d.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", d.Reason)
+	}
+	for _, v := range d.FieldViolations {
+		fmt.Fprintf(&b, "\n  %s: %s", v.Field, v.Description)
+	}
+	keys := make([]string, 0, len(d.Metadata))
+	for k := range d.Metadata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n  %s=%s", k, d.Metadata[k])
+	}
+	if d.RetryDelay > 0 {
+		fmt.Fprintf(&b, "\n  retry after %s", d.RetryDelay)
+	}
+	return errors.New(b.String())
+}
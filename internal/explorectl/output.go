@@ -0,0 +1,135 @@
+package explorectl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Formats are the values of -o.
+var Formats = []string{"table", "json", "csv"}
+
+// printer writes rows of columns as they come, so long listings start
+// printing before the last page arrives.
+type printer interface {
+	row(values ...any) error
+	close() error
+}
+
+// newPrinter returns a printer for format. A listing prints as an array in
+// JSON; otherwise its one row prints as an object.
+func newPrinter(format string, w io.Writer, listing bool, columns ...string) (printer, error) {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		p := &tablePrinter{w: tw}
+		return p, p.row(anys(columns)...)
+	case "json":
+		return &jsonPrinter{w: w, columns: columns, listing: listing}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		return &csvPrinter{w: cw}, cw.Write(columns)
+	}
+	return nil, fmt.Errorf("unknown output format %q, want table, json or csv", format)
+}
+
+type tablePrinter struct {
+	w *tabwriter.Writer
+}
+
+func (p *tablePrinter) row(values ...any) error {
+	for i, v := range values {
+		sep := "\t"
+		if i == len(values)-1 {
+			sep = "\n"
+		}
+		if _, err := fmt.Fprint(p.w, v, sep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *tablePrinter) close() error {
+	return p.w.Flush()
+}
+
+// jsonPrinter writes objects keyed by column, in column order.
+type jsonPrinter struct {
+	w       io.Writer
+	columns []string
+	listing bool
+	rows    int
+}
+
+func (p *jsonPrinter) row(values ...any) error {
+	var obj bytes.Buffer
+	obj.WriteByte('{')
+	for i, v := range values {
+		key, _ := json.Marshal(p.columns[i])
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			obj.WriteByte(',')
+		}
+		obj.Write(key)
+		obj.WriteByte(':')
+		obj.Write(value)
+	}
+	obj.WriteByte('}')
+
+	prefix := ""
+	switch {
+	case !p.listing:
+		obj.WriteByte('\n')
+	case p.rows == 0:
+		prefix = "[\n  "
+	default:
+		prefix = ",\n  "
+	}
+	p.rows++
+	_, err := fmt.Fprint(p.w, prefix, obj.String())
+	return err
+}
+
+func (p *jsonPrinter) close() error {
+	switch {
+	case !p.listing:
+		return nil
+	case p.rows == 0:
+		_, err := io.WriteString(p.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(p.w, "\n]\n")
+	return err
+}
+
+type csvPrinter struct {
+	w *csv.Writer
+}
+
+func (p *csvPrinter) row(values ...any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = fmt.Sprint(v)
+	}
+	return p.w.Write(record)
+}
+
+func (p *csvPrinter) close() error {
+	p.w.Flush()
+	return p.w.Error()
+}
+
+func anys(values []string) []any {
+	out := make([]any, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
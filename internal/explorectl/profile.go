@@ -0,0 +1,210 @@
+package explorectl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/tlsutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// TokenEnv holds the bearer token when neither a flag nor the profile sets
+// one.
+const TokenEnv = "EXPLORE_TOKEN"
+
+// Profile is how to reach a server. Flags of the same name override it.
+type Profile struct {
+	Addr       string        `yaml:"addr"`
+	TLS        bool          `yaml:"tls"`
+	CAFile     string        `yaml:"ca_file"`
+	CertFile   string        `yaml:"cert_file"`
+	KeyFile    string        `yaml:"key_file"`
+	ServerName string        `yaml:"server_name"`
+	Token      string        `yaml:"token"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// profileFile is the profile file, e.g.
+//
+//	current: prod
+//	profiles:
+//	  local:
+//	    addr: localhost:50051
+//	  prod:
+//	    addr: explore.example.com:443
+//	    tls: true
+//	    token: eyJhbGciOi...
+type profileFile struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+func defaultProfile() Profile {
+	return Profile{Addr: "localhost:50051", Timeout: 10 * time.Second}
+}
+
+// DefaultConfigFile is where profiles are read from when -config isn't
+// given: explorectl/config.yaml in the user's configuration directory.
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "explorectl", "config.yaml")
+}
+
+// connFlags are the connection flags every command takes.
+type connFlags struct {
+	config  string
+	profile string
+	set     Profile
+}
+
+func (f *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.config, "config", DefaultConfigFile(), "profile file")
+	fs.StringVar(&f.profile, "profile", "", "profile to use instead of the file's current one")
+	fs.StringVar(&f.set.Addr, "addr", "", "server address (default localhost:50051)")
+	fs.BoolVar(&f.set.TLS, "tls", false, "connect over TLS")
+	fs.StringVar(&f.set.CAFile, "ca_file", "", "CA certificates verifying the server, implies -tls")
+	fs.StringVar(&f.set.CertFile, "cert_file", "", "client certificate for mTLS, implies -tls")
+	fs.StringVar(&f.set.KeyFile, "key_file", "", "client certificate key")
+	fs.StringVar(&f.set.ServerName, "server_name", "", "name verified in the server certificate")
+	fs.StringVar(&f.set.Token, "token", "", "bearer token, default $"+TokenEnv)
+	fs.DurationVar(&f.set.Timeout, "timeout", 0, "deadline of each call (default 10s)")
+}
+
+// resolve returns the profile selected by the flags with the flags that
+// were given applied over it.
+func (f *connFlags) resolve(fs *flag.FlagSet) (Profile, error) {
+	p := defaultProfile()
+
+	selected, err := f.load()
+	if err != nil {
+		return Profile{}, err
+	}
+	if selected != nil {
+		if selected.Addr != "" {
+			p.Addr = selected.Addr
+		}
+		if selected.Timeout != 0 {
+			p.Timeout = selected.Timeout
+		}
+		p.TLS, p.CAFile, p.CertFile, p.KeyFile = selected.TLS, selected.CAFile, selected.CertFile, selected.KeyFile
+		p.ServerName, p.Token = selected.ServerName, selected.Token
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "addr":
+			p.Addr = f.set.Addr
+		case "tls":
+			p.TLS = f.set.TLS
+		case "ca_file":
+			p.CAFile = f.set.CAFile
+		case "cert_file":
+			p.CertFile = f.set.CertFile
+		case "key_file":
+			p.KeyFile = f.set.KeyFile
+		case "server_name":
+			p.ServerName = f.set.ServerName
+		case "token":
+			p.Token = f.set.Token
+		case "timeout":
+			p.Timeout = f.set.Timeout
+		}
+	})
+
+	if p.Token == "" {
+		p.Token = os.Getenv(TokenEnv)
+	}
+	if p.Timeout <= 0 {
+		return Profile{}, errors.New("timeout must be positive")
+	}
+	return p, nil
+}
+
+// load reads the selected profile. A missing default file selects none,
+// but a missing named profile or explicitly given file is an error.
+func (f *connFlags) load() (*Profile, error) {
+	if f.config == "" {
+		if f.profile != "" {
+			return nil, fmt.Errorf("profile %q: no profile file", f.profile)
+		}
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(f.config)
+	if errors.Is(err, os.ErrNotExist) && f.config == DefaultConfigFile() && f.profile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file profileFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.config, err)
+	}
+
+	name := f.profile
+	if name == "" {
+		name = file.Current
+	}
+	if name == "" {
+		return nil, nil
+	}
+	p, ok := file.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: no profile %q", f.config, name)
+	}
+	return &p, nil
+}
+
+// dial connects to the profile's server. Calls carry the token, if any, as
+// a bearer token.
+func (p Profile) dial() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if p.TLS || p.CAFile != "" || p.CertFile != "" {
+		cfg := &tls.Config{ServerName: p.ServerName, MinVersion: tls.VersionTLS12}
+		if p.CAFile != "" {
+			pool, err := tlsutil.LoadCertPool(p.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs = pool
+		}
+		if p.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("client certificate: %w", err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if p.Token != "" {
+		opts = append(opts, grpc.WithUnaryInterceptor(bearer(p.Token)))
+	}
+	return grpc.NewClient(p.Addr, opts...)
+}
+
+func bearer(token string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,81 @@
+// Package gateway serves the ExploreService as JSON over HTTP. Each route
+// comes from the google.api.http annotation of its RPC in explore.proto,
+// e.g. GET /v1/users/{recipient_user_id}/likes for ListLikedYou, and
+// messages are encoded with protojson using the proto field names.
+package gateway
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fleimkeipa/grpc-example/internal/inprocess"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// forwardedHeaders are passed to the RPC as metadata under their own name.
+// Authorization is always forwarded; other headers only with a
+// Grpc-Metadata- prefix.
+var forwardedHeaders = map[string]bool{
+	logging.RequestIDHeader: true,
+	"x-read-your-writes":    true,
+}
+
+// NewHandler returns a handler serving srv over HTTP. Calls run through
+// interceptors, outermost first, exactly as over gRPC, so authentication,
+// load shedding, logging and metrics apply to both alike. A failed call's
+// gRPC code sets the HTTP status, e.g. INVALID_ARGUMENT gives 400 and
+// UNAVAILABLE 503, and the body is the google.rpc.Status with its details.
+func NewHandler(ctx context.Context, srv pb.ExploreServiceServer, interceptors ...grpc.UnaryServerInterceptor) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:   true,
+				EmitUnpopulated: true,
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{
+				DiscardUnknown: true,
+			},
+		}),
+		runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+			if k := strings.ToLower(key); forwardedHeaders[k] {
+				return k, true
+			}
+			return runtime.DefaultHeaderMatcher(key)
+		}),
+		runtime.WithOutgoingHeaderMatcher(func(key string) (string, bool) {
+			if forwardedHeaders[key] {
+				return key, true
+			}
+			return runtime.MetadataHeaderPrefix + key, true
+		}),
+		runtime.WithErrorHandler(errorHandler),
+	)
+
+	if err := pb.RegisterExploreServiceHandlerServer(ctx, mux, inprocess.New(srv, interceptors...)); err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
+
+// errorHandler writes err as runtime.DefaultHTTPErrorHandler does, adding
+// a Retry-After header when the error carries a RetryInfo.
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler,
+	w http.ResponseWriter, r *http.Request, err error) {
+	if delay, ok := apierror.RetryDelay(err); ok {
+		// Retry-After counts whole seconds; round up so clients never
+		// retry early.
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
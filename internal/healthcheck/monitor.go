@@ -0,0 +1,113 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Probe checks one dependency and returns nil when it is usable.
+type Probe func(ctx context.Context) error
+
+// conclusiveError is a probe failure that needs no confirmation.
+type conclusiveError struct {
+	err error
+}
+
+func (e *conclusiveError) Error() string { return e.err.Error() }
+func (e *conclusiveError) Unwrap() error { return e.err }
+
+// Conclusive marks err as a probe failure that is already confirmed, such
+// as an open circuit breaker: it flips the status to NOT_SERVING without
+// waiting for FailureThreshold.
+func Conclusive(err error) error {
+	return &conclusiveError{err: err}
+}
+
+// Monitor runs a probe in the background and publishes the result as the
+// serving status of the given services on a grpc.health.v1 server.
+type Monitor struct {
+	hs       *health.Server
+	probe    Probe
+	services []string
+	recheck  chan struct{}
+
+	// FailureThreshold is how many consecutive failures flip the status to
+	// NOT_SERVING. A single success flips it back.
+	FailureThreshold int
+	// Timeout bounds each probe.
+	Timeout time.Duration
+}
+
+// NewMonitor publishes probe's result for services. The empty service name
+// reports overall server health.
+func NewMonitor(hs *health.Server, probe Probe, services ...string) *Monitor {
+	return &Monitor{
+		hs:               hs,
+		probe:            probe,
+		services:         services,
+		recheck:          make(chan struct{}, 1),
+		FailureThreshold: 3,
+		Timeout:          2 * time.Second,
+	}
+}
+
+// Run probes immediately and then on every interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := true
+	failures := 0
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, m.Timeout)
+		err := m.probe(probeCtx)
+		cancel()
+
+		if err == nil {
+			failures = 0
+		} else {
+			failures++
+		}
+
+		switch {
+		case err == nil && !serving:
+			slog.Info("dependencies recovered, serving", "component", "health")
+			serving = true
+		case err != nil && serving && (failures >= m.FailureThreshold || errors.As(err, new(*conclusiveError))):
+			slog.Error("probe failing, not serving", "component", "health", "failures", failures, "error", err)
+			serving = false
+		}
+		m.set(serving)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.recheck:
+		}
+	}
+}
+
+// Recheck makes Run probe now rather than at the next interval. It doesn't
+// block.
+func (m *Monitor) Recheck() {
+	select {
+	case m.recheck <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Monitor) set(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range m.services {
+		m.hs.SetServingStatus(service, status)
+	}
+}
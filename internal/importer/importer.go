@@ -0,0 +1,354 @@
+// Package importer bulk-loads decisions with their original timestamps,
+// e.g. history from a legacy system, into the decisions table.
+//
+// Records are read from JSONL or CSV, validated as PutDecision validates
+// requests and written in batches, each COPYed into a temporary table and
+// merged into decisions in one transaction per shard. Batches are upserts
+// on (actor_user_id, recipient_user_id) like PutDecision, so a pair keeps
+// one row whose decision is the most recent one. A checkpoint file records
+// how far the import got after every batch, and a re-run resumes there.
+//
+// Batches bypass the caches of running servers, which live in each
+// server's memory and only learn of writes made through that server. Until
+// their entries expire, servers keep serving the counts and first pages
+// they cached before the import, and a server's decided-set filters keep
+// treating imported pairs as undecided until they are rebuilt after
+// DECIDED_CACHE_MAX_AGE, or never when that is zero. Restart the servers,
+// or wait that long, for imported decisions to show everywhere.
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+)
+
+// DefaultBatchSize is the number of records written per transaction
+// unless Config says otherwise.
+const DefaultBatchSize = 5000
+
+// Config controls an import.
+type Config struct {
+	Format Format
+	// UserIDs checks and canonicalises user IDs, as the server does.
+	UserIDs server.UserIDValidator
+	// BatchSize is the number of records COPYed per transaction.
+	BatchSize int
+	// MaxErrors is how many invalid records are logged and skipped before
+	// the import fails. Zero fails on the first.
+	MaxErrors int64
+	// DryRun reads and validates the whole file without connecting to the
+	// database or reading and writing the checkpoint.
+	DryRun bool
+	// Checkpoint is the file progress is saved to after every batch and
+	// resumed from. Empty disables checkpoints.
+	Checkpoint string
+	// ProgressInterval is how often progress is logged.
+	ProgressInterval time.Duration
+}
+
+// Counts tally the records of an import.
+type Counts struct {
+	// Records is the number of valid records read.
+	Records int64 `json:"records"`
+	// Invalid is the number of records skipped as invalid.
+	Invalid int64 `json:"invalid"`
+	// Inserted and Updated count the pairs that were new and that already
+	// had a row. Records repeating a pair within a batch are merged, so
+	// they can add up to less than Records.
+	Inserted int64 `json:"inserted"`
+	Updated  int64 `json:"updated"`
+}
+
+// Checkpoint is saved after every batch: the records up to Position have
+// been written.
+type Checkpoint struct {
+	Source string `json:"source"`
+	// Size is the size of the source when it is a regular file, so that a
+	// checkpoint isn't resumed into a different file.
+	Size int64 `json:"size,omitempty"`
+	Position
+	Counts
+	Done bool `json:"done"`
+}
+
+// Report is the outcome of Run.
+type Report struct {
+	Counts
+	Position
+	// Resumed is set when the import carried on from a checkpoint.
+	Resumed bool
+	Elapsed time.Duration
+}
+
+// Importer writes decisions to the primary database or to the shards of a
+// sharded deployment.
+type Importer struct {
+	cfg      Config
+	dbs      []*sql.DB
+	shardMap repository.ShardMap
+	locked   []bool
+}
+
+// New returns an importer writing to dbs, the shards of shardMap, which is
+// ignored for a single database. dbs is nil for a dry run.
+func New(dbs []*sql.DB, shardMap repository.ShardMap, cfg Config) (*Importer, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.ProgressInterval <= 0 {
+		cfg.ProgressInterval = 10 * time.Second
+	}
+	if cfg.UserIDs == nil {
+		return nil, errors.New("importer needs a user ID validator")
+	}
+	if !cfg.DryRun && len(dbs) == 0 {
+		return nil, errors.New("importer needs a database unless it is a dry run")
+	}
+	if len(dbs) > 1 {
+		if err := shardMap.Validate(len(dbs)); err != nil {
+			return nil, err
+		}
+	}
+
+	im := &Importer{cfg: cfg, dbs: dbs, shardMap: shardMap}
+	for _, db := range dbs {
+		locked, err := repository.NeedsLockedUpsert(db)
+		if err != nil {
+			return nil, err
+		}
+		im.locked = append(im.locked, locked)
+	}
+
+	return im, nil
+}
+
+// Run imports the records of src, named name in the checkpoint. When src
+// is an *os.File its size is used to report progress as a percentage and
+// to check the checkpoint belongs to it. Cancelling ctx stops the import
+// after the batch in flight, which a re-run resumes after.
+func (im *Importer) Run(ctx context.Context, name string, src io.Reader) (Report, error) {
+	start := time.Now()
+	cp := Checkpoint{Source: name}
+	if f, ok := src.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			cp.Size = info.Size()
+		}
+	}
+
+	var report Report
+	if im.cfg.Checkpoint != "" && !im.cfg.DryRun {
+		saved, ok, err := loadCheckpoint(im.cfg.Checkpoint)
+		if err != nil {
+			return report, err
+		}
+		if ok {
+			if saved.Source != cp.Source || saved.Size != cp.Size {
+				return report, fmt.Errorf("checkpoint %s is for %s (%d bytes), not %s (%d bytes); remove it to start over",
+					im.cfg.Checkpoint, saved.Source, saved.Size, cp.Source, cp.Size)
+			}
+			cp = saved
+			report.Resumed = true
+			slog.Info("resuming import", "component", "import", "source", name,
+				"line", cp.Line, "records", cp.Records)
+		}
+	}
+
+	r, err := NewReader(src, im.cfg.Format, im.cfg.UserIDs, cp.Position)
+	if err != nil {
+		return report, err
+	}
+
+	progress := newProgress(start, cp)
+	batch := make([]Record, 0, im.cfg.BatchSize)
+
+	// flush writes the batch and saves the checkpoint past it.
+	flush := func() error {
+		if len(batch) > 0 && !im.cfg.DryRun {
+			// An interrupted import finishes the batch in flight.
+			inserted, updated, err := im.write(context.WithoutCancel(ctx), batch)
+			if err != nil {
+				return fmt.Errorf("failed to write the batch from line %d: %w", batch[0].Line, err)
+			}
+			cp.Inserted += inserted
+			cp.Updated += updated
+		}
+		batch = batch[:0]
+
+		cp.Position = r.Pos()
+		if im.cfg.Checkpoint != "" && !im.cfg.DryRun {
+			if err := saveCheckpoint(im.cfg.Checkpoint, cp); err != nil {
+				return err
+			}
+		}
+		if time.Since(progress.last) >= im.cfg.ProgressInterval {
+			progress.log(cp, im.cfg.DryRun)
+		}
+		if err := ctx.Err(); err != nil && !cp.Done {
+			return fmt.Errorf("import interrupted after line %d: %w", cp.Line, err)
+		}
+		return nil
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			cp.Invalid++
+			slog.Warn("invalid record", "component", "import", "line", recErr.Line, "error", recErr.Err)
+			// A dry run reports every invalid record before failing.
+			if cp.Invalid > im.cfg.MaxErrors && !im.cfg.DryRun {
+				return im.report(report, cp, start), fmt.Errorf("more than %d invalid records, the last at %w", im.cfg.MaxErrors, recErr)
+			}
+			continue
+		}
+		if err != nil {
+			return im.report(report, cp, start), fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		cp.Records++
+		batch = append(batch, rec)
+		if len(batch) == im.cfg.BatchSize {
+			if err := flush(); err != nil {
+				return im.report(report, cp, start), err
+			}
+		}
+	}
+
+	cp.Done = true
+	if err := flush(); err != nil {
+		return im.report(report, cp, start), err
+	}
+	report = im.report(report, cp, start)
+
+	msg := "import finished"
+	if im.cfg.DryRun {
+		msg = "dry run finished"
+	}
+	slog.Info(msg, "component", "import", "source", name, "records", cp.Records, "invalid", cp.Invalid,
+		"inserted", cp.Inserted, "updated", cp.Updated, "elapsed", report.Elapsed.Round(time.Millisecond))
+
+	if cp.Invalid > im.cfg.MaxErrors {
+		return report, fmt.Errorf("%d invalid records, more than the %d allowed", cp.Invalid, im.cfg.MaxErrors)
+	}
+	return report, nil
+}
+
+func (im *Importer) report(report Report, cp Checkpoint, start time.Time) Report {
+	report.Counts = cp.Counts
+	report.Position = cp.Position
+	report.Elapsed = time.Since(start)
+	return report
+}
+
+// write merges batch into the shards owning its recipients. A shard that
+// fails leaves the others committed; re-running the batch is harmless as
+// the merge is idempotent.
+func (im *Importer) write(ctx context.Context, batch []Record) (inserted, updated int64, err error) {
+	byShard := make([][]Record, len(im.dbs))
+	for _, rec := range batch {
+		shard := 0
+		if len(im.dbs) > 1 {
+			shard = im.shardMap.ShardFor(rec.RecipientUserId)
+		}
+		byShard[shard] = append(byShard[shard], rec)
+	}
+
+	for shard, records := range byShard {
+		if len(records) == 0 {
+			continue
+		}
+		n, u, err := writeBatch(ctx, im.dbs[shard], im.locked[shard], records)
+		if err != nil {
+			if len(im.dbs) > 1 {
+				err = fmt.Errorf("shard %d: %w", shard, err)
+			}
+			return inserted, updated, err
+		}
+		inserted += n
+		updated += u
+	}
+
+	return inserted, updated, nil
+}
+
+// progress logs how far the import has got.
+type progress struct {
+	start   time.Time
+	last    time.Time
+	size    int64
+	offset0 int64 // offset resumed from, for the rate of this run
+}
+
+func newProgress(start time.Time, cp Checkpoint) *progress {
+	return &progress{start: start, last: start, size: cp.Size, offset0: cp.Offset}
+}
+
+func (p *progress) log(cp Checkpoint, dryRun bool) {
+	p.last = time.Now()
+	elapsed := p.last.Sub(p.start)
+
+	args := []any{"component", "import", "line", cp.Line, "records", cp.Records, "invalid", cp.Invalid,
+		"elapsed", elapsed.Round(time.Second)}
+	if !dryRun {
+		args = append(args, "inserted", cp.Inserted, "updated", cp.Updated)
+	}
+	if p.size > 0 {
+		args = append(args, "percent", fmt.Sprintf("%.1f", 100*float64(cp.Offset)/float64(p.size)))
+		if read := cp.Offset - p.offset0; read > 0 {
+			left := time.Duration(float64(elapsed) * float64(p.size-cp.Offset) / float64(read))
+			args = append(args, "eta", left.Round(time.Second))
+		}
+	}
+	slog.Info("import progress", args...)
+}
+
+// loadCheckpoint reads the checkpoint at path, reporting false if there is
+// none yet.
+func loadCheckpoint(path string) (Checkpoint, bool, error) {
+	var cp Checkpoint
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, false, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+
+	return cp, true, nil
+}
+
+// saveCheckpoint replaces the checkpoint at path through a rename, so a
+// crash leaves either the old or the new one.
+func saveCheckpoint(path string, cp Checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	return nil
+}
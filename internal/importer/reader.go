@@ -0,0 +1,380 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+)
+
+// Format is the encoding of an import file.
+type Format string
+
+const (
+	// JSONL is one JSON object per line with the column names as keys.
+	JSONL Format = "jsonl"
+	// CSV has a header row naming the columns, in any order.
+	CSV Format = "csv"
+)
+
+// columns are the fields of a record. updated_at is optional and defaults
+// to created_at.
+var columns = []string{"actor_user_id", "recipient_user_id", "liked_recipient", "created_at", "updated_at"}
+
+// FormatOf picks the format from the extension of path.
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return JSONL, nil
+	case ".csv":
+		return CSV, nil
+	}
+	return "", fmt.Errorf("can't tell the format of %q from its extension, want .jsonl or .csv", path)
+}
+
+// Position is how far into a file records have been read: the byte offset
+// and line number just after the last one.
+type Position struct {
+	Offset int64 `json:"offset"`
+	Line   int64 `json:"line"`
+}
+
+// Record is a valid decision read from a file, with its own timestamps.
+type Record struct {
+	models.Decision
+	// Line is where the record starts, for error messages.
+	Line int64
+}
+
+// RecordError reports an invalid record. Reading can carry on after it.
+type RecordError struct {
+	Line int64
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads and validates decisions from a JSONL or CSV file.
+type Reader struct {
+	format Format
+	ids    server.UserIDValidator
+	now    time.Time
+
+	br  *bufio.Reader
+	pos Position
+
+	// index maps CSV columns to their place in a row.
+	index map[string]int
+}
+
+// NewReader reads records of format from src, skipping to from, which is
+// the zero Position or one returned by Pos. src is sought to from when it
+// is an io.Seeker and read through otherwise. User IDs are checked and
+// canonicalised with ids, as PutDecision does.
+func NewReader(src io.Reader, format Format, ids server.UserIDValidator, from Position) (*Reader, error) {
+	r := &Reader{
+		format: format,
+		ids:    ids,
+		now:    time.Now(),
+		br:     bufio.NewReaderSize(src, 1<<20),
+	}
+
+	switch format {
+	case JSONL:
+	case CSV:
+		if err := r.readHeader(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q, want %s or %s", format, JSONL, CSV)
+	}
+
+	if from.Offset > r.pos.Offset {
+		if s, ok := src.(io.Seeker); ok {
+			if _, err := s.Seek(from.Offset, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to seek to offset %d: %w", from.Offset, err)
+			}
+			r.br.Reset(src)
+		} else if _, err := io.CopyN(io.Discard, r.br, from.Offset-r.pos.Offset); err != nil {
+			return nil, fmt.Errorf("failed to skip to offset %d: %w", from.Offset, err)
+		}
+		r.pos = from
+	}
+
+	return r, nil
+}
+
+// readHeader reads the CSV header row and maps the columns it names.
+func (r *Reader) readHeader() error {
+	line, err := r.readRow()
+	if err != nil {
+		return fmt.Errorf("failed to read the CSV header: %w", err)
+	}
+
+	header, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	r.index = make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !slices.Contains(columns, name) {
+			return fmt.Errorf("unknown CSV column %q, want %s", name, strings.Join(columns, ", "))
+		}
+		if _, dup := r.index[name]; dup {
+			return fmt.Errorf("CSV column %q appears twice", name)
+		}
+		r.index[name] = i
+	}
+	for _, name := range columns[:4] {
+		if _, ok := r.index[name]; !ok {
+			return fmt.Errorf("CSV header lacks the %s column", name)
+		}
+	}
+
+	return nil
+}
+
+// Pos returns the position just after the last record returned by Next.
+func (r *Reader) Pos() Position {
+	return r.pos
+}
+
+// Next returns the next record, a *RecordError for an invalid one, io.EOF
+// after the last or any other error reading the file.
+func (r *Reader) Next() (Record, error) {
+	if r.format == CSV {
+		return r.nextCSV()
+	}
+	return r.nextJSONL()
+}
+
+// fields are the raw values of a record, "" when absent.
+type fields struct {
+	actor, recipient, liked, createdAt, updatedAt string
+}
+
+func (r *Reader) nextJSONL() (Record, error) {
+	for {
+		line, err := r.readRow()
+		if err != nil {
+			return Record{}, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		f, err := parseJSON(line)
+		if err != nil {
+			return Record{}, &RecordError{Line: r.pos.Line, Err: err}
+		}
+		return r.record(r.pos.Line, f)
+	}
+}
+
+// jsonRecord accepts timestamps as RFC 3339 strings or Unix seconds.
+type jsonRecord struct {
+	ActorUserID     string          `json:"actor_user_id"`
+	RecipientUserID string          `json:"recipient_user_id"`
+	LikedRecipient  *bool           `json:"liked_recipient"`
+	CreatedAt       json.RawMessage `json:"created_at"`
+	UpdatedAt       json.RawMessage `json:"updated_at"`
+}
+
+func parseJSON(line []byte) (fields, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	var j jsonRecord
+	if err := dec.Decode(&j); err != nil {
+		return fields{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if dec.More() {
+		return fields{}, errors.New("invalid JSON: more than one value on the line")
+	}
+
+	f := fields{actor: j.ActorUserID, recipient: j.RecipientUserID}
+	if j.LikedRecipient != nil {
+		f.liked = strconv.FormatBool(*j.LikedRecipient)
+	}
+	var err error
+	if f.createdAt, err = jsonTime(j.CreatedAt); err != nil {
+		return fields{}, fmt.Errorf("created_at: %w", err)
+	}
+	if f.updatedAt, err = jsonTime(j.UpdatedAt); err != nil {
+		return fields{}, fmt.Errorf("updated_at: %w", err)
+	}
+	return f, nil
+}
+
+func jsonTime(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	if raw[0] != '"' {
+		return string(raw), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+func (r *Reader) nextCSV() (Record, error) {
+	for {
+		line := r.pos.Line + 1
+		row, err := r.readRow()
+		if err != nil {
+			return Record{}, err
+		}
+		if len(bytes.TrimSpace(row)) == 0 {
+			continue
+		}
+
+		values, err := csv.NewReader(bytes.NewReader(row)).Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				err = parseErr.Err
+			}
+			return Record{}, &RecordError{Line: line, Err: err}
+		}
+		if len(values) != len(r.index) {
+			return Record{}, &RecordError{Line: line, Err: fmt.Errorf("has %d fields, want %d", len(values), len(r.index))}
+		}
+
+		get := func(name string) string {
+			if i, ok := r.index[name]; ok {
+				return strings.TrimSpace(values[i])
+			}
+			return ""
+		}
+		return r.record(line, fields{
+			actor:     get("actor_user_id"),
+			recipient: get("recipient_user_id"),
+			liked:     get("liked_recipient"),
+			createdAt: get("created_at"),
+			updatedAt: get("updated_at"),
+		})
+	}
+}
+
+// readRow reads the next line, or for CSV the lines up to the end of a
+// quoted field spanning several, and advances the position past them. It
+// returns io.EOF only when nothing is left.
+func (r *Reader) readRow() ([]byte, error) {
+	var row []byte
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if len(line) > 0 {
+			r.pos.Offset += int64(len(line))
+			r.pos.Line++
+			row = append(row, line...)
+		}
+		switch {
+		case err == io.EOF && len(row) == 0:
+			return nil, io.EOF
+		case err == io.EOF:
+			return row, nil
+		case err != nil:
+			return nil, err
+		}
+
+		// Quotes, including doubled ones inside a quoted field, pair up
+		// unless a quoted field continues on the next line.
+		if r.format != CSV || bytes.Count(row, []byte{'"'})%2 == 0 {
+			return row, nil
+		}
+	}
+}
+
+// record validates f as PutDecision would and adds the timestamps.
+func (r *Reader) record(line int64, f fields) (Record, error) {
+	fail := func(format string, args ...any) (Record, error) {
+		return Record{}, &RecordError{Line: line, Err: fmt.Errorf(format, args...)}
+	}
+
+	actor, err := r.ids.Validate(f.actor)
+	if err != nil {
+		return fail("actor_user_id: %v", err)
+	}
+	recipient, err := r.ids.Validate(f.recipient)
+	if err != nil {
+		return fail("recipient_user_id: %v", err)
+	}
+	if actor == recipient {
+		return fail("actor_user_id and recipient_user_id must differ")
+	}
+
+	if f.liked == "" {
+		return fail("liked_recipient is required")
+	}
+	liked, err := strconv.ParseBool(f.liked)
+	if err != nil {
+		return fail("liked_recipient: must be true or false")
+	}
+
+	if f.createdAt == "" {
+		return fail("created_at is required")
+	}
+	createdAt, err := parseTime(f.createdAt)
+	if err != nil {
+		return fail("created_at: %v", err)
+	}
+	updatedAt := createdAt
+	if f.updatedAt != "" {
+		if updatedAt, err = parseTime(f.updatedAt); err != nil {
+			return fail("updated_at: %v", err)
+		}
+	}
+	if updatedAt.Before(createdAt) {
+		return fail("updated_at is before created_at")
+	}
+	// A future created_at would keep a liker at the top of every listing.
+	if updatedAt.After(r.now) {
+		return fail("timestamps must not be in the future")
+	}
+
+	return Record{
+		Decision: models.Decision{
+			ActorUserId:     actor,
+			RecipientUserId: recipient,
+			LikedRecipient:  liked,
+			CreatedAt:       createdAt,
+			UpdatedAt:       updatedAt,
+		},
+		Line: line,
+	}, nil
+}
+
+// parseTime reads an RFC 3339 timestamp or whole Unix seconds.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errors.New("must be an RFC 3339 timestamp or Unix seconds")
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,142 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// stagingTable holds a batch for the length of its transaction.
+const stagingTable = `
+	CREATE TEMP TABLE decisions_import (
+		seq BIGINT NOT NULL,
+		actor_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		liked_recipient BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	) ON COMMIT DROP
+	`
+
+// staged is one row per pair of the batch: the latest decision, the later
+// record winning a tie, and the earliest created_at.
+const staged = `
+	SELECT DISTINCT ON (actor_user_id, recipient_user_id)
+		actor_user_id, recipient_user_id, liked_recipient,
+		MIN(created_at) OVER (PARTITION BY actor_user_id, recipient_user_id) AS created_at,
+		updated_at
+	FROM decisions_import
+	ORDER BY actor_user_id, recipient_user_id, updated_at DESC, seq DESC
+	`
+
+// merged sets the columns of a pair after merging the staged row, whose
+// alias is the argument, into the existing row d as putDecision does with
+// live decisions: the pair keeps its first created_at and takes the
+// decision made last. Merging the same batch twice changes nothing, so a
+// batch can be retried after a failure.
+const merged = `
+		liked_recipient = CASE WHEN d.updated_at IS NULL OR %[1]s.updated_at >= d.updated_at
+			THEN %[1]s.liked_recipient ELSE d.liked_recipient END,
+		created_at = LEAST(d.created_at, %[1]s.created_at),
+		updated_at = GREATEST(d.updated_at, %[1]s.updated_at)
+	`
+
+// upsertStaged merges the batch with ON CONFLICT and counts the pairs that
+// were inserted, which xmax is 0 for, and updated.
+var upsertStaged = fmt.Sprintf(`
+	WITH upserted AS (
+		INSERT INTO decisions AS d (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+		SELECT * FROM (%s) s
+		ON CONFLICT (actor_user_id, recipient_user_id)
+		DO UPDATE SET %s
+		RETURNING xmax = 0 AS inserted
+	)
+	SELECT COUNT(*) FILTER (WHERE inserted), COUNT(*) FILTER (WHERE NOT inserted)
+	FROM upserted
+	`, staged, fmt.Sprintf(merged, "EXCLUDED"))
+
+// Without a unique key on the pair, as with time sub-partitions, the batch
+// is merged by an update and an insert under a lock that conflicts with the
+// row writes of putDecision, so neither can add a pair the other is adding.
+const lockDecisions = `LOCK TABLE decisions IN SHARE ROW EXCLUSIVE MODE`
+
+var updateStaged = fmt.Sprintf(`
+	UPDATE decisions d
+	SET %s
+	FROM (%s) s
+	WHERE d.actor_user_id = s.actor_user_id
+	  AND d.recipient_user_id = s.recipient_user_id
+	`, fmt.Sprintf(merged, "s"), staged)
+
+var insertStaged = fmt.Sprintf(`
+	INSERT INTO decisions (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+	SELECT * FROM (%s) s
+	WHERE NOT EXISTS (
+		SELECT 1 FROM decisions d
+		WHERE d.actor_user_id = s.actor_user_id
+		  AND d.recipient_user_id = s.recipient_user_id
+	)
+	`, staged)
+
+// writeBatch COPYs records into the staging table and merges them into
+// decisions in one transaction.
+func writeBatch(ctx context.Context, db *sql.DB, locked bool, records []Record) (inserted, updated int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, stagingTable); err != nil {
+		return 0, 0, fmt.Errorf("failed to create staging table: %w", err)
+	}
+	if err := copyRecords(ctx, tx, records); err != nil {
+		return 0, 0, err
+	}
+
+	if locked {
+		if _, err := tx.ExecContext(ctx, lockDecisions); err != nil {
+			return 0, 0, fmt.Errorf("failed to lock decisions: %w", err)
+		}
+		res, err := tx.ExecContext(ctx, updateStaged)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to update decisions: %w", err)
+		}
+		updated, _ = res.RowsAffected()
+		res, err = tx.ExecContext(ctx, insertStaged)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to insert decisions: %w", err)
+		}
+		inserted, _ = res.RowsAffected()
+	} else if err := tx.QueryRowContext(ctx, upsertStaged).Scan(&inserted, &updated); err != nil {
+		return 0, 0, fmt.Errorf("failed to upsert decisions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return inserted, updated, nil
+}
+
+func copyRecords(ctx context.Context, tx *sql.Tx, records []Record) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("decisions_import",
+		"seq", "actor_user_id", "recipient_user_id", "liked_recipient", "created_at", "updated_at"))
+	if err != nil {
+		return fmt.Errorf("failed to start COPY: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, rec := range records {
+		_, err := stmt.ExecContext(ctx, i, rec.ActorUserId, rec.RecipientUserId, rec.LikedRecipient, rec.CreatedAt, rec.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to COPY: %w", err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("failed to COPY: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+// Package inprocess calls an ExploreService implementation the way the
+// gRPC server does, through the method handlers of its service descriptor
+// and a chain of unary interceptors, for transports other than gRPC.
+package inprocess
+
+import (
+	"context"
+
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Server serves srv through interceptors, so they get the request and the
+// full method name, e.g. /explore.ExploreService/PutDecision, they expect.
+type Server struct {
+	pb.UnimplementedExploreServiceServer
+
+	srv         pb.ExploreServiceServer
+	interceptor grpc.UnaryServerInterceptor
+	handlers    map[string]grpc.MethodHandler
+}
+
+// New returns srv wrapped in interceptors, outermost first.
+func New(srv pb.ExploreServiceServer, interceptors ...grpc.UnaryServerInterceptor) *Server {
+	handlers := make(map[string]grpc.MethodHandler)
+	for _, m := range pb.ExploreService_ServiceDesc.Methods {
+		handlers[m.MethodName] = m.Handler
+	}
+
+	return &Server{srv: srv, interceptor: Chain(interceptors), handlers: handlers}
+}
+
+func (s *Server) invoke(ctx context.Context, method string, req proto.Message) (any, error) {
+	dec := func(m any) error {
+		proto.Merge(m.(proto.Message), req)
+		return nil
+	}
+	return s.handlers[method](s.srv, ctx, dec, s.interceptor)
+}
+
+func (s *Server) ListLikedYou(ctx context.Context, req *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	resp, err := s.invoke(ctx, "ListLikedYou", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListLikedYouResponse), nil
+}
+
+func (s *Server) ListNewLikedYou(ctx context.Context, req *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	resp, err := s.invoke(ctx, "ListNewLikedYou", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.ListLikedYouResponse), nil
+}
+
+func (s *Server) CountLikedYou(ctx context.Context, req *pb.CountLikedYouRequest) (*pb.CountLikedYouResponse, error) {
+	resp, err := s.invoke(ctx, "CountLikedYou", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.CountLikedYouResponse), nil
+}
+
+func (s *Server) PutDecision(ctx context.Context, req *pb.PutDecisionRequest) (*pb.PutDecisionResponse, error) {
+	resp, err := s.invoke(ctx, "PutDecision", req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.PutDecisionResponse), nil
+}
+
+// Chain combines interceptors into one, outermost first, as
+// grpc.ChainUnaryInterceptor does for a server.
+func Chain(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
@@ -0,0 +1,259 @@
+// Package limiter sheds calls the server can't finish in time before they
+// queue for a database connection. It keeps an adaptive limit on
+// concurrent calls: the limit grows by one per limit's worth of calls that
+// complete quickly and shrinks by a fixed factor whenever a call shows
+// overload (AIMD), so it settles near the concurrency the database can
+// actually serve.
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Priority orders methods for shedding. Lower priorities may only use part
+// of the limit, so they are rejected first as load rises and higher
+// priorities keep the rest.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// shares is the part of the limit calls of each priority may fill.
+var shares = [...]float64{
+	PriorityLow:    0.6,
+	PriorityNormal: 0.8,
+	PriorityHigh:   1,
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ParsePriority parses "low", "normal" or "high".
+func ParsePriority(s string) (Priority, error) {
+	for p := PriorityLow; p <= PriorityHigh; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q, want low, normal or high", s)
+}
+
+// Config tunes a Limiter.
+type Config struct {
+	// InitialLimit is the limit before any call has completed. It is
+	// clamped to [MinLimit, MaxLimit].
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// Backoff multiplies the limit each time a call shows overload.
+	Backoff float64
+	// LatencyThreshold is the latency above which a successful call still
+	// counts as overload. Zero only counts failures.
+	LatencyThreshold time.Duration
+	// RejectCode is returned to shed calls: ResourceExhausted, or
+	// Unavailable for clients whose retry policy only retries that.
+	RejectCode codes.Code
+	// RetryAfter is suggested to shed callers in a RetryInfo.
+	RetryAfter time.Duration
+	// Priorities maps method names, e.g. "PutDecision", to their priority.
+	// Other methods are PriorityNormal.
+	Priorities map[string]Priority
+}
+
+// Observer is told about every shed call.
+type Observer interface {
+	CallShed(method, priority string)
+}
+
+// Option customises a Limiter.
+type Option func(*Limiter)
+
+// WithObserver reports shed calls to o.
+func WithObserver(o Observer) Option {
+	return func(l *Limiter) {
+		l.observer = o
+	}
+}
+
+// ExemptMethods never limits methods whose full name starts with one of
+// prefixes, such as health checks that must answer under load.
+func ExemptMethods(prefixes ...string) Option {
+	return func(l *Limiter) {
+		l.exempt = append(l.exempt, prefixes...)
+	}
+}
+
+type noopObserver struct{}
+
+func (noopObserver) CallShed(string, string) {}
+
+// Limiter is an adaptive concurrency limit shared by all calls.
+type Limiter struct {
+	cfg      Config
+	observer Observer
+	exempt   []string
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+}
+
+func New(cfg Config, opts ...Option) *Limiter {
+	if cfg.MinLimit < 1 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+	if cfg.RejectCode == codes.OK {
+		cfg.RejectCode = codes.ResourceExhausted
+	}
+
+	l := &Limiter{
+		cfg:      cfg,
+		observer: noopObserver{},
+		limit:    float64(min(max(cfg.InitialLimit, cfg.MinLimit), cfg.MaxLimit)),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Limit returns the current limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// InFlight returns the number of admitted calls not yet finished.
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inflight
+}
+
+// Acquire admits a call of priority p if the part of the limit p may use
+// isn't full. The admitted caller must call done once with whether the
+// call showed overload.
+func (l *Limiter) Acquire(p Priority) (done func(overloaded bool), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= max(1, int(l.limit*shares[p])) {
+		return nil, false
+	}
+	l.inflight++
+
+	var once sync.Once
+	return func(overloaded bool) {
+		once.Do(func() { l.release(overloaded) })
+	}, true
+}
+
+func (l *Limiter) release(overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+
+	switch {
+	case overloaded:
+		l.limit = max(l.limit*l.cfg.Backoff, float64(l.cfg.MinLimit))
+	case inflight*2 >= int(l.limit):
+		// Only grow while the limit is actually in use, so a quiet period
+		// doesn't leave a limit far above what was ever tested.
+		l.limit = min(l.limit+1/l.limit, float64(l.cfg.MaxLimit))
+	}
+}
+
+// Priority returns the priority of the RPC with the given full name.
+func (l *Limiter) Priority(fullMethod string) Priority {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if p, ok := l.cfg.Priorities[method]; ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// UnaryServerInterceptor rejects calls over their priority's share of the
+// limit with cfg.RejectCode and a RetryInfo, and adapts the limit to the
+// outcome of the others. It should run before anything expensive, such as
+// token verification.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp any, err error) {
+		for _, prefix := range l.exempt {
+			if strings.HasPrefix(info.FullMethod, prefix) {
+				return handler(ctx, req)
+			}
+		}
+
+		p := l.Priority(info.FullMethod)
+		done, ok := l.Acquire(p)
+		if !ok {
+			l.observer.CallShed(info.FullMethod, p.String())
+			return nil, apierror.New(l.cfg.RejectCode, apierror.CodeReason(l.cfg.RejectCode),
+				"server overloaded, retry later",
+				apierror.WithMetadata(map[string]string{"priority": p.String()}),
+				apierror.WithRetryDelay(l.cfg.RetryAfter))
+		}
+
+		// Deferred so that a panicking handler, recovered further out,
+		// still frees its slot.
+		start := time.Now()
+		defer func() { done(l.overloaded(ctx, time.Since(start), err)) }()
+
+		return handler(ctx, req)
+	}
+}
+
+// overloaded reports whether a call that took latency and returned err
+// suggests the server is past its capacity. Client errors don't, and nor
+// do calls an open circuit breaker rejected without trying the database.
+// A deadline only counts when the server's own timeout fired: a caller
+// whose deadline has passed, or that was turned away for having too
+// little of it left, says nothing about the server.
+func (l *Limiter) overloaded(ctx context.Context, latency time.Duration, err error) bool {
+	if errors.Is(err, repository.ErrCircuitOpen) {
+		return false
+	}
+	if err != nil {
+		switch code, _ := rpcerror.Classify(ctx, err); code {
+		case codes.DeadlineExceeded:
+			return rpcerror.ServerTimeout(ctx, err)
+		case codes.ResourceExhausted, codes.Unavailable:
+			return true
+		}
+	}
+	return l.cfg.LatencyThreshold > 0 && latency > l.cfg.LatencyThreshold
+}
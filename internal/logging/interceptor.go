@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key a request ID is read from and echoed
+// back in.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLen bounds client-supplied request IDs before they are
+// trusted into every log line.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the current call, or "" outside one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// UnaryServerInterceptor gives every call a request ID, taken from the
+// x-request-id metadata or generated, and a logger carrying it, the method,
+// the peer address and the user IDs in the request. Failed calls are always
+// logged; successful ones are logged at successSampleRate (0 to 1).
+func UnaryServerInterceptor(base *slog.Logger, successSampleRate float64) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		requestID := incomingRequestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, requestID))
+
+		attrs := []any{"request_id", requestID, "method", info.FullMethod}
+		if p, ok := peer.FromContext(ctx); ok {
+			attrs = append(attrs, "peer", p.Addr.String())
+		}
+		if r, ok := req.(interface{ GetActorUserId() string }); ok {
+			attrs = append(attrs, KeyActorUserID, r.GetActorUserId())
+		}
+		if r, ok := req.(interface{ GetRecipientUserId() string }); ok {
+			attrs = append(attrs, KeyRecipientUserID, r.GetRecipientUserId())
+		}
+		logger := base.With(attrs...)
+
+		ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+		ctx = WithLogger(ctx, logger)
+
+		resp, err := handler(ctx, req)
+
+		code := status.Code(err)
+		if code == codes.OK && mathrand.Float64() >= successSampleRate {
+			return resp, err
+		}
+
+		callAttrs := []any{"code", code.String(), "duration", time.Since(start)}
+		if err != nil {
+			callAttrs = append(callAttrs, "error", status.Convert(err).Message())
+		}
+		logger.Log(ctx, levelFor(code), "rpc finished", callAttrs...)
+
+		return resp, err
+	}
+}
+
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(RequestIDHeader); len(v) > 0 && v[0] != "" && len(v[0]) <= maxRequestIDLen {
+			return v[0]
+		}
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// levelFor logs server-side failures as errors and caller mistakes as
+// warnings.
+func levelFor(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics owns the server's Prometheus registry: RPC latency and status
+// codes, database pool statistics and business counters.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	rpcDuration *prometheus.HistogramVec
+	rpcTotal    *prometheus.CounterVec
+	decisions   *prometheus.CounterVec
+	overwrites  prometheus.Counter
+	matches     prometheus.Counter
+	shed        *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "explore_rpc_duration_seconds",
+			Help:    "Latency of unary RPCs by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		rpcTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "explore_rpc_requests_total",
+			Help: "Unary RPCs handled by method and status code.",
+		}, []string{"method", "code"}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "explore_decisions_total",
+			Help: "Decisions stored, by decision (like or pass).",
+		}, []string{"decision"}),
+		overwrites: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "explore_decision_overwrites_total",
+			Help: "Decisions that replaced an earlier decision for the same pair.",
+		}),
+		matches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "explore_matches_total",
+			Help: "New likes that completed a mutual like.",
+		}),
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "explore_limiter_shed_total",
+			Help: "Calls rejected by the concurrency limiter, by method and priority.",
+		}, []string{"method", "priority"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.rpcDuration,
+		m.rpcTotal,
+		m.decisions,
+		m.overwrites,
+		m.matches,
+		m.shed,
+	)
+
+	return m
+}
+
+// Registry exposes the registry for collectors owned by other packages.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// WatchDB exports db's pool statistics (open, in-use and idle connections,
+// waits) as go_sql_* gauges labelled db_name=name.
+func (m *Metrics) WatchDB(name string, db *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// LimiterState is what WatchLimiter exports of a concurrency limiter.
+type LimiterState interface {
+	Limit() int
+	InFlight() int
+}
+
+// WatchLimiter exports l's current limit and admitted calls as the
+// explore_limiter_limit and explore_limiter_in_flight gauges.
+func (m *Metrics) WatchLimiter(l LimiterState) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "explore_limiter_limit",
+			Help: "Concurrent calls the adaptive limiter currently admits.",
+		}, func() float64 { return float64(l.Limit()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "explore_limiter_in_flight",
+			Help: "Calls admitted by the limiter and not yet finished.",
+		}, func() float64 { return float64(l.InFlight()) }),
+	)
+}
+
+// DecidedCacheState is what WatchDecidedCache exports of a decided cache.
+type DecidedCacheState interface {
+	Stats() repository.DecidedCacheStats
+}
+
+// WatchDecidedCache exports c's size and activity as explore_decided_cache_*
+// metrics.
+func (m *Metrics) WatchDecidedCache(c DecidedCacheState) {
+	counter := func(name, help string, value func(repository.DecidedCacheStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+			func() float64 { return float64(value(c.Stats())) })
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "explore_decided_cache_entries",
+			Help: "Actors with a cached decided-set filter.",
+		}, func() float64 { return float64(c.Stats().Entries) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "explore_decided_cache_bytes",
+			Help: "Memory held by cached decided-set filters.",
+		}, func() float64 { return float64(c.Stats().Bytes) }),
+		counter("explore_decided_cache_lookups_total", "Recipients checked against a decided-set filter.",
+			func(s repository.DecidedCacheStats) int64 { return s.Lookups }),
+		counter("explore_decided_cache_undecided_total", "Lookups answered definitely undecided without a query.",
+			func(s repository.DecidedCacheStats) int64 { return s.Undecided }),
+		counter("explore_decided_cache_confirmations_total", "Lookups confirmed with a query after a filter hit.",
+			func(s repository.DecidedCacheStats) int64 { return s.Confirmations }),
+		counter("explore_decided_cache_loads_total", "Decided-set filters built from the decisions table.",
+			func(s repository.DecidedCacheStats) int64 { return s.Loads }),
+		counter("explore_decided_cache_evictions_total", "Decided-set filters evicted to stay within the memory budget.",
+			func(s repository.DecidedCacheStats) int64 { return s.Evictions }),
+		counter("explore_decided_cache_rebuilds_total", "Decided-set filters dropped for outgrowing their capacity.",
+			func(s repository.DecidedCacheStats) int64 { return s.Rebuilds }),
+		counter("explore_decided_cache_expirations_total", "Decided-set filters rebuilt for being older than their maximum age.",
+			func(s repository.DecidedCacheStats) int64 { return s.Expirations }),
+	)
+}
+
+// UnaryServerInterceptor records the latency and status code of every call.
+// It should run first in the chain so rejections by later interceptors are
+// counted too.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		m.rpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		m.rpcTotal.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+		return resp, err
+	}
+}
+
+// DecisionRecorded implements server.Observer.
+func (m *Metrics) DecisionRecorded(liked, overwrote bool) {
+	if liked {
+		m.decisions.WithLabelValues("like").Inc()
+	} else {
+		m.decisions.WithLabelValues("pass").Inc()
+	}
+
+	if overwrote {
+		m.overwrites.Inc()
+	}
+}
+
+// MatchCreated implements server.Observer.
+func (m *Metrics) MatchCreated() {
+	m.matches.Inc()
+}
+
+// CallShed implements limiter.Observer.
+func (m *Metrics) CallShed(method, priority string) {
+	m.shed.WithLabelValues(method, priority).Inc()
+}
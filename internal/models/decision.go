@@ -8,4 +8,7 @@ type Decision struct {
 	LikedRecipient  bool
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
+	// PreviouslyLiked is set by PutDecision when the decision replaced an
+	// earlier like of the same recipient.
+	PreviouslyLiked bool
 }
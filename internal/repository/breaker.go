@@ -0,0 +1,372 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen matches, with errors.Is, the error CircuitBreaker returns
+// for calls it rejects without trying the database.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed passes every call through and watches the outcome.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects every call until OpenTimeout has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few trial calls through to decide between
+	// closing again and reopening.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerConfig tunes a CircuitBreaker.
+type BreakerConfig struct {
+	// FailureRate is the share of failed calls within Window that opens
+	// the breaker, once at least MinRequests calls were seen.
+	FailureRate float64
+	MinRequests int
+	Window      time.Duration
+	// SlowCallThreshold counts successful calls slower than this as
+	// failures. Zero only counts errors.
+	SlowCallThreshold time.Duration
+	// OpenTimeout is how long the breaker stays open before trying the
+	// database again.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is both how many trial calls may run at once while
+	// half-open and how many must succeed to close.
+	HalfOpenProbes int
+	// OnStateChange, if set, is called after every transition, outside of
+	// the breaker's lock.
+	OnStateChange func(from, to BreakerState)
+}
+
+// Guarded is what a CircuitBreaker protects: both kinds of repository.
+type Guarded interface {
+	Decisions
+	DecidedSource
+	Check(ctx context.Context) error
+}
+
+// breakerBuckets is how many slices Window is counted in; the oldest is
+// dropped as a whole, so the rate covers between 90% and 100% of Window.
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	start    time.Time
+	calls    int
+	failures int
+}
+
+// CircuitBreaker fails calls fast with Unavailable while the database
+// behind it is failing, instead of letting each one wait for a connection
+// or a timeout. It opens when too many calls within a window fail or are
+// slow, stays open for OpenTimeout, then lets HalfOpenProbes trial calls
+// through and closes if they all succeed. Cancelled calls and errors that
+// show the database answered, such as a unique violation, don't count.
+type CircuitBreaker struct {
+	next Guarded
+	cfg  BreakerConfig
+
+	mu        sync.Mutex
+	state     BreakerState
+	epoch     int // bumped on every transition so stale outcomes are ignored
+	openedAt  time.Time
+	buckets   [breakerBuckets]breakerBucket
+	trials    int
+	successes int
+}
+
+func NewCircuitBreaker(next Guarded, cfg BreakerConfig) *CircuitBreaker {
+	if cfg.FailureRate <= 0 || cfg.FailureRate > 1 {
+		cfg.FailureRate = 0.5
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 3
+	}
+
+	return &CircuitBreaker{next: next, cfg: cfg}
+}
+
+// State returns the current state. An open breaker whose OpenTimeout has
+// passed reports open until a call arrives to try the database.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// openError is returned for rejected calls. It is an Unavailable status
+// with a RetryInfo of the time left until the breaker half-opens.
+type openError struct {
+	retryAfter time.Duration
+}
+
+func (e *openError) Error() string {
+	return ErrCircuitOpen.Error()
+}
+
+func (e *openError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+func (e *openError) GRPCStatus() *status.Status {
+	return status.Convert(apierror.New(codes.Unavailable, apierror.ReasonUnavailable,
+		"database unavailable, retry later", apierror.WithRetryDelay(e.retryAfter)))
+}
+
+// do runs fn if the breaker admits the call and records its outcome.
+func (b *CircuitBreaker) do(ctx context.Context, fn func() error) error {
+	epoch, err := b.admit()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = fn()
+	// A call its caller gave up on, by cancelling or by its deadline
+	// running out, says nothing about the database unless it failed.
+	abandoned := errors.Is(ctx.Err(), context.Canceled) || rpcerror.CallerExpired(ctx)
+	b.record(epoch, b.failed(ctx, time.Since(start), err), abandoned)
+
+	return err
+}
+
+func (b *CircuitBreaker) admit() (int, error) {
+	b.mu.Lock()
+
+	switch b.state {
+	case BreakerOpen:
+		if left := b.cfg.OpenTimeout - time.Since(b.openedAt); left > 0 {
+			b.mu.Unlock()
+			return 0, &openError{retryAfter: left}
+		}
+		notify := b.transition(BreakerHalfOpen)
+		b.trials++
+		epoch := b.epoch
+		b.mu.Unlock()
+		notify()
+		return epoch, nil
+
+	case BreakerHalfOpen:
+		if b.trials >= b.cfg.HalfOpenProbes {
+			b.mu.Unlock()
+			return 0, &openError{retryAfter: b.cfg.OpenTimeout}
+		}
+		b.trials++
+	}
+
+	epoch := b.epoch
+	b.mu.Unlock()
+	return epoch, nil
+}
+
+// failed reports whether a call that took latency and returned err counts
+// against the database. A deadline only does when the server's own timeout
+// fired, so callers with short deadlines can't open the breaker on a
+// healthy database.
+func (b *CircuitBreaker) failed(ctx context.Context, latency time.Duration, err error) bool {
+	if err != nil {
+		switch code, _ := rpcerror.Classify(ctx, err); code {
+		case codes.Unavailable:
+			return true
+		case codes.DeadlineExceeded:
+			return rpcerror.ServerTimeout(ctx, err)
+		}
+	}
+	return b.cfg.SlowCallThreshold > 0 && latency > b.cfg.SlowCallThreshold
+}
+
+func (b *CircuitBreaker) record(epoch int, failed, abandoned bool) {
+	b.mu.Lock()
+
+	if epoch != b.epoch {
+		// admitted before the last transition; says nothing about now
+		b.mu.Unlock()
+		return
+	}
+
+	notify := func() {}
+	switch b.state {
+	case BreakerClosed:
+		if abandoned && !failed {
+			break
+		}
+		bucket := b.bucket(time.Now())
+		bucket.calls++
+		if failed {
+			bucket.failures++
+		}
+		if calls, failures := b.counts(time.Now()); calls >= b.cfg.MinRequests &&
+			float64(failures) >= b.cfg.FailureRate*float64(calls) {
+			notify = b.transition(BreakerOpen)
+		}
+
+	case BreakerHalfOpen:
+		b.trials--
+		switch {
+		case failed:
+			notify = b.transition(BreakerOpen)
+		case abandoned:
+		default:
+			b.successes++
+			if b.successes >= b.cfg.HalfOpenProbes {
+				notify = b.transition(BreakerClosed)
+			}
+		}
+	}
+
+	b.mu.Unlock()
+	notify()
+}
+
+// bucket returns the bucket counting calls at now, recycling the one it
+// replaces in the ring.
+func (b *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := b.cfg.Window / breakerBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[start.UnixNano()/int64(width)%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// counts sums the buckets still within the window at now.
+func (b *CircuitBreaker) counts(now time.Time) (calls, failures int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			calls += bucket.calls
+			failures += bucket.failures
+		}
+	}
+	return calls, failures
+}
+
+// transition moves to state with b.mu held. It returns a function that
+// logs the change and calls OnStateChange, to be called after unlocking.
+func (b *CircuitBreaker) transition(to BreakerState) func() {
+	from := b.state
+	b.state = to
+	b.epoch++
+	b.trials = 0
+	b.successes = 0
+
+	switch to {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+
+	return func() {
+		level := slog.LevelInfo
+		if to == BreakerOpen {
+			level = slog.LevelError
+		}
+		slog.Log(context.Background(), level, "database circuit breaker "+to.String(),
+			"component", "breaker", "from", from.String(), "to", to.String())
+
+		if b.cfg.OnStateChange != nil {
+			b.cfg.OnStateChange(from, to)
+		}
+	}
+}
+
+func (b *CircuitBreaker) PutDecision(ctx context.Context, d *models.Decision) error {
+	return b.do(ctx, func() error {
+		return b.next.PutDecision(ctx, d)
+	})
+}
+
+func (b *CircuitBreaker) ListLikedYou(ctx context.Context, recipientID string, paginationToken string) (decisions []models.Decision, next string, err error) {
+	err = b.do(ctx, func() error {
+		decisions, next, err = b.next.ListLikedYou(ctx, recipientID, paginationToken)
+		return err
+	})
+	return decisions, next, err
+}
+
+func (b *CircuitBreaker) ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) (decisions []models.Decision, next string, err error) {
+	err = b.do(ctx, func() error {
+		decisions, next, err = b.next.ListNewLikedYou(ctx, recipientID, paginationToken)
+		return err
+	})
+	return decisions, next, err
+}
+
+func (b *CircuitBreaker) IsMutual(ctx context.Context, actorID, recipientID string) (mutual bool, err error) {
+	err = b.do(ctx, func() error {
+		mutual, err = b.next.IsMutual(ctx, actorID, recipientID)
+		return err
+	})
+	return mutual, err
+}
+
+func (b *CircuitBreaker) CountLikedYou(ctx context.Context, recipientID string) (count int64, err error) {
+	err = b.do(ctx, func() error {
+		count, err = b.next.CountLikedYou(ctx, recipientID)
+		return err
+	})
+	return count, err
+}
+
+func (b *CircuitBreaker) DecidedRecipients(ctx context.Context, actorID string) (recipients []string, err error) {
+	err = b.do(ctx, func() error {
+		recipients, err = b.next.DecidedRecipients(ctx, actorID)
+		return err
+	})
+	return recipients, err
+}
+
+func (b *CircuitBreaker) FilterDecided(ctx context.Context, actorID string, recipientIDs []string) (decided map[string]bool, err error) {
+	err = b.do(ctx, func() error {
+		decided, err = b.next.FilterDecided(ctx, actorID, recipientIDs)
+		return err
+	})
+	return decided, err
+}
+
+// Check probes the database through the breaker: it fails fast with
+// ErrCircuitOpen while open and serves as a trial call once half-open, so
+// health checks alone can close the breaker when no traffic arrives.
+func (b *CircuitBreaker) Check(ctx context.Context) error {
+	return b.do(ctx, func() error {
+		return b.next.Check(ctx)
+	})
+}
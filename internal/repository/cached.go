@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/cache"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig tunes the read-through cache in front of a Decisions store.
+type CacheConfig struct {
+	// MaxEntries bounds the in-process LRU.
+	MaxEntries int
+	// CountTTL is how long CountLikedYou results are served from cache.
+	CountTTL time.Duration
+	// ListTTL is how long first pages of ListLikedYou and ListNewLikedYou
+	// are served from cache.
+	ListTTL time.Duration
+	// FetchTimeout bounds a shared fetch, which outlives the callers
+	// waiting for it giving up.
+	FetchTimeout time.Duration
+	// Remote is an optional shared cache consulted on local misses.
+	Remote cache.Remote
+}
+
+// CachedDecisionRepository is a read-through cache for the hottest recipient
+// reads: CountLikedYou and the first page of both list queries. Writes go
+// straight through and invalidate every key they can affect.
+type CachedDecisionRepository struct {
+	next   Decisions
+	cfg    CacheConfig
+	local  *cache.LRU[string, cachedValue]
+	group  singleflight.Group
+	remote cache.Remote
+
+	// versions is a striped invalidation counter. A load only populates the
+	// cache if its key's stripe was not bumped while it ran, so a read that
+	// started before a PutDecision can never be stored after it.
+	versions [1024]atomic.Uint64
+}
+
+type cachedValue struct {
+	payload []byte
+	expires time.Time
+}
+
+type cachedPage struct {
+	Decisions []models.Decision `json:"decisions"`
+	NextToken string            `json:"next_token"`
+}
+
+func NewCachedDecisionRepository(next Decisions, cfg CacheConfig) *CachedDecisionRepository {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10_000
+	}
+	if cfg.CountTTL <= 0 {
+		cfg.CountTTL = 5 * time.Second
+	}
+	if cfg.ListTTL <= 0 {
+		cfg.ListTTL = 5 * time.Second
+	}
+	if cfg.FetchTimeout <= 0 {
+		cfg.FetchTimeout = 5 * time.Second
+	}
+
+	return &CachedDecisionRepository{
+		next:   next,
+		cfg:    cfg,
+		local:  cache.NewLRU[string, cachedValue](int64(cfg.MaxEntries), nil),
+		remote: cfg.Remote,
+	}
+}
+
+func (c *CachedDecisionRepository) PutDecision(ctx context.Context, d *models.Decision) error {
+	err := c.next.PutDecision(ctx, d)
+
+	// Invalidate even on error: the write may have committed before the
+	// failure was reported.
+	c.invalidate(ctx,
+		countKey(d.RecipientUserId),
+		likesKey(d.RecipientUserId),
+		newLikesKey(d.RecipientUserId),
+		// the actor liking back removes the recipient from the actor's new likes
+		newLikesKey(d.ActorUserId),
+	)
+
+	return err
+}
+
+func (c *CachedDecisionRepository) IsMutual(ctx context.Context, actorID, recipientID string) (bool, error) {
+	return c.next.IsMutual(ctx, actorID, recipientID)
+}
+
+func (c *CachedDecisionRepository) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	if PrimaryReadsRequested(ctx) {
+		return c.next.CountLikedYou(ctx, recipientID)
+	}
+
+	payload, err := c.load(ctx, countKey(recipientID), c.cfg.CountTTL, func(ctx context.Context) ([]byte, error) {
+		count, err := c.next.CountLikedYou(ctx, recipientID)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(count)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := json.Unmarshal(payload, &count); err != nil {
+		return 0, fmt.Errorf("failed to decode cached count: %w", err)
+	}
+
+	return count, nil
+}
+
+func (c *CachedDecisionRepository) ListLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+	if paginationToken != "" || PrimaryReadsRequested(ctx) {
+		return c.next.ListLikedYou(ctx, recipientID, paginationToken)
+	}
+
+	return c.loadPage(ctx, likesKey(recipientID), func(ctx context.Context) ([]models.Decision, string, error) {
+		return c.next.ListLikedYou(ctx, recipientID, "")
+	})
+}
+
+func (c *CachedDecisionRepository) ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+	if paginationToken != "" || PrimaryReadsRequested(ctx) {
+		return c.next.ListNewLikedYou(ctx, recipientID, paginationToken)
+	}
+
+	return c.loadPage(ctx, newLikesKey(recipientID), func(ctx context.Context) ([]models.Decision, string, error) {
+		return c.next.ListNewLikedYou(ctx, recipientID, "")
+	})
+}
+
+func (c *CachedDecisionRepository) loadPage(ctx context.Context, key string, fetch func(context.Context) ([]models.Decision, string, error)) ([]models.Decision, string, error) {
+	payload, err := c.load(ctx, key, c.cfg.ListTTL, func(ctx context.Context) ([]byte, error) {
+		decisions, nextToken, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(cachedPage{Decisions: decisions, NextToken: nextToken})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var page cachedPage
+	if err := json.Unmarshal(payload, &page); err != nil {
+		return nil, "", fmt.Errorf("failed to decode cached page: %w", err)
+	}
+
+	return page.Decisions, page.NextToken, nil
+}
+
+// load serves key from the local LRU, then the remote cache, then fetch.
+// Concurrent misses for the same key and version share one fetch, which is
+// detached from the caller that started it so that caller going away
+// doesn't fail the others; each caller stops waiting when its own ctx ends.
+func (c *CachedDecisionRepository) load(ctx context.Context, key string, ttl time.Duration, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
+	if v, ok := c.local.Get(key); ok && time.Now().Before(v.expires) {
+		return v.payload, nil
+	}
+
+	version := c.stripe(key).Load()
+	flight := fmt.Sprintf("%s#%d", key, version)
+
+	result := c.group.DoChan(flight, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.FetchTimeout)
+		defer cancel()
+
+		if c.remote != nil {
+			payload, ok, err := c.remote.Get(ctx, key)
+			if err != nil {
+				logging.FromContext(ctx).WarnContext(ctx, "remote cache get failed", "component", "cache", "key", key, "error", err)
+			} else if ok {
+				c.store(key, version, payload, ttl)
+				return payload, nil
+			}
+		}
+
+		payload, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.store(key, version, payload, ttl) && c.remote != nil {
+			if err := c.remote.Set(ctx, key, payload, ttl); err != nil {
+				logging.FromContext(ctx).WarnContext(ctx, "remote cache set failed", "component", "cache", "key", key, "error", err)
+			} else if c.stripe(key).Load() != version {
+				// an invalidation raced the Set and may have deleted first
+				c.remote.Delete(ctx, key)
+			}
+		}
+
+		return payload, nil
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// store caches payload unless key was invalidated since version was read.
+// The version is checked again after the write because an invalidation may
+// land between the first check and the Set.
+func (c *CachedDecisionRepository) store(key string, version uint64, payload []byte, ttl time.Duration) bool {
+	if c.stripe(key).Load() != version {
+		return false
+	}
+
+	c.local.Set(key, cachedValue{payload: payload, expires: time.Now().Add(ttl)}, 1)
+
+	if c.stripe(key).Load() != version {
+		c.local.Delete(key)
+		return false
+	}
+	return true
+}
+
+func (c *CachedDecisionRepository) invalidate(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		c.stripe(key).Add(1)
+		c.local.Delete(key)
+	}
+
+	if c.remote != nil {
+		if err := c.remote.Delete(ctx, keys...); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "remote cache delete failed", "component", "cache", "keys", keys, "error", err)
+		}
+	}
+}
+
+func (c *CachedDecisionRepository) stripe(key string) *atomic.Uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &c.versions[h.Sum32()%uint32(len(c.versions))]
+}
+
+func countKey(recipientID string) string    { return "explore:count:" + recipientID }
+func likesKey(recipientID string) string    { return "explore:likes:" + recipientID }
+func newLikesKey(recipientID string) string { return "explore:newlikes:" + recipientID }
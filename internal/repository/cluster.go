@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads marks ctx so that every query made with it goes to the
+// primary, giving the caller read-your-writes consistency.
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// PrimaryReadsRequested reports whether WithPrimaryReads was applied to ctx.
+func PrimaryReadsRequested(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return v
+}
+
+// Cluster routes queries between a primary and optional read replicas.
+// Replicas that fail a health check are skipped until they recover; with no
+// healthy replica left, reads fall back to the primary.
+type Cluster struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     atomic.Uint32
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+func NewCluster(primary *sql.DB, replicas ...*sql.DB) *Cluster {
+	c := &Cluster{primary: primary}
+	for _, db := range replicas {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+		c.replicas = append(c.replicas, r)
+	}
+	return c
+}
+
+// Primary returns the database that takes writes.
+func (c *Cluster) Primary() *sql.DB {
+	return c.primary
+}
+
+// Reader returns a healthy replica in round-robin order, or the primary if
+// ctx asks for primary reads or no replica is healthy.
+func (c *Cluster) Reader(ctx context.Context) *sql.DB {
+	if len(c.replicas) == 0 || PrimaryReadsRequested(ctx) {
+		return c.primary
+	}
+
+	start := c.next.Add(1)
+	for i := range c.replicas {
+		r := c.replicas[(int(start)+i)%len(c.replicas)]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+
+	return c.primary
+}
+
+// MarkUnhealthy takes db out of rotation until the next successful health
+// check. It is a no-op for the primary.
+func (c *Cluster) MarkUnhealthy(db *sql.DB) {
+	for _, r := range c.replicas {
+		if r.db == db && r.healthy.Swap(false) {
+			slog.Warn("replica marked unhealthy, reads fail over to primary", "component", "cluster")
+		}
+	}
+}
+
+// Run pings every replica on each interval until ctx is cancelled.
+func (c *Cluster) Run(ctx context.Context, interval time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkReplicas(ctx, interval)
+		}
+	}
+}
+
+func (c *Cluster) checkReplicas(ctx context.Context, timeout time.Duration) {
+	for i, r := range c.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.db.PingContext(pingCtx)
+		cancel()
+
+		healthy := err == nil
+		if r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				slog.Info("replica recovered", "component", "cluster", "replica", i)
+			} else {
+				slog.Warn("replica unhealthy", "component", "cluster", "replica", i, "error", err)
+			}
+		}
+	}
+}
+
+// Close closes every replica. The primary is owned by the caller.
+func (c *Cluster) Close() error {
+	for _, r := range c.replicas {
+		r.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/bloom"
+	"github.com/fleimkeipa/grpc-example/internal/cache"
+)
+
+// DecidedCacheConfig tunes the per-actor Bloom filter cache.
+type DecidedCacheConfig struct {
+	// MaxBytes bounds the memory held by all cached filters.
+	MaxBytes int64
+	// FalsePositiveRate is the target rate at which an undecided recipient is
+	// reported as "maybe decided" and has to be confirmed against Postgres.
+	FalsePositiveRate float64
+	// MinCapacity is the smallest number of items a filter is sized for, so
+	// actors with few decisions can keep swiping before a rebuild.
+	MinCapacity int
+	// MaxAge is how long a filter is used before it is rebuilt from the
+	// table, bounding how long decisions made through other servers can be
+	// missing from it. Zero keeps filters until they are evicted.
+	MaxAge time.Duration
+	// LoadTimeout bounds building a filter, which outlives the callers
+	// waiting for it giving up.
+	LoadTimeout time.Duration
+}
+
+// DecidedCacheStats is a point-in-time snapshot of cache activity.
+type DecidedCacheStats struct {
+	Lookups       int64 // recipients checked against a filter
+	Undecided     int64 // lookups answered "definitely undecided" without Postgres
+	Confirmations int64 // lookups that needed a Postgres round trip
+	Loads         int64 // filters built from the decisions table
+	Evictions     int64 // filters dropped to stay within MaxBytes
+	Rebuilds      int64 // filters dropped because they outgrew their capacity
+	Expirations   int64 // filters rebuilt because they were older than MaxAge
+	Entries       int
+	Bytes         int64
+}
+
+// DecidedSource supplies the data DecidedCache is built from.
+type DecidedSource interface {
+	DecidedRecipients(ctx context.Context, actorID string) ([]string, error)
+	FilterDecided(ctx context.Context, actorID string, recipientIDs []string) (map[string]bool, error)
+}
+
+// DecidedCache keeps an in-process Bloom filter of every recipient each actor
+// has decided about. Filters are built lazily from the decisions table and
+// kept current through Add, which must be called after every successful
+// PutDecision. Decisions stored through other servers reach a filter when it
+// is rebuilt after MaxAge.
+type DecidedCache struct {
+	src     DecidedSource
+	cfg     DecidedCacheConfig
+	filters *cache.LRU[string, *decidedFilter]
+
+	mu      sync.Mutex
+	loading map[string]*decidedLoad
+
+	lookups       atomic.Int64
+	undecided     atomic.Int64
+	confirmations atomic.Int64
+	loads         atomic.Int64
+	evictions     atomic.Int64
+	rebuilds      atomic.Int64
+	expirations   atomic.Int64
+}
+
+// decidedFilter is a cached filter and when it was built.
+type decidedFilter struct {
+	*bloom.Filter
+	loadedAt time.Time
+}
+
+type decidedLoad struct {
+	done    chan struct{}
+	pending []string
+	filter  *decidedFilter
+	err     error
+}
+
+func NewDecidedCache(src DecidedSource, cfg DecidedCacheConfig) *DecidedCache {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.FalsePositiveRate <= 0 || cfg.FalsePositiveRate >= 1 {
+		cfg.FalsePositiveRate = 0.01
+	}
+	if cfg.MinCapacity <= 0 {
+		cfg.MinCapacity = 1024
+	}
+	if cfg.LoadTimeout <= 0 {
+		cfg.LoadTimeout = 5 * time.Second
+	}
+
+	c := &DecidedCache{
+		src:     src,
+		cfg:     cfg,
+		loading: make(map[string]*decidedLoad),
+	}
+	c.filters = cache.NewLRU(cfg.MaxBytes, func(string, *decidedFilter) {
+		c.evictions.Add(1)
+	})
+
+	return c
+}
+
+// Add records a new decision in the actor's filter, if one is cached or
+// being built. Actors without a filter are skipped: their filter will be
+// built from the table, which already contains the decision.
+func (c *DecidedCache) Add(actorID, recipientID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.loading[actorID]; ok {
+		l.pending = append(l.pending, recipientID)
+		return
+	}
+
+	saturated := false
+	c.filters.Update(actorID, func(f *decidedFilter) {
+		f.Add(recipientID)
+		saturated = f.Saturated()
+	})
+	if saturated {
+		c.filters.Delete(actorID)
+		c.rebuilds.Add(1)
+	}
+}
+
+// DefinitelyUndecided reports true only when the actor has certainly never
+// decided about the recipient. A false result means "maybe decided".
+func (c *DecidedCache) DefinitelyUndecided(ctx context.Context, actorID, recipientID string) (bool, error) {
+	maybe, err := c.MaybeDecided(ctx, actorID, []string{recipientID})
+	if err != nil {
+		return false, err
+	}
+
+	return len(maybe) == 0, nil
+}
+
+// MaybeDecided returns the candidates the actor may have decided about, in
+// their original order, without querying Postgres unless the filter has to
+// be built. Candidates left out have certainly not been decided about.
+func (c *DecidedCache) MaybeDecided(ctx context.Context, actorID string, candidates []string) ([]string, error) {
+	f, err := c.filter(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.test(actorID, f, candidates), nil
+}
+
+// FilterUndecided returns the candidates the actor has not decided about yet,
+// in their original order. Only candidates that hit the filter are checked
+// against Postgres.
+func (c *DecidedCache) FilterUndecided(ctx context.Context, actorID string, candidates []string) ([]string, error) {
+	maybe, err := c.MaybeDecided(ctx, actorID, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	decided := map[string]bool{}
+	if len(maybe) > 0 {
+		c.confirmations.Add(int64(len(maybe)))
+		decided, err = c.src.FilterDecided(ctx, actorID, maybe)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	undecided := make([]string, 0, len(candidates))
+	for _, id := range candidates {
+		if !decided[id] {
+			undecided = append(undecided, id)
+		}
+	}
+
+	return undecided, nil
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *DecidedCache) Stats() DecidedCacheStats {
+	return DecidedCacheStats{
+		Lookups:       c.lookups.Load(),
+		Undecided:     c.undecided.Load(),
+		Confirmations: c.confirmations.Load(),
+		Loads:         c.loads.Load(),
+		Evictions:     c.evictions.Load(),
+		Rebuilds:      c.rebuilds.Load(),
+		Expirations:   c.expirations.Load(),
+		Entries:       c.filters.Len(),
+		Bytes:         c.filters.Cost(),
+	}
+}
+
+// test returns the candidates that may have been decided. f is used directly
+// when it could not be cached because it alone exceeds MaxBytes.
+func (c *DecidedCache) test(actorID string, f *decidedFilter, candidates []string) []string {
+	var maybe []string
+	check := func(f *decidedFilter) {
+		for _, id := range candidates {
+			if f.Test(id) {
+				maybe = append(maybe, id)
+			}
+		}
+	}
+	if !c.filters.Update(actorID, check) {
+		check(f)
+	}
+
+	c.lookups.Add(int64(len(candidates)))
+	c.undecided.Add(int64(len(candidates) - len(maybe)))
+
+	return maybe
+}
+
+// filter returns the actor's filter, building it from the decisions table on
+// a miss or once it is older than MaxAge. Concurrent misses for the same
+// actor share a single load.
+func (c *DecidedCache) filter(ctx context.Context, actorID string) (*decidedFilter, error) {
+	if f, ok := c.filters.Get(actorID); ok && c.fresh(f) {
+		return f, nil
+	}
+
+	c.mu.Lock()
+	f, cached := c.filters.Get(actorID)
+	if cached && c.fresh(f) {
+		c.mu.Unlock()
+		return f, nil
+	}
+	l, ok := c.loading[actorID]
+	if !ok {
+		if cached {
+			c.expirations.Add(1)
+		}
+		l = &decidedLoad{done: make(chan struct{})}
+		c.loading[actorID] = l
+		go c.load(context.WithoutCancel(ctx), actorID, l)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return l.filter, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *DecidedCache) fresh(f *decidedFilter) bool {
+	return c.cfg.MaxAge <= 0 || time.Since(f.loadedAt) < c.cfg.MaxAge
+}
+
+func (c *DecidedCache) load(ctx context.Context, actorID string, l *decidedLoad) {
+	defer close(l.done)
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.LoadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	recipients, err := c.src.DecidedRecipients(ctx, actorID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.loading, actorID)
+
+	if err != nil {
+		l.err = err
+		return
+	}
+
+	f := &decidedFilter{
+		Filter:   bloom.New(max(2*(len(recipients)+len(l.pending)), c.cfg.MinCapacity), c.cfg.FalsePositiveRate),
+		loadedAt: start,
+	}
+	for _, id := range recipients {
+		f.Add(id)
+	}
+	// Decisions written while the query ran may be missing from its snapshot.
+	for _, id := range l.pending {
+		f.Add(id)
+	}
+
+	l.filter = f
+	c.filters.Set(actorID, f, int64(f.SizeBytes()))
+	c.loads.Add(1)
+}
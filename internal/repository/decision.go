@@ -5,57 +5,197 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/fleimkeipa/grpc-example/internal/logging"
 	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
 
+	"github.com/lib/pq"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// DefaultPageSize is the number of likers returned per page unless
+// WithPageSize says otherwise.
+const DefaultPageSize = 30
+
+// paginationTokenLayout formats the created_at cursor handed to clients.
+const paginationTokenLayout = "2006-01-02 15:04:05.999999999"
+
+// Decisions is the storage contract ExploreServer depends on. It is
+// implemented by DecisionRepository and by decorators wrapping it.
+type Decisions interface {
+	PutDecision(ctx context.Context, d *models.Decision) error
+	ListLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error)
+	ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error)
+	IsMutual(ctx context.Context, actorID, recipientID string) (bool, error)
+	CountLikedYou(ctx context.Context, recipientID string) (int64, error)
+}
+
 type DecisionRepository struct {
-	db    *sql.DB
-	stmts map[string]*sql.Stmt
-	mu    sync.RWMutex
+	db      *sql.DB
+	cluster *Cluster
+	queries map[string]string
+	stmts   map[string]*sql.Stmt
+	mu      sync.RWMutex
+
+	// lockedUpsert is set when the table has no unique key on
+	// (actor_user_id, recipient_user_id), as with time sub-partitions, so
+	// ON CONFLICT can't be used and writers serialise on an advisory lock.
+	// reprepare checks it again, as ConvertToPartitioned may drop the key
+	// under a running server.
+	lockedUpsert bool
+
+	pageSize         int
+	retryPolicy      RetryPolicy
+	statementTimeout time.Duration
+
+	// generation counts statement replacements by reprepare.
+	generation int
+}
+
+// RepositoryOption configures a DecisionRepository.
+type RepositoryOption func(*DecisionRepository)
+
+// WithPageSize sets the number of likers returned per page.
+func WithPageSize(n int) RepositoryOption {
+	return func(r *DecisionRepository) {
+		if n > 0 {
+			r.pageSize = n
+		}
+	}
+}
+
+func NewDecisionRepository(db *sql.DB, opts ...RepositoryOption) (*DecisionRepository, error) {
+	return NewReplicatedDecisionRepository(NewCluster(db), opts...)
 }
 
-func NewDecisionRepository(db *sql.DB) (*DecisionRepository, error) {
+// NewReplicatedDecisionRepository sends CountLikedYou, ListLikedYou and
+// ListNewLikedYou to the cluster's replicas; writes and IsMutual always use
+// the primary. Statements are prepared on the primary only.
+func NewReplicatedDecisionRepository(cluster *Cluster, opts ...RepositoryOption) (*DecisionRepository, error) {
+	db := cluster.Primary()
 	repo := &DecisionRepository{
-		db:    db,
-		stmts: make(map[string]*sql.Stmt),
+		db:          db,
+		cluster:     cluster,
+		stmts:       make(map[string]*sql.Stmt),
+		pageSize:    DefaultPageSize,
+		retryPolicy: DefaultRetryPolicy,
 	}
+	for _, opt := range opts {
+		opt(repo)
+	}
+
+	lockedUpsert, err := NeedsLockedUpsert(db)
+	if err != nil {
+		return nil, err
+	}
+	queries := repositoryQueries(lockedUpsert)
 
-	queries := map[string]string{
+	repo.lockedUpsert = lockedUpsert
+	repo.queries = queries
+	for name, query := range queries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare %s: %w", name, err)
+		}
+		repo.stmts[name] = stmt
+	}
+
+	return repo, nil
+}
+
+// preparedQueries returns the statements prepared on every repository.
+func preparedQueries() map[string]string {
+	return map[string]string{
 		"countLikedYou": `
             SELECT COUNT(*) 
             FROM decisions 
             WHERE recipient_user_id = $1 
               AND liked_recipient = true
         `,
+		// previous locks an existing row, so it reads the version the
+		// upsert replaces even when another writer got there first.
 		"putDecision": `
+            WITH previous AS (
+                SELECT liked_recipient
+                FROM decisions
+                WHERE actor_user_id = $1
+                  AND recipient_user_id = $2
+                FOR UPDATE
+            )
             INSERT INTO decisions (actor_user_id, recipient_user_id, liked_recipient,created_at, updated_at)
             VALUES ($1, $2, $3, NOW(), NOW())
             ON CONFLICT (actor_user_id, recipient_user_id)
             DO UPDATE SET 
                 liked_recipient = EXCLUDED.liked_recipient,
                 updated_at = NOW()
+            RETURNING created_at, updated_at, COALESCE((SELECT liked_recipient FROM previous), false)
         `,
 		"checkMutualLikes": `
             SELECT liked_recipient 
             FROM decisions 
             WHERE actor_user_id = $1 
               AND recipient_user_id = $2
+        `,
+		"listDecidedRecipients": `
+            SELECT recipient_user_id
+            FROM decisions
+            WHERE actor_user_id = $1
+        `,
+		"filterDecided": `
+            SELECT recipient_user_id
+            FROM decisions
+            WHERE actor_user_id = $1
+              AND recipient_user_id = ANY($2)
+        `,
+		"filterLiked": `
+            SELECT recipient_user_id
+            FROM decisions
+            WHERE actor_user_id = $1
+              AND recipient_user_id = ANY($2)
+              AND liked_recipient = true
         `,
 	}
+}
 
-	for name, query := range queries {
-		stmt, err := db.Prepare(query)
-		if err != nil {
-			return nil, fmt.Errorf("failed to prepare %s: %w", name, err)
+// repositoryQueries returns the statements a repository prepares, with the
+// locked upsert in place of putDecision when lockedUpsert is set.
+func repositoryQueries(lockedUpsert bool) map[string]string {
+	queries := preparedQueries()
+	if lockedUpsert {
+		delete(queries, "putDecision")
+		for name, query := range lockedUpsertQueries() {
+			queries[name] = query
 		}
-		repo.stmts[name] = stmt
 	}
+	return queries
+}
 
-	return repo, nil
+// lockedUpsertQueries replace putDecision when ON CONFLICT is unavailable.
+func lockedUpsertQueries() map[string]string {
+	return map[string]string{
+		"lockDecision": `SELECT pg_advisory_xact_lock(hashtextextended($1 || ':' || $2, 0))`,
+		"updateDecision": `
+            WITH previous AS (
+                SELECT liked_recipient
+                FROM decisions
+                WHERE actor_user_id = $1
+                  AND recipient_user_id = $2
+            )
+            UPDATE decisions
+            SET liked_recipient = $3, updated_at = NOW()
+            WHERE actor_user_id = $1
+              AND recipient_user_id = $2
+            RETURNING created_at, updated_at, (SELECT liked_recipient FROM previous)
+        `,
+		"insertDecision": `
+            INSERT INTO decisions (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+            VALUES ($1, $2, $3, NOW(), NOW())
+            RETURNING created_at, updated_at
+        `,
+	}
 }
 
 func (r *DecisionRepository) Close() error {
@@ -68,81 +208,433 @@ func (r *DecisionRepository) Close() error {
 	return nil
 }
 
-func (r *DecisionRepository) PutDecision(ctx context.Context, d *models.Decision) error {
+// Check verifies the primary answers and that the prepared statements are
+// usable by running the read-only ones against an ID no user can have.
+func (r *DecisionRepository) Check(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, name := range []string{"countLikedYou", "checkMutualLikes"} {
+		stmt, ok := r.stmts[name]
+		if !ok {
+			return fmt.Errorf("statement %s is not prepared", name)
+		}
+
+		args := []any{""}
+		if name == "checkMutualLikes" {
+			args = append(args, "")
+		}
+		var discard any
+		if err := stmt.QueryRowContext(ctx, args...).Scan(&discard); err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("statement %s failed: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// PutDecision upserts d and fills in its stored CreatedAt and UpdatedAt, so
+// an UpdatedAt later than CreatedAt means an earlier decision was replaced,
+// and whether that decision was a like.
+func (r *DecisionRepository) PutDecision(ctx context.Context, d *models.Decision) (err error) {
 	if err := ctx.Err(); err != nil {
 		return status.Error(codes.Canceled, "request cancelled")
 	}
 
-	stmt := r.stmts["putDecision"]
+	ctx, span := startQuerySpan(ctx, "putDecision")
+	defer func() { endQuerySpan(span, 1, err) }()
+
+	return r.retry(ctx, "putDecision", func() error {
+		if r.upsertLocked() {
+			return r.putDecisionLocked(ctx, d)
+		}
+		return r.putDecision(ctx, d)
+	})
+}
+
+func (r *DecisionRepository) putDecision(ctx context.Context, d *models.Decision) error {
+	sc, err := r.begin(ctx, r.db, false)
+	if err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
+	defer sc.rollback()
+
+	err = sc.stmt(ctx, "putDecision").QueryRowContext(ctx, d.ActorUserId, d.RecipientUserId, d.LikedRecipient).
+		Scan(&d.CreatedAt, &d.UpdatedAt, &d.PreviouslyLiked)
+	if err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
+
+	if err := sc.commit(); err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
+
+	return nil
+}
+
+// putDecisionLocked upserts without ON CONFLICT, holding a per-pair advisory
+// lock so concurrent writers can't both insert.
+func (r *DecisionRepository) putDecisionLocked(ctx context.Context, d *models.Decision) error {
+	sc, err := r.beginTx(ctx, r.db, false)
+	if err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
+	defer sc.rollback()
+
+	args := []any{d.ActorUserId, d.RecipientUserId}
+	if _, err := sc.stmt(ctx, "lockDecision").ExecContext(ctx, args...); err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
 
-	_, err := stmt.ExecContext(ctx, d.ActorUserId, d.RecipientUserId, d.LikedRecipient)
+	args = append(args, d.LikedRecipient)
+	err = sc.stmt(ctx, "updateDecision").QueryRowContext(ctx, args...).Scan(&d.CreatedAt, &d.UpdatedAt, &d.PreviouslyLiked)
+	if err == sql.ErrNoRows {
+		d.PreviouslyLiked = false
+		err = sc.stmt(ctx, "insertDecision").QueryRowContext(ctx, args...).Scan(&d.CreatedAt, &d.UpdatedAt)
+	}
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to put decision for actor=%s recipient=%s: %v", d.ActorUserId, d.RecipientUserId, err.Error())
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
+	}
+
+	if err := sc.commit(); err != nil {
+		return queryError("failed to put decision", err, logging.KeyActorUserID, d.ActorUserId, logging.KeyRecipientUserID, d.RecipientUserId)
 	}
 
 	return nil
 }
 
-func (r *DecisionRepository) ListLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+func (r *DecisionRepository) ListLikedYou(ctx context.Context, recipientID string, paginationToken string) (decisions []models.Decision, nextToken string, err error) {
 	if err := ctx.Err(); err != nil {
 		return nil, "", status.Error(codes.Canceled, "request cancelled")
 	}
 
-	const limit = 30
-	query := `
-		SELECT 
-			actor_user_id, 
-			recipient_user_id, 
-			liked_recipient, 
-			created_at, 
-			updated_at
-		FROM decisions
-		WHERE recipient_user_id = $1 
-			AND liked_recipient = TRUE
-	`
-	args := []any{recipientID}
+	ctx, span := startQuerySpan(ctx, "listLikedYou")
+	defer func() { endQuerySpan(span, len(decisions), err) }()
 
-	// Add pagination token condition if provided
+	query := listLikedYouQuery(paginationToken != "", r.pageSize)
+	err = r.retry(ctx, "listLikedYou", func() (err error) {
+		decisions, nextToken, err = r.listLikers(ctx, "failed to list liked you", query, recipientID, paginationToken)
+		return err
+	})
+
+	return decisions, nextToken, err
+}
+
+func (r *DecisionRepository) ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) (decisions []models.Decision, nextToken string, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", status.Error(codes.Canceled, "request cancelled")
+	}
+
+	ctx, span := startQuerySpan(ctx, "listNewLikedYou")
+	defer func() { endQuerySpan(span, len(decisions), err) }()
+
+	query := listNewLikedYouQuery(paginationToken != "", r.pageSize)
+	err = r.retry(ctx, "listNewLikedYou", func() (err error) {
+		decisions, nextToken, err = r.listLikers(ctx, "failed to list new liked you", query, recipientID, paginationToken)
+		return err
+	})
+
+	return decisions, nextToken, err
+}
+
+// listLikers runs a page query built by listLikedYouQuery or
+// listNewLikedYouQuery.
+func (r *DecisionRepository) listLikers(ctx context.Context, op, query, recipientID, paginationToken string) ([]models.Decision, string, error) {
+	limit := r.pageSize
+	args := []any{recipientID}
 	if paginationToken != "" {
-		query += "AND created_at < $2"
 		args = append(args, paginationToken)
 	}
 
-	query += " ORDER BY created_at DESC"
-	query += fmt.Sprintf(" LIMIT %v", limit+1)
-
-	rows, err := r.db.QueryContext(ctx, query, args...)
+	sc, rows, err := r.readQuery(ctx, query, args...)
 	if err != nil {
-		return nil, "", status.Errorf(codes.Internal, "failed to list liked you for recipient=%s: %v", recipientID, err)
+		return nil, "", queryError(op, err, logging.KeyRecipientUserID, recipientID)
 	}
+	defer sc.rollback()
 	defer rows.Close()
 
 	var decisions []models.Decision
 	for rows.Next() {
 		var d models.Decision
 		if err := rows.Scan(&d.ActorUserId, &d.RecipientUserId, &d.LikedRecipient, &d.CreatedAt, &d.UpdatedAt); err != nil {
-			return nil, "", status.Errorf(codes.Internal, "failed to scan decision for recipient=%s: %v", recipientID, err)
+			return nil, "", queryError("failed to scan decision", err, logging.KeyRecipientUserID, recipientID)
 		}
 		decisions = append(decisions, d)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", queryError(op, err, logging.KeyRecipientUserID, recipientID)
+	}
 
 	// Determine next pagination token
 	var nextToken string
 	if len(decisions) > limit {
 		// Remove the extra record and use its timestamp as next token
 		decisions = decisions[:limit]
-		nextToken = decisions[limit-1].CreatedAt.Format("2006-01-02 15:04:05.999999999")
+		nextToken = decisions[limit-1].CreatedAt.Format(paginationTokenLayout)
 	}
 
 	return decisions, nextToken, nil
 }
 
-func (r *DecisionRepository) ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+// Liked reports whether the actor currently likes the recipient.
+func (r *DecisionRepository) Liked(ctx context.Context, actorID, recipientID string) (liked bool, err error) {
 	if err := ctx.Err(); err != nil {
-		return nil, "", status.Error(codes.Canceled, "request cancelled")
+		return false, status.Error(codes.Canceled, "request cancelled")
+	}
+
+	var found bool
+	ctx, span := startQuerySpan(ctx, "checkMutualLikes")
+	defer func() { endQuerySpan(span, rowCount(found), err) }()
+
+	err = r.retry(ctx, "checkMutualLikes", func() (err error) {
+		liked, found, err = r.liked(ctx, actorID, recipientID)
+		return err
+	})
+
+	return liked, err
+}
+
+func (r *DecisionRepository) liked(ctx context.Context, actorID, recipientID string) (liked, found bool, err error) {
+	sc, err := r.begin(ctx, r.db, true)
+	if err != nil {
+		return false, false, queryError("failed to check like", err, logging.KeyActorUserID, actorID, logging.KeyRecipientUserID, recipientID)
+	}
+	defer sc.rollback()
+
+	return sc.liked(ctx, actorID, recipientID)
+}
+
+// liked looks up the actor's decision about the recipient. found is false
+// when there is none.
+func (sc *scope) liked(ctx context.Context, actorID, recipientID string) (liked, found bool, err error) {
+	err = sc.stmt(ctx, "checkMutualLikes").QueryRowContext(ctx, actorID, recipientID).Scan(&liked)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, queryError("failed to check like", err, logging.KeyActorUserID, actorID, logging.KeyRecipientUserID, recipientID)
+	}
+
+	return liked, true, nil
+}
+
+func (r *DecisionRepository) IsMutual(ctx context.Context, actorID, recipientID string) (mutual bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, status.Error(codes.Canceled, "request cancelled")
+	}
+
+	var found int
+	ctx, span := startQuerySpan(ctx, "checkMutualLikes")
+	defer func() { endQuerySpan(span, found, err) }()
+
+	err = r.retry(ctx, "checkMutualLikes", func() error {
+		sc, err := r.begin(ctx, r.db, true)
+		if err != nil {
+			return queryError("failed to check like", err, logging.KeyActorUserID, actorID, logging.KeyRecipientUserID, recipientID)
+		}
+		defer sc.rollback()
+
+		// Both must have liked each other for it to be mutual
+		actorLikedRecipient, actorFound, err := sc.liked(ctx, actorID, recipientID)
+		if err != nil {
+			return err
+		}
+		recipientLikedActor, recipientFound, err := sc.liked(ctx, recipientID, actorID)
+		if err != nil {
+			return err
+		}
+
+		found = rowCount(actorFound) + rowCount(recipientFound)
+		mutual = actorLikedRecipient && recipientLikedActor
+		return nil
+	})
+
+	return mutual, err
+}
+
+func rowCount(found bool) int {
+	if found {
+		return 1
+	}
+	return 0
+}
+
+func (r *DecisionRepository) CountLikedYou(ctx context.Context, recipientID string) (count int64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, status.Error(codes.Canceled, "request cancelled")
+	}
+
+	ctx, span := startQuerySpan(ctx, "countLikedYou")
+	defer func() { endQuerySpan(span, 1, err) }()
+
+	err = r.retry(ctx, "countLikedYou", func() (err error) {
+		db := r.cluster.Reader(ctx)
+		count, err = r.countLikedYouOn(ctx, db, recipientID)
+		if err != nil && err != sql.ErrNoRows && r.failOver(ctx, db, err) {
+			count, err = r.countLikedYouOn(ctx, r.db, recipientID)
+		}
+		if err == sql.ErrNoRows {
+			count = 0
+			return nil
+		}
+		if err != nil {
+			return queryError("failed to count liked you", err, logging.KeyRecipientUserID, recipientID)
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+func (r *DecisionRepository) countLikedYouOn(ctx context.Context, db *sql.DB, recipientID string) (count int64, err error) {
+	sc, err := r.begin(ctx, db, true)
+	if err != nil {
+		return 0, err
+	}
+	defer sc.rollback()
+
+	err = sc.queryRow(ctx, "countLikedYou", recipientID).Scan(&count)
+	return count, err
+}
+
+// DecidedRecipients returns every recipient the actor has made a decision
+// about, liked or passed.
+func (r *DecisionRepository) DecidedRecipients(ctx context.Context, actorID string) (recipients []string, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.Error(codes.Canceled, "request cancelled")
+	}
+
+	ctx, span := startQuerySpan(ctx, "listDecidedRecipients")
+	defer func() { endQuerySpan(span, len(recipients), err) }()
+
+	err = r.retry(ctx, "listDecidedRecipients", func() (err error) {
+		recipients, err = r.decidedRecipients(ctx, actorID)
+		return err
+	})
+
+	return recipients, err
+}
+
+func (r *DecisionRepository) decidedRecipients(ctx context.Context, actorID string) ([]string, error) {
+	sc, err := r.begin(ctx, r.db, true)
+	if err != nil {
+		return nil, queryError("failed to list decided recipients", err, logging.KeyActorUserID, actorID)
+	}
+	defer sc.rollback()
+
+	rows, err := sc.stmt(ctx, "listDecidedRecipients").QueryContext(ctx, actorID)
+	if err != nil {
+		return nil, queryError("failed to list decided recipients", err, logging.KeyActorUserID, actorID)
+	}
+	defer rows.Close()
+
+	var recipients []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, queryError("failed to scan decided recipient", err, logging.KeyActorUserID, actorID)
+		}
+		recipients = append(recipients, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError("failed to list decided recipients", err, logging.KeyActorUserID, actorID)
+	}
+
+	return recipients, nil
+}
+
+// FilterDecided reports which of recipientIDs the actor has already decided
+// about.
+func (r *DecisionRepository) FilterDecided(ctx context.Context, actorID string, recipientIDs []string) (map[string]bool, error) {
+	return r.filterRecipients(ctx, "filterDecided", actorID, recipientIDs)
+}
+
+// FilterLiked reports which of recipientIDs the actor has liked.
+func (r *DecisionRepository) FilterLiked(ctx context.Context, actorID string, recipientIDs []string) (map[string]bool, error) {
+	return r.filterRecipients(ctx, "filterLiked", actorID, recipientIDs)
+}
+
+func (r *DecisionRepository) filterRecipients(ctx context.Context, stmtName, actorID string, recipientIDs []string) (found map[string]bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, status.Error(codes.Canceled, "request cancelled")
+	}
+
+	if len(recipientIDs) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	ctx, span := startQuerySpan(ctx, stmtName)
+	defer func() { endQuerySpan(span, len(found), err) }()
+
+	err = r.retry(ctx, stmtName, func() (err error) {
+		found, err = r.filterRecipientsOnce(ctx, stmtName, actorID, recipientIDs)
+		return err
+	})
+
+	return found, err
+}
+
+func (r *DecisionRepository) filterRecipientsOnce(ctx context.Context, stmtName, actorID string, recipientIDs []string) (map[string]bool, error) {
+	sc, err := r.begin(ctx, r.db, true)
+	if err != nil {
+		return nil, queryError("failed to filter recipients", err, logging.KeyActorUserID, actorID)
+	}
+	defer sc.rollback()
+
+	rows, err := sc.stmt(ctx, stmtName).QueryContext(ctx, actorID, pq.Array(recipientIDs))
+	if err != nil {
+		return nil, queryError("failed to filter recipients", err, logging.KeyActorUserID, actorID)
 	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(recipientIDs))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, queryError("failed to scan recipient", err, logging.KeyActorUserID, actorID)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError("failed to filter recipients", err, logging.KeyActorUserID, actorID)
+	}
+
+	return found, nil
+}
 
-	const limit = 30
+// listLikedYouQuery builds the ListLikedYou query, filtering on the created_at
+// cursor in $2 when paginated.
+func listLikedYouQuery(paginated bool, pageSize int) string {
+	query := `
+		SELECT 
+			actor_user_id, 
+			recipient_user_id, 
+			liked_recipient, 
+			created_at, 
+			updated_at
+		FROM decisions
+		WHERE recipient_user_id = $1 
+			AND liked_recipient = TRUE
+	`
+
+	// Add pagination token condition if provided
+	if paginated {
+		query += "AND created_at < $2"
+	}
+
+	query += " ORDER BY created_at DESC"
+	query += fmt.Sprintf(" LIMIT %v", pageSize+1)
+
+	return query
+}
+
+// listNewLikedYouQuery builds the ListNewLikedYou query, filtering on the
+// created_at cursor in $2 when paginated.
+func listNewLikedYouQuery(paginated bool, pageSize int) string {
 	query := `
 	SELECT 	d1.actor_user_id,
 			d1.recipient_user_id,
@@ -158,87 +650,98 @@ func (r *DecisionRepository) ListNewLikedYou(ctx context.Context, recipientID st
 		AND d1.liked_recipient = TRUE
 		AND d2.actor_user_id IS NULL
 	`
-	args := []any{recipientID}
 
 	// Add pagination token condition if provided
-	if paginationToken != "" {
+	if paginated {
 		query += " AND d1.created_at < $2"
-		args = append(args, paginationToken)
 	}
 
 	query += " ORDER BY d1.created_at DESC"
-	query += fmt.Sprintf(" LIMIT %v", limit+1)
+	query += fmt.Sprintf(" LIMIT %v", pageSize+1)
 
-	rows, err := r.db.QueryContext(ctx, query, args...)
-	if err != nil {
-		return nil, "", status.Errorf(codes.Internal, "failed to list new liked you for recipient=%s: %v", recipientID, err)
-	}
-	defer rows.Close()
+	return query
+}
 
-	var decisions []models.Decision
-	for rows.Next() {
-		var d models.Decision
-		if err := rows.Scan(&d.ActorUserId, &d.RecipientUserId, &d.LikedRecipient, &d.CreatedAt, &d.UpdatedAt); err != nil {
-			return nil, "", status.Errorf(codes.Internal, "failed to scan decision for recipient=%s: %v", recipientID, err)
-		}
-		decisions = append(decisions, d)
+// Statements returns the SQL of every read and write the repository issues,
+// keyed by name, so query plans can be checked against a real schema.
+func Statements() map[string]string {
+	statements := preparedQueries()
+	statements["listLikedYou"] = listLikedYouQuery(false, DefaultPageSize)
+	statements["listLikedYouPage"] = listLikedYouQuery(true, DefaultPageSize)
+	statements["listNewLikedYou"] = listNewLikedYouQuery(false, DefaultPageSize)
+	statements["listNewLikedYouPage"] = listNewLikedYouQuery(true, DefaultPageSize)
+	for name, query := range lockedUpsertQueries() {
+		statements[name] = query
 	}
+	return statements
+}
 
-	// Determine next pagination token
-	var nextToken string
-	if len(decisions) > limit {
-		// Remove the extra record and use its timestamp as next token
-		decisions = decisions[:limit]
-		nextToken = decisions[limit-1].CreatedAt.Format("2006-01-02 15:04:05.999999999")
+// NeedsLockedUpsert reports whether decisions lacks a unique index on
+// exactly (actor_user_id, recipient_user_id), in which case writers
+// can't use ON CONFLICT and serialise on a lock instead.
+func NeedsLockedUpsert(db *sql.DB) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_index i
+			WHERE i.indrelid = to_regclass('decisions')
+			  AND i.indisunique
+			  AND i.indnkeyatts = 2
+		)
+	`).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect decisions keys: %w", err)
 	}
 
-	return decisions, nextToken, nil
+	return !exists, nil
 }
 
-func (r *DecisionRepository) IsMutual(ctx context.Context, actorID, recipientID string) (bool, error) {
-	if err := ctx.Err(); err != nil {
-		return false, status.Error(codes.Canceled, "request cancelled")
-	}
+// readQuery runs a read on a replica when one is available, retrying once on
+// the primary if the replica fails. The scope must be rolled back once the
+// rows have been read.
+func (r *DecisionRepository) readQuery(ctx context.Context, query string, args ...any) (*scope, *sql.Rows, error) {
+	db := r.cluster.Reader(ctx)
 
-	stmt := r.stmts["checkMutualLikes"]
+	sc, rows, err := r.queryOn(ctx, db, query, args...)
+	if err != nil && r.failOver(ctx, db, err) {
+		sc, rows, err = r.queryOn(ctx, r.db, query, args...)
+	}
 
-	var actorLikedRecipient, recipientLikedActor bool
+	return sc, rows, err
+}
 
-	// Check if actor liked recipient
-	err := stmt.QueryRowContext(ctx, actorID, recipientID).Scan(&actorLikedRecipient)
-	if err == sql.ErrNoRows {
-		actorLikedRecipient = false
-	} else if err != nil {
-		return false, status.Errorf(codes.Internal, "failed to check if actor=%s liked recipient=%s: %v", actorID, recipientID, err)
+func (r *DecisionRepository) queryOn(ctx context.Context, db *sql.DB, query string, args ...any) (*scope, *sql.Rows, error) {
+	sc, err := r.begin(ctx, db, true)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Check if recipient liked actor
-	err = stmt.QueryRowContext(ctx, recipientID, actorID).Scan(&recipientLikedActor)
-	if err == sql.ErrNoRows {
-		recipientLikedActor = false
-	} else if err != nil {
-		return false, status.Errorf(codes.Internal, "failed to check if recipient=%s liked actor=%s: %v", recipientID, actorID, err)
+	rows, err := sc.QueryContext(ctx, query, args...)
+	if err != nil {
+		sc.rollback()
+		return nil, nil, err
 	}
 
-	// Both must have liked each other for it to be mutual
-	return actorLikedRecipient && recipientLikedActor, nil
+	return sc, rows, nil
 }
 
-func (r *DecisionRepository) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
-	if err := ctx.Err(); err != nil {
-		return 0, status.Error(codes.Canceled, "request cancelled")
+// failOver reports whether a read that failed with err on db should be
+// retried on the primary: when db looks unreachable, which also takes it out
+// of rotation, or when a replica cancelled the read for conflicting with
+// recovery. Reads that ran out of time or failed for reasons of their own
+// are not retried, and say nothing about the health of db.
+func (r *DecisionRepository) failOver(ctx context.Context, db *sql.DB, err error) bool {
+	if db == r.db || ctx.Err() != nil {
+		return false
 	}
 
-	stmt := r.stmts["countLikedYou"]
-
-	var count int64
-	err := stmt.QueryRowContext(ctx, recipientID).Scan(&count)
-	if err == sql.ErrNoRows {
-		return 0, nil
-	}
-	if err != nil {
-		return 0, status.Errorf(codes.Internal, "failed to count liked you for recipient=%s: %v", recipientID, err)
+	switch code, _ := rpcerror.Classify(ctx, err); code {
+	case codes.Unavailable:
+		r.cluster.MarkUnhealthy(db)
+		return true
+	case codes.Aborted:
+		return true
 	}
-
-	return count, nil
+	return false
 }
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"log/slog"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QueryError is a failed database operation. It keeps the driver error for
+// errors.As and the user IDs involved as structured log attributes, and
+// reports codes.Internal to gRPC until an interceptor maps it to something
+// more precise.
+type QueryError struct {
+	Op    string
+	Err   error
+	Attrs []slog.Attr
+}
+
+func queryError(op string, err error, args ...any) *QueryError {
+	var attrs []slog.Attr
+	for len(args) >= 2 {
+		key, _ := args[0].(string)
+		attrs = append(attrs, slog.Any(key, args[1]))
+		args = args[2:]
+	}
+	return &QueryError{Op: op, Err: err, Attrs: attrs}
+}
+
+func (e *QueryError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *QueryError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, e.Error())
+}
+
+// LogValue logs the operation, the driver error and the user IDs as
+// separate attributes.
+func (e *QueryError) LogValue() slog.Value {
+	attrs := append([]slog.Attr{slog.String("op", e.Op), slog.String("cause", e.Err.Error())}, e.Attrs...)
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy controls how repository operations are retried after a
+// transient failure. Every operation of DecisionRepository is idempotent:
+// reads trivially, and PutDecision because replaying the upsert leaves the
+// same row. A retried PutDecision whose first attempt did commit reports
+// an overwrite, as a client retry would.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt; 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry. It doubles on each
+	// further retry up to MaxDelay, and the actual wait is drawn uniformly
+	// below it so that clients failing together don't retry together.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy says otherwise.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   20 * time.Millisecond,
+	MaxDelay:    500 * time.Millisecond,
+}
+
+// WithRetryPolicy sets how transient failures are retried.
+func WithRetryPolicy(p RetryPolicy) RepositoryOption {
+	return func(r *DecisionRepository) {
+		r.retryPolicy = p
+	}
+}
+
+// failure says what a failed attempt means for the next one.
+type failure int
+
+const (
+	permanent failure = iota
+	transient
+	// stalePlan is a prepared statement the server no longer has, or
+	// whose plan a schema change invalidated, or one closed by a
+	// concurrent reprepare, or an ON CONFLICT upsert on a table that lost
+	// its unique key.
+	stalePlan
+)
+
+// classifyFailure says what err means for the next attempt. replaced is
+// set when reprepare swapped the statements while the attempt ran, which
+// closes the ones it may have been using.
+func classifyFailure(err error, replaced bool) failure {
+	if errors.Is(err, driver.ErrBadConn) {
+		return transient
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		// A statement closed under the attempt fails in database/sql
+		// before reaching the server, with an error it doesn't export.
+		if replaced && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			return stalePlan
+		}
+		return permanent
+	}
+
+	switch {
+	case pqErr.Code == "40001", pqErr.Code == "40P01": // serialization_failure, deadlock_detected
+		return transient
+	case pqErr.Code.Class() == "08": // connection_exception
+		return transient
+	case pqErr.Code == "26000": // invalid_sql_statement_name
+		return stalePlan
+	case pqErr.Code == "42P10": // invalid_column_reference, no unique key matching ON CONFLICT
+		return stalePlan
+	case pqErr.Code == "0A000" && strings.Contains(pqErr.Message, "cached plan"): // feature_not_supported
+		return stalePlan
+	}
+	return permanent
+}
+
+// retry runs attempt until it succeeds, fails permanently, runs out of
+// attempts or would retry past ctx's deadline. Statements are re-prepared
+// before retrying a stale plan.
+func (r *DecisionRepository) retry(ctx context.Context, op string, attempt func() error) error {
+	policy := r.retryPolicy
+	delay := policy.BaseDelay
+
+	for n := 1; ; n++ {
+		generation := r.stmtGeneration()
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		kind := classifyFailure(err, r.stmtGeneration() != generation)
+		if kind == permanent {
+			return err
+		}
+
+		// Stale statements are replaced even when this was the last
+		// attempt, so that later calls don't fail the same way.
+		// reprepare does nothing when another attempt has already
+		// replaced them.
+		if kind == stalePlan {
+			if perr := r.reprepare(generation); perr != nil {
+				return errors.Join(err, perr)
+			}
+		}
+		if n >= policy.MaxAttempts {
+			return err
+		}
+
+		wait := time.Duration(rand.Int64N(int64(delay) + 1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return err
+		}
+
+		logging.FromContext(ctx).DebugContext(ctx, "retrying query",
+			"op", op, "attempt", n+1, "backoff", wait, "error", err)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", n+1),
+			attribute.String("error", err.Error()),
+		))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay = min(delay*2, policy.MaxDelay)
+	}
+}
+
+// stmt returns the named prepared statement.
+func (r *DecisionRepository) stmt(name string) *sql.Stmt {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.stmts[name]
+}
+
+// query returns the SQL of the named prepared statement.
+func (r *DecisionRepository) query(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queries[name]
+}
+
+// upsertLocked reports whether PutDecision must use the locked upsert.
+func (r *DecisionRepository) upsertLocked() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.lockedUpsert
+}
+
+func (r *DecisionRepository) stmtGeneration() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.generation
+}
+
+// reprepare replaces every prepared statement, unless they have been
+// replaced since generation was read. database/sql prepares a statement
+// again on each new connection by itself, so this is only needed when a
+// live connection loses its statements, as after DISCARD ALL from a pooler,
+// or when a migration changes a table a plan was built on. Whether the
+// upsert needs the lock is checked again, as a migration to time
+// sub-partitions drops the unique key ON CONFLICT relies on.
+func (r *DecisionRepository) reprepare(generation int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation != generation {
+		return nil
+	}
+
+	lockedUpsert, err := NeedsLockedUpsert(r.db)
+	if err != nil {
+		return err
+	}
+	queries := repositoryQueries(lockedUpsert)
+
+	stmts := make(map[string]*sql.Stmt, len(queries))
+	for name, query := range queries {
+		stmt, err := r.db.Prepare(query)
+		if err != nil {
+			for _, s := range stmts {
+				s.Close()
+			}
+			return fmt.Errorf("failed to prepare %s: %w", name, err)
+		}
+		stmts[name] = stmt
+	}
+
+	for _, stmt := range r.stmts {
+		stmt.Close()
+	}
+	r.stmts = stmts
+	r.queries = queries
+	r.lockedUpsert = lockedUpsert
+	r.generation++
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+)
+
+// ShardMap assigns hash buckets of recipient_user_id to shards. Buckets are
+// fixed for the lifetime of the data; rebalancing moves bucket ranges between
+// shards rather than changing the bucket count.
+type ShardMap struct {
+	Buckets int          `json:"buckets"`
+	Ranges  []ShardRange `json:"ranges"`
+}
+
+// ShardRange places buckets From..To, inclusive, on Shard.
+type ShardRange struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Shard int `json:"shard"`
+}
+
+// EvenShardMap spreads buckets evenly over shards.
+func EvenShardMap(buckets, shards int) ShardMap {
+	m := ShardMap{Buckets: buckets}
+	for i := range shards {
+		m.Ranges = append(m.Ranges, ShardRange{
+			From:  i * buckets / shards,
+			To:    (i+1)*buckets/shards - 1,
+			Shard: i,
+		})
+	}
+	return m
+}
+
+// LoadShardMap reads a JSON shard map from path.
+func LoadShardMap(path string) (ShardMap, error) {
+	var m ShardMap
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("failed to read shard map: %w", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse shard map: %w", err)
+	}
+
+	return m, nil
+}
+
+// Validate checks that every bucket is assigned to exactly one of shards.
+func (m ShardMap) Validate(shards int) error {
+	if m.Buckets <= 0 {
+		return fmt.Errorf("shard map must have at least one bucket")
+	}
+
+	seen := make([]bool, m.Buckets)
+	for _, r := range m.Ranges {
+		if r.Shard < 0 || r.Shard >= shards {
+			return fmt.Errorf("shard map range %d-%d points at unknown shard %d", r.From, r.To, r.Shard)
+		}
+		if r.From < 0 || r.To >= m.Buckets || r.From > r.To {
+			return fmt.Errorf("shard map range %d-%d is outside 0-%d", r.From, r.To, m.Buckets-1)
+		}
+		for b := r.From; b <= r.To; b++ {
+			if seen[b] {
+				return fmt.Errorf("shard map assigns bucket %d twice", b)
+			}
+			seen[b] = true
+		}
+	}
+	for b, ok := range seen {
+		if !ok {
+			return fmt.Errorf("shard map leaves bucket %d unassigned", b)
+		}
+	}
+
+	return nil
+}
+
+// ShardFor returns the shard holding rows for recipientID.
+func (m ShardMap) ShardFor(recipientID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(recipientID))
+	bucket := int(h.Sum32() % uint32(m.Buckets))
+
+	for _, r := range m.Ranges {
+		if bucket >= r.From && bucket <= r.To {
+			return r.Shard
+		}
+	}
+	return 0
+}
+
+// ShardedDecisionRepository places each decision on the shard owning its
+// recipient_user_id, so recipient-centric reads hit a single shard. Lookups
+// in the reverse direction (did the recipient like the actor back?) go to the
+// shard owning the actor.
+type ShardedDecisionRepository struct {
+	shards   []*DecisionRepository
+	shardMap ShardMap
+	decided  *DecidedCache
+}
+
+func NewShardedDecisionRepository(shards []*DecisionRepository, shardMap ShardMap) (*ShardedDecisionRepository, error) {
+	if err := shardMap.Validate(len(shards)); err != nil {
+		return nil, err
+	}
+
+	return &ShardedDecisionRepository{shards: shards, shardMap: shardMap}, nil
+}
+
+// UseDecidedCache lets ListNewLikedYou skip the cross-shard lookup for
+// likers c says the recipient has certainly not decided about. c must be
+// kept current with DecidedCache.Add.
+func (s *ShardedDecisionRepository) UseDecidedCache(c *DecidedCache) {
+	s.decided = c
+}
+
+func (s *ShardedDecisionRepository) shardFor(recipientID string) *DecisionRepository {
+	return s.shards[s.shardMap.ShardFor(recipientID)]
+}
+
+func (s *ShardedDecisionRepository) Close() error {
+	for _, shard := range s.shards {
+		shard.Close()
+	}
+	return nil
+}
+
+// Check fails if any shard fails its check.
+func (s *ShardedDecisionRepository) Check(ctx context.Context) error {
+	for i, shard := range s.shards {
+		if err := shard.Check(ctx); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *ShardedDecisionRepository) PutDecision(ctx context.Context, d *models.Decision) error {
+	return s.shardFor(d.RecipientUserId).PutDecision(ctx, d)
+}
+
+func (s *ShardedDecisionRepository) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	return s.shardFor(recipientID).CountLikedYou(ctx, recipientID)
+}
+
+func (s *ShardedDecisionRepository) ListLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+	return s.shardFor(recipientID).ListLikedYou(ctx, recipientID, paginationToken)
+}
+
+func (s *ShardedDecisionRepository) IsMutual(ctx context.Context, actorID, recipientID string) (bool, error) {
+	actorLikedRecipient, err := s.shardFor(recipientID).Liked(ctx, actorID, recipientID)
+	if err != nil || !actorLikedRecipient {
+		return false, err
+	}
+
+	return s.shardFor(actorID).Liked(ctx, recipientID, actorID)
+}
+
+// newLikedYouMaxPages bounds the pages of likers ListNewLikedYou reads per
+// call, so a recipient who liked back most of their likers costs a bounded
+// number of cross-shard lookups.
+const newLikedYouMaxPages = 4
+
+// ListNewLikedYou pages through the recipient's likers on its own shard and
+// drops those the recipient liked back, checking each liker's shard. Pages
+// are fetched until a full page survives the filter, likers run out or
+// newLikedYouMaxPages pages have been read. In the last case the page can
+// be short, even empty, and its token carries on after the last liker read.
+func (s *ShardedDecisionRepository) ListNewLikedYou(ctx context.Context, recipientID string, paginationToken string) ([]models.Decision, string, error) {
+	var decisions []models.Decision
+	shard := s.shardFor(recipientID)
+
+	for range newLikedYouMaxPages {
+		page, next, err := shard.ListLikedYou(ctx, recipientID, paginationToken)
+		if err != nil {
+			return nil, "", err
+		}
+
+		likedBack, err := s.likedBack(ctx, recipientID, page)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, d := range page {
+			if !likedBack[d.ActorUserId] {
+				decisions = append(decisions, d)
+			}
+		}
+
+		if len(decisions) > shard.pageSize {
+			decisions = decisions[:shard.pageSize]
+			return decisions, decisions[shard.pageSize-1].CreatedAt.Format(paginationTokenLayout), nil
+		}
+		if next == "" {
+			return decisions, "", nil
+		}
+		paginationToken = next
+	}
+
+	return decisions, paginationToken, nil
+}
+
+// likedBack reports which likers the recipient has liked in return. With a
+// decided cache only the likers the recipient may have decided about are
+// looked up; the cache failing means looking them all up.
+func (s *ShardedDecisionRepository) likedBack(ctx context.Context, recipientID string, likers []models.Decision) (map[string]bool, error) {
+	ids := make([]string, len(likers))
+	for i, d := range likers {
+		ids[i] = d.ActorUserId
+	}
+	if s.decided != nil && len(ids) > 0 {
+		if maybe, err := s.decided.MaybeDecided(ctx, recipientID, ids); err == nil {
+			ids = maybe
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	byShard := make(map[int][]string)
+	for _, id := range ids {
+		shard := s.shardMap.ShardFor(id)
+		byShard[shard] = append(byShard[shard], id)
+	}
+
+	likedBack := make(map[string]bool)
+	for shard, ids := range byShard {
+		liked, err := s.shards[shard].FilterLiked(ctx, recipientID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for id := range liked {
+			likedBack[id] = true
+		}
+	}
+
+	return likedBack, nil
+}
+
+// DecidedRecipients gathers the actor's decisions from every shard, since
+// rows are placed by recipient.
+func (s *ShardedDecisionRepository) DecidedRecipients(ctx context.Context, actorID string) ([]string, error) {
+	var recipients []string
+	for _, shard := range s.shards {
+		ids, err := shard.DecidedRecipients(ctx, actorID)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, ids...)
+	}
+
+	return recipients, nil
+}
+
+func (s *ShardedDecisionRepository) FilterDecided(ctx context.Context, actorID string, recipientIDs []string) (map[string]bool, error) {
+	byShard := make(map[int][]string)
+	for _, id := range recipientIDs {
+		shard := s.shardMap.ShardFor(id)
+		byShard[shard] = append(byShard[shard], id)
+	}
+
+	decided := make(map[string]bool)
+	for shard, ids := range byShard {
+		found, err := s.shards[shard].FilterDecided(ctx, actorID, ids)
+		if err != nil {
+			return nil, err
+		}
+		for id := range found {
+			decided[id] = true
+		}
+	}
+
+	return decided, nil
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// scope is where one repository call runs its queries. Connections run
+// with the statement_timeout given to NewConnector, so Postgres stops working
+// on a query the caller has given up on even if the cancel request sent by
+// the driver is lost. A call with much less time left than that, or with
+// more, as under a longer method timeout, is a transaction setting
+// statement_timeout to the time left; other calls go straight to the pool
+// and its prepared statements.
+type scope struct {
+	r  *DecisionRepository
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// WithStatementTimeout tells the repository the statement_timeout its
+// connections run with, as set by NewConnector. Without it every call with
+// a deadline sets its own.
+func WithStatementTimeout(d time.Duration) RepositoryOption {
+	return func(r *DecisionRepository) {
+		r.statementTimeout = d
+	}
+}
+
+// NewConnector returns a connector for the Postgres dsn whose connections
+// run with statement_timeout set to timeout, sent once as each connection
+// is opened. Zero leaves the server's setting.
+func NewConnector(dsn string, timeout time.Duration) (driver.Connector, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		return connector, nil
+	}
+
+	return &timeoutConnector{
+		Connector: connector,
+		set:       fmt.Sprintf("SET statement_timeout = %d", timeout.Milliseconds()),
+	}, nil
+}
+
+type timeoutConnector struct {
+	driver.Connector
+	set string
+}
+
+func (c *timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.(driver.ExecerContext).ExecContext(ctx, c.set, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set statement_timeout: %w", err)
+	}
+
+	return conn, nil
+}
+
+// begin opens a scope on db for ctx.
+func (r *DecisionRepository) begin(ctx context.Context, db *sql.DB, readOnly bool) (*scope, error) {
+	if _, ok := r.callTimeout(ctx); !ok {
+		return &scope{r: r, db: db}, nil
+	}
+	return r.beginTx(ctx, db, readOnly)
+}
+
+// beginTx opens a transaction scope on db, setting statement_timeout to
+// the time ctx has left when that is well below the connection's or above
+// it.
+func (r *DecisionRepository) beginTx(ctx context.Context, db *sql.DB, readOnly bool) (*scope, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout, ok := r.callTimeout(ctx); ok {
+		// SET LOCAL can't take a parameter; set_config is its
+		// parameterised form and likewise ends with the transaction.
+		_, err := tx.ExecContext(ctx, "SELECT set_config('statement_timeout', $1, true)", strconv.FormatInt(timeout.Milliseconds(), 10))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return &scope{r: r, db: db, tx: tx}, nil
+}
+
+// callTimeout returns the statement_timeout for ctx when it has less than
+// half the connection's left, or more than all of it. The connection's
+// timeout then bounds the work done after the caller gives up to less than
+// twice the time it had without cutting a longer budget short, and calls
+// that got the usual budget skip the round trips of setting their own.
+func (r *DecisionRepository) callTimeout(ctx context.Context) (time.Duration, bool) {
+	timeout, ok := statementTimeout(ctx)
+	if !ok {
+		return 0, false
+	}
+	if r.statementTimeout > 0 && timeout >= r.statementTimeout/2 && timeout <= r.statementTimeout {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// statementTimeout returns the time left before ctx's deadline, rounded up
+// to the millisecond statement_timeout counts in. ok is false without a
+// deadline.
+func statementTimeout(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+
+	left := time.Until(deadline)
+	if left < time.Millisecond {
+		// 0 would disable the timeout; the context is about to expire
+		// and cancel the query anyway.
+		return time.Millisecond, true
+	}
+	return left.Truncate(time.Millisecond) + time.Millisecond, true
+}
+
+// stmt returns the named prepared statement for use in the scope.
+func (s *scope) stmt(ctx context.Context, name string) *sql.Stmt {
+	if s.tx != nil {
+		return s.tx.StmtContext(ctx, s.r.stmt(name))
+	}
+	return s.r.stmt(name)
+}
+
+// queryRow runs the named statement, prepared on the primary and sent as
+// text to a replica.
+func (s *scope) queryRow(ctx context.Context, name string, args ...any) *sql.Row {
+	if s.db != s.r.db {
+		return s.QueryRowContext(ctx, s.r.query(name), args...)
+	}
+	return s.stmt(ctx, name).QueryRowContext(ctx, args...)
+}
+
+func (s *scope) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if s.tx != nil {
+		return s.tx.QueryContext(ctx, query, args...)
+	}
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+func (s *scope) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if s.tx != nil {
+		return s.tx.QueryRowContext(ctx, query, args...)
+	}
+	return s.db.QueryRowContext(ctx, query, args...)
+}
+
+// commit ends the scope, keeping what it wrote.
+func (s *scope) commit() error {
+	if s.tx != nil {
+		return s.tx.Commit()
+	}
+	return nil
+}
+
+// rollback ends the scope if commit was not reached. It is meant to be
+// deferred.
+func (s *scope) rollback() {
+	if s.tx != nil {
+		s.tx.Rollback()
+	}
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fleimkeipa/grpc-example/internal/repository"
+
+// startQuerySpan starts a client span for the named statement. The tracer
+// is looked up on every call so a provider installed after start-up is
+// picked up.
+func startQuerySpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "decisions."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement.name", name),
+		),
+	)
+}
+
+// endQuerySpan records the rows read or written and any error, then ends
+// the span.
+func endQuerySpan(span trace.Span, rows int, err error) {
+	span.SetAttributes(attribute.Int("db.rows", rows))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
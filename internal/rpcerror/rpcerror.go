@@ -0,0 +1,186 @@
+package rpcerror
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// publicMessages are the only error texts clients see for sanitised codes.
+var publicMessages = map[codes.Code]string{
+	codes.Internal:         "internal error",
+	codes.Unavailable:      "service temporarily unavailable",
+	codes.Aborted:          "conflicting concurrent update, retry the call",
+	codes.AlreadyExists:    "decision already exists",
+	codes.DeadlineExceeded: "deadline exceeded",
+	codes.Canceled:         "request cancelled",
+}
+
+// retryDelays are suggested to clients for errors that are worth retrying.
+var retryDelays = map[codes.Code]time.Duration{
+	codes.Aborted:     50 * time.Millisecond,
+	codes.Unavailable: time.Second,
+}
+
+// RecoverInterceptor turns a panicking handler into an Internal error and
+// logs the panic with its stack instead of crashing the process.
+func RecoverInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "handler panicked",
+					"panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+				resp, err = nil, status.Errorf(codes.Internal, "panic: %v", p)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// SanitizeInterceptor replaces internal and database errors with a generic
+// message and a correlation ID before they reach the client. The full error
+// is logged server-side under the same ID. Database errors are first mapped
+// to the gRPC code that tells the client what to do about them, with a
+// RetryInfo where a retry may succeed. Errors already meant for the client,
+// such as InvalidArgument, pass through. Every error leaves with an
+// ErrorInfo reason; see pkg/apierror.
+func SanitizeInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		code, sanitize := Classify(ctx, err)
+		if !sanitize {
+			return resp, withErrorInfo(err)
+		}
+
+		id := logging.RequestID(ctx)
+		if id == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		logging.FromContext(ctx).ErrorContext(ctx, "request failed",
+			"code", code.String(), "correlation_id", id, "error", err)
+
+		opts := []apierror.Option{apierror.WithMetadata(map[string]string{"correlation_id": id})}
+		if delay, ok := retryDelays[code]; ok {
+			opts = append(opts, apierror.WithRetryDelay(delay))
+		}
+		msg := fmt.Sprintf("%s (correlation id: %s)", publicMessages[code], id)
+
+		return nil, apierror.New(code, apierror.CodeReason(code), msg, opts...)
+	}
+}
+
+// withErrorInfo gives a client-facing error without details the generic
+// ErrorInfo reason for its code, so every error carries one.
+func withErrorInfo(err error) error {
+	st := status.Convert(err)
+	if len(st.Details()) > 0 {
+		return err
+	}
+	return apierror.New(st.Code(), apierror.CodeReason(st.Code()), st.Message())
+}
+
+// Classify returns the gRPC code err should be reported with and whether
+// its message must be hidden from the client.
+func Classify(ctx context.Context, err error) (codes.Code, bool) {
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &pqErr):
+		return postgresCode(ctx, pqErr), true
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded, true
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled, true
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return codes.Unavailable, true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return codes.Unavailable, true
+	}
+
+	switch code := status.Code(err); code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return codes.Internal, true
+	default:
+		return code, false
+	}
+}
+
+func postgresCode(ctx context.Context, err *pq.Error) codes.Code {
+	switch err.Code {
+	case "23505": // unique_violation
+		return codes.AlreadyExists
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return codes.Aborted
+	case "57014": // query_canceled, by statement_timeout or our context
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return codes.Canceled
+		}
+		return codes.DeadlineExceeded
+	case "53300", "57P01", "57P02", "57P03": // too_many_connections, admin_shutdown, crash_shutdown, cannot_connect_now
+		return codes.Unavailable
+	}
+
+	if err.Code.Class() == "08" { // connection_exception
+		return codes.Unavailable
+	}
+
+	return codes.Internal
+}
+
+type callerDeadlineKey struct{}
+
+// WithCallerDeadline records the deadline ctx has when a call arrives,
+// before the server bounds it by its own timeout, for CallerExpired.
+func WithCallerDeadline(ctx context.Context) context.Context {
+	deadline, _ := ctx.Deadline()
+	return context.WithValue(ctx, callerDeadlineKey{}, deadline)
+}
+
+// CallerExpired reports whether the deadline the caller gave the call has
+// passed, so that a DeadlineExceeded is the caller's doing rather than a
+// sign of a slow server. Without WithCallerDeadline, ctx's own deadline is
+// taken to be the caller's.
+func CallerExpired(ctx context.Context) bool {
+	deadline, recorded := ctx.Value(callerDeadlineKey{}).(time.Time)
+	if !recorded {
+		deadline, _ = ctx.Deadline()
+	}
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// ServerTimeout reports whether err is a DeadlineExceeded the server's own
+// timeout caused: not the caller's deadline running out, nor a call turned
+// away for having too little of it left.
+func ServerTimeout(ctx context.Context, err error) bool {
+	if code, _ := Classify(ctx, err); code != codes.DeadlineExceeded {
+		return false
+	}
+	return !CallerExpired(ctx) && apierror.Reason(err) != apierror.ReasonDeadlineExceeded
+}
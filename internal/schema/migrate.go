@@ -0,0 +1,234 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	newTable = "decisions_partitioned"
+	oldTable = "decisions_unpartitioned"
+)
+
+// mirrorFunction keeps the partitioned copy in step with writes to the live
+// table while it is backfilled. The live table's row locks serialise writers
+// per (actor, recipient) pair, so update-then-insert cannot duplicate rows.
+const mirrorFunction = `
+	CREATE OR REPLACE FUNCTION decisions_mirror() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			DELETE FROM decisions_partitioned
+			WHERE actor_user_id = OLD.actor_user_id AND recipient_user_id = OLD.recipient_user_id;
+			RETURN OLD;
+		END IF;
+
+		UPDATE decisions_partitioned
+		SET liked_recipient = NEW.liked_recipient,
+			updated_at = COALESCE(NEW.updated_at, NOW())
+		WHERE actor_user_id = NEW.actor_user_id AND recipient_user_id = NEW.recipient_user_id;
+		IF NOT FOUND THEN
+			INSERT INTO decisions_partitioned (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+			VALUES (NEW.actor_user_id, NEW.recipient_user_id, NEW.liked_recipient,
+				COALESCE(NEW.created_at, NOW()), COALESCE(NEW.updated_at, NOW()));
+		END IF;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS decisions_mirror ON decisions;
+	CREATE TRIGGER decisions_mirror
+	AFTER INSERT OR UPDATE OR DELETE ON decisions
+	FOR EACH ROW EXECUTE FUNCTION decisions_mirror();
+	`
+
+// backfillBatch copies the next batch of rows after the ($1, $2) cursor.
+// FOR SHARE waits out in-flight writers and blocks new ones on these rows
+// until the batch commits, after which the trigger sees the copied row.
+const backfillBatch = `
+	WITH batch AS (
+		SELECT actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at
+		FROM decisions
+		WHERE (actor_user_id, recipient_user_id) > ($1, $2)
+		ORDER BY actor_user_id, recipient_user_id
+		LIMIT $3
+		FOR SHARE
+	), copied AS (
+		INSERT INTO decisions_partitioned (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+		SELECT b.actor_user_id, b.recipient_user_id, b.liked_recipient, COALESCE(b.created_at, NOW()), COALESCE(b.updated_at, NOW())
+		FROM batch b
+		WHERE NOT EXISTS (
+			SELECT 1 FROM decisions_partitioned p
+			WHERE p.actor_user_id = b.actor_user_id AND p.recipient_user_id = b.recipient_user_id
+		)
+	)
+	SELECT COUNT(*), MAX(actor_user_id), MAX(recipient_user_id) FILTER (
+		WHERE actor_user_id = (SELECT MAX(actor_user_id) FROM batch)
+	)
+	FROM batch
+	`
+
+// ConvertToPartitioned converts an existing unpartitioned decisions table
+// into the layout chosen by opts without blocking writers for the length of
+// the copy:
+//
+//  1. create decisions_partitioned and a trigger mirroring live writes into it;
+//  2. backfill existing rows in primary-key order, batchSize rows at a time;
+//  3. swap the tables by renaming them, their partitions and indexes under a
+//     brief exclusive lock.
+//
+// The original table is kept as decisions_unpartitioned for rollback. The
+// conversion can be re-run after a failure; it resumes the backfill.
+func ConvertToPartitioned(ctx context.Context, db *sql.DB, opts Options, batchSize int) error {
+	if opts.HashPartitions <= 0 {
+		return fmt.Errorf("conversion needs at least one hash partition")
+	}
+	if batchSize <= 0 {
+		batchSize = 5000
+	}
+
+	exists, partitioned, err := inspect(ctx, db, "decisions")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("decisions table does not exist")
+	}
+	if partitioned {
+		slog.Info("decisions is already partitioned", "component", "schema")
+		return nil
+	}
+
+	staged, _, err := inspect(ctx, db, newTable)
+	if err != nil {
+		return err
+	}
+	if !staged {
+		if err := createPartitioned(ctx, db, newTable, opts); err != nil {
+			return err
+		}
+	}
+	if err := ensureTimePartitions(ctx, db, newTable, opts, time.Now()); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, mirrorFunction); err != nil {
+		return fmt.Errorf("failed to install mirror trigger: %w", err)
+	}
+
+	if err := backfill(ctx, db, batchSize); err != nil {
+		return err
+	}
+
+	return swap(ctx, db)
+}
+
+func backfill(ctx context.Context, db *sql.DB, batchSize int) error {
+	var actor, recipient string
+	var copied int64
+	start := time.Now()
+
+	for {
+		var n int64
+		var lastActor, lastRecipient sql.NullString
+		err := db.QueryRowContext(ctx, backfillBatch, actor, recipient, batchSize).Scan(&n, &lastActor, &lastRecipient)
+		if err != nil {
+			return fmt.Errorf("failed to backfill after (%s, %s): %w", actor, recipient, err)
+		}
+		if n == 0 {
+			break
+		}
+
+		actor, recipient = lastActor.String, lastRecipient.String
+		copied += n
+		slog.Info("backfilled rows", "component", "schema", "rows", copied, "elapsed", time.Since(start).Round(time.Second))
+	}
+
+	return nil
+}
+
+func swap(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to swap tables: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`SET LOCAL lock_timeout = '5s'`,
+		`LOCK TABLE decisions IN ACCESS EXCLUSIVE MODE`,
+		`DROP TRIGGER decisions_mirror ON decisions`,
+		`DROP FUNCTION decisions_mirror()`,
+		fmt.Sprintf(`ALTER TABLE decisions RENAME TO %s`, oldTable),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO decisions`, newTable),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to swap tables: %w", err)
+		}
+	}
+
+	// Partitions and indexes are named after the table they were created
+	// for; give them the names createPartitioned gives decisions.
+	renames, err := derivedNames(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("failed to swap tables: %w", err)
+	}
+	for _, stmt := range renames {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to swap tables: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to swap tables: %w", err)
+	}
+
+	slog.Info("decisions is now partitioned", "component", "schema", "old_table", oldTable)
+	return nil
+}
+
+// derivedNames returns the statements renaming the partitions of decisions
+// and its secondary indexes from the staging table's prefix to decisions.
+func derivedNames(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT FALSE, c.relname
+		FROM pg_partition_tree('decisions') t
+		JOIN pg_class c ON c.oid = t.relid
+		WHERE t.level > 0
+		UNION ALL
+		SELECT TRUE, c.relname
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = 'decisions'::regclass
+		  -- the old table's primary key keeps decisions_pkey
+		  AND NOT i.indisprimary
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stmts []string
+	for rows.Next() {
+		var index bool
+		var name string
+		if err := rows.Scan(&index, &name); err != nil {
+			return nil, err
+		}
+		suffix, ok := strings.CutPrefix(name, newTable)
+		if !ok {
+			continue
+		}
+		kind := "TABLE"
+		if index {
+			kind = "INDEX"
+		}
+		stmts = append(stmts, fmt.Sprintf(`ALTER %s %s RENAME TO decisions%s`, kind, name, suffix))
+	}
+
+	return stmts, rows.Err()
+}
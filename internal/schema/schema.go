@@ -0,0 +1,212 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Options selects the physical layout of the decisions table.
+type Options struct {
+	// HashPartitions splits the table by hash of recipient_user_id. Zero
+	// keeps a plain, unpartitioned table.
+	HashPartitions int
+	// TimePartitions further splits every hash partition into monthly
+	// ranges of created_at. Postgres requires unique keys on a partitioned
+	// table to include every partition column, so the primary key then
+	// covers created_at too and pair uniqueness is enforced by the
+	// repository instead of ON CONFLICT.
+	TimePartitions bool
+	// MonthsAhead is how many future monthly partitions are kept ready.
+	MonthsAhead int
+}
+
+const plainSchema = `
+	CREATE TABLE IF NOT EXISTS decisions (
+		actor_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		liked_recipient BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW(),
+		PRIMARY KEY (actor_user_id, recipient_user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_decisions_created_at ON decisions (created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_decisions_updated_at ON decisions (updated_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_recipient_user_id ON decisions (recipient_user_id) WHERE liked_recipient = true;
+	CREATE INDEX IF NOT EXISTS idx_actor_user_id ON decisions (actor_user_id) WHERE liked_recipient = true;
+	`
+
+// Apply creates the decisions table in the layout chosen by opts. An
+// existing table is left untouched; use ConvertToPartitioned to change its
+// layout.
+func Apply(ctx context.Context, db *sql.DB, opts Options) error {
+	if opts.HashPartitions <= 0 {
+		if _, err := db.ExecContext(ctx, plainSchema); err != nil {
+			return fmt.Errorf("failed to create decisions table: %w", err)
+		}
+		return nil
+	}
+
+	exists, partitioned, err := inspect(ctx, db, "decisions")
+	if err != nil {
+		return err
+	}
+	if exists && !partitioned {
+		slog.Warn("decisions is not partitioned; run the migrate command to convert it", "component", "schema")
+		return nil
+	}
+	if !exists {
+		if err := createPartitioned(ctx, db, "decisions", opts); err != nil {
+			return err
+		}
+	}
+
+	return EnsureTimePartitions(ctx, db, opts, time.Now())
+}
+
+// inspect reports whether table exists and whether it is partitioned.
+func inspect(ctx context.Context, db *sql.DB, table string) (bool, bool, error) {
+	var kind sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT relkind::text FROM pg_class WHERE oid = to_regclass($1)`, table).Scan(&kind)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+
+	return true, kind.String == "p", nil
+}
+
+// createPartitioned creates table hash partitioned on recipient_user_id,
+// with a DEFAULT time sub-partition per hash partition when requested.
+// Partitions and indexes are named after table: table_p0, table_p0_default,
+// table_created_at_idx and so on.
+func createPartitioned(ctx context.Context, db *sql.DB, table string, opts Options) error {
+	primaryKey := "actor_user_id, recipient_user_id"
+	if opts.TimePartitions {
+		primaryKey += ", created_at"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+	CREATE TABLE %s (
+		actor_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		liked_recipient BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (%s)
+	) PARTITION BY HASH (recipient_user_id);
+	`, table, primaryKey)
+
+	for i := range opts.HashPartitions {
+		partition := hashPartition(table, i)
+		fmt.Fprintf(&b, `CREATE TABLE %s PARTITION OF %s FOR VALUES WITH (MODULUS %d, REMAINDER %d)`,
+			partition, table, opts.HashPartitions, i)
+		if opts.TimePartitions {
+			fmt.Fprintf(&b, ` PARTITION BY RANGE (created_at);
+	CREATE TABLE %[1]s_default PARTITION OF %[1]s DEFAULT`, partition)
+		}
+		b.WriteString(";\n")
+	}
+
+	fmt.Fprintf(&b, `
+	CREATE INDEX %[1]s_created_at_idx ON %[1]s (created_at DESC);
+	CREATE INDEX %[1]s_updated_at_idx ON %[1]s (updated_at DESC);
+	CREATE INDEX %[1]s_recipient_liked_idx ON %[1]s (recipient_user_id, created_at DESC) WHERE liked_recipient = true;
+	CREATE INDEX %[1]s_actor_liked_idx ON %[1]s (actor_user_id) WHERE liked_recipient = true;
+	`, table)
+
+	if _, err := db.ExecContext(ctx, b.String()); err != nil {
+		return fmt.Errorf("failed to create partitioned %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// hashPartition names hash partition i of table.
+func hashPartition(table string, i int) string {
+	return fmt.Sprintf("%s_p%d", table, i)
+}
+
+// EnsureTimePartitions creates monthly created_at partitions of decisions
+// from the month of now through opts.MonthsAhead months later. Rows outside
+// that window land in each hash partition's DEFAULT partition.
+func EnsureTimePartitions(ctx context.Context, db *sql.DB, opts Options, now time.Time) error {
+	return ensureTimePartitions(ctx, db, "decisions", opts, now)
+}
+
+func ensureTimePartitions(ctx context.Context, db *sql.DB, table string, opts Options, now time.Time) error {
+	if opts.HashPartitions <= 0 || !opts.TimePartitions {
+		return nil
+	}
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for range opts.MonthsAhead + 1 {
+		next := month.AddDate(0, 1, 0)
+		for i := range opts.HashPartitions {
+			parent := hashPartition(table, i)
+			name := fmt.Sprintf("%s_%s", parent, month.Format("200601"))
+
+			exists, _, err := inspect(ctx, db, name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+
+			if err := attachMonth(ctx, db, parent, name, month, next); err != nil {
+				return err
+			}
+		}
+		month = next
+	}
+
+	return nil
+}
+
+// attachMonth creates the partition name of parent for from..to. A new
+// range can't be attached while the DEFAULT partition holds rows belonging
+// to it, which is the case for the current month on a table created
+// mid-month or for imported history, so those rows are moved across first.
+// The DEFAULT partition is locked throughout, so no row for the range can be
+// written to it between the move and the attach; writers wait for the
+// attach and are then routed to the new partition.
+func attachMonth(ctx context.Context, db *sql.DB, parent, name string, from, to time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create partition %s: %w", name, err)
+	}
+	defer tx.Rollback()
+
+	lower, upper := from.Format(time.RFC3339), to.Format(time.RFC3339)
+	stmts := []string{
+		`SET LOCAL lock_timeout = '5s'`,
+		// moving rows may outlast a server connection's statement_timeout
+		`SET LOCAL statement_timeout = 0`,
+		// ATTACH takes this lock on the DEFAULT partition anyway; taking it
+		// first keeps the move and the attach from racing writers.
+		fmt.Sprintf(`LOCK TABLE %s_default IN ACCESS EXCLUSIVE MODE`, parent),
+		fmt.Sprintf(`CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS)`, name, parent),
+		fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM %[1]s_default WHERE created_at >= '%[3]s' AND created_at < '%[4]s'
+			RETURNING *
+		)
+		INSERT INTO %[2]s SELECT * FROM moved`, parent, name, lower, upper),
+		fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`,
+			parent, name, lower, upper),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+	}
+
+	return tx.Commit()
+}
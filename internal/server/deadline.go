@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultRequestTimeout bounds RPCs that TimeoutPolicy doesn't name.
+const DefaultRequestTimeout = 3 * time.Second
+
+// TimeoutPolicy bounds the time each RPC may take. A client deadline
+// shorter than the policy's always wins.
+type TimeoutPolicy struct {
+	// Default applies to methods missing from Methods.
+	Default time.Duration
+	// Methods overrides Default per RPC, keyed by method name, e.g.
+	// "PutDecision".
+	Methods map[string]time.Duration
+	// MinBudget is the least time a call must have left to start. Calls
+	// with less fail fast with DeadlineExceeded rather than queue for a
+	// connection and time out in the database.
+	MinBudget time.Duration
+}
+
+// Timeout returns the time method may take.
+func (p TimeoutPolicy) Timeout(method string) time.Duration {
+	if d, ok := p.Methods[method]; ok && d > 0 {
+		return d
+	}
+	if p.Default > 0 {
+		return p.Default
+	}
+	return DefaultRequestTimeout
+}
+
+// budget bounds ctx by method's timeout and checks enough of it is left to
+// be worth starting. The caller's own deadline is recorded first, so that
+// the repository can tell it running out from the server's timeout firing.
+func (s *ExploreServer) budget(ctx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(rpcerror.WithCallerDeadline(ctx), s.timeouts.Timeout(method))
+
+	deadline, _ := ctx.Deadline()
+	if left := time.Until(deadline); left < s.timeouts.MinBudget {
+		cancel()
+		return nil, nil, apierror.New(codes.DeadlineExceeded, apierror.ReasonDeadlineExceeded,
+			"deadline too close to start the call",
+			apierror.WithMetadata(map[string]string{
+				"remaining":  max(left, 0).String(),
+				"min_budget": s.timeouts.MinBudget.String(),
+			}))
+	}
+
+	return ctx, cancel, nil
+}
@@ -2,45 +2,115 @@ package server
 
 import (
 	"context"
-	"time"
-	"unicode"
 
+	"github.com/fleimkeipa/grpc-example/internal/auth"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
 	"github.com/fleimkeipa/grpc-example/internal/models"
 	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
 	pb "github.com/fleimkeipa/grpc-example/proto"
 
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type ExploreServer struct {
 	pb.UnimplementedExploreServiceServer
-	repo *repository.DecisionRepository
+	repo     repository.Decisions
+	decided  *repository.DecidedCache
+	observer Observer
+	userIDs  UserIDValidator
+	timeouts TimeoutPolicy
 }
 
-func NewExploreServer(repo *repository.DecisionRepository) *ExploreServer {
-	return &ExploreServer{repo: repo}
+// Observer is told about business events as ExploreServer handles them.
+type Observer interface {
+	// DecisionRecorded reports a stored decision and whether it replaced
+	// an earlier one for the same pair.
+	DecisionRecorded(liked, overwrote bool)
+	// MatchCreated reports a new like that completed a mutual like.
+	MatchCreated()
+}
+
+type noopObserver struct{}
+
+func (noopObserver) DecisionRecorded(bool, bool) {}
+func (noopObserver) MatchCreated()               {}
+
+// Option configures optional ExploreServer collaborators.
+type Option func(*ExploreServer)
+
+// WithDecidedCache keeps the per-actor decided-set cache current on every
+// PutDecision.
+func WithDecidedCache(c *repository.DecidedCache) Option {
+	return func(s *ExploreServer) {
+		s.decided = c
+	}
+}
+
+// WithObserver reports decisions and matches to o.
+func WithObserver(o Observer) Option {
+	return func(s *ExploreServer) {
+		s.observer = o
+	}
+}
+
+// WithUserIDValidator checks and normalises the user IDs of every request
+// with v instead of requiring numeric IDs.
+func WithUserIDValidator(v UserIDValidator) Option {
+	return func(s *ExploreServer) {
+		s.userIDs = v
+	}
+}
+
+// WithTimeoutPolicy bounds each RPC by p instead of DefaultRequestTimeout.
+func WithTimeoutPolicy(p TimeoutPolicy) Option {
+	return func(s *ExploreServer) {
+		s.timeouts = p
+	}
+}
+
+func NewExploreServer(repo repository.Decisions, opts ...Option) *ExploreServer {
+	numeric, _ := NewUserIDValidator(UserIDNumeric, "", DefaultMaxUserIDLength)
+	s := &ExploreServer{repo: repo, observer: noopObserver{}, userIDs: numeric}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *ExploreServer) PutDecision(ctx context.Context, req *pb.PutDecisionRequest) (*pb.PutDecisionResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer cancel()
+	actorID, err := s.userID("actor_user_id", req.ActorUserId)
+	if err != nil {
+		return nil, err
+	}
 
-	if req.ActorUserId == req.RecipientUserId {
-		return nil, status.Error(codes.InvalidArgument, "you can't like yourself (at least this project)")
+	recipientID, err := s.userID("recipient_user_id", req.RecipientUserId)
+	if err != nil {
+		return nil, err
 	}
 
-	if !isNumeric(req.ActorUserId) || len(req.ActorUserId) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "actor id must be number")
+	if actorID == recipientID {
+		return nil, apierror.New(codes.InvalidArgument, apierror.ReasonSelfDecision,
+			"you can't like yourself (at least this project)",
+			apierror.WithFieldViolations(apierror.FieldViolation{
+				Field:       "recipient_user_id",
+				Description: "must differ from actor_user_id",
+			}))
 	}
 
-	if !isNumeric(req.RecipientUserId) || len(req.RecipientUserId) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "recipient id must be number")
+	if err := auth.Authorize(ctx, actorID); err != nil {
+		return nil, err
 	}
 
+	ctx, cancel, err := s.budget(ctx, "PutDecision")
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
 	decision := &models.Decision{
-		ActorUserId:     req.ActorUserId,
-		RecipientUserId: req.RecipientUserId,
+		ActorUserId:     actorID,
+		RecipientUserId: recipientID,
 		LikedRecipient:  req.LikedRecipient,
 	}
 
@@ -48,23 +118,45 @@ func (s *ExploreServer) PutDecision(ctx context.Context, req *pb.PutDecisionRequ
 		return nil, err
 	}
 
-	mutual, err := s.repo.IsMutual(ctx, req.ActorUserId, req.RecipientUserId)
+	if s.decided != nil {
+		s.decided.Add(actorID, recipientID)
+	}
+
+	overwrote := decision.UpdatedAt.After(decision.CreatedAt)
+	s.observer.DecisionRecorded(req.LikedRecipient, overwrote)
+	logging.FromContext(ctx).DebugContext(ctx, "decision stored", "liked", req.LikedRecipient, "overwrote", overwrote)
+
+	mutual, err := s.repo.IsMutual(ctx, actorID, recipientID)
 	if err != nil {
 		return nil, err
 	}
 
+	// Repeating a like doesn't make a new match, but a pass turned into
+	// a like does.
+	if mutual && req.LikedRecipient && !decision.PreviouslyLiked {
+		s.observer.MatchCreated()
+	}
+
 	return &pb.PutDecisionResponse{MutualLikes: mutual && req.LikedRecipient}, nil
 }
 
 func (s *ExploreServer) CountLikedYou(ctx context.Context, req *pb.CountLikedYouRequest) (*pb.CountLikedYouResponse, error) {
-	if req.RecipientUserId == "" {
-		return nil, status.Error(codes.InvalidArgument, "recipient_user_id required")
+	recipientID, err := s.userID("recipient_user_id", req.RecipientUserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.Authorize(ctx, recipientID); err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	ctx, cancel, err := s.budget(ctx, "CountLikedYou")
+	if err != nil {
+		return nil, err
+	}
 	defer cancel()
 
-	count, err := s.repo.CountLikedYou(ctx, req.RecipientUserId)
+	count, err := s.repo.CountLikedYou(ctx, recipientID)
 	if err != nil {
 		return nil, err
 	}
@@ -73,11 +165,19 @@ func (s *ExploreServer) CountLikedYou(ctx context.Context, req *pb.CountLikedYou
 }
 
 func (s *ExploreServer) ListLikedYou(ctx context.Context, req *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
-	if req.RecipientUserId == "" {
-		return nil, status.Error(codes.InvalidArgument, "recipient_user_id required")
+	recipientID, err := s.userID("recipient_user_id", req.RecipientUserId)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	if err := auth.Authorize(ctx, recipientID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel, err := s.budget(ctx, "ListLikedYou")
+	if err != nil {
+		return nil, err
+	}
 	defer cancel()
 
 	// Get pagination token
@@ -86,7 +186,7 @@ func (s *ExploreServer) ListLikedYou(ctx context.Context, req *pb.ListLikedYouRe
 		paginationToken = *req.PaginationToken
 	}
 
-	decisions, nextToken, err := s.repo.ListLikedYou(ctx, req.RecipientUserId, paginationToken)
+	decisions, nextToken, err := s.repo.ListLikedYou(ctx, recipientID, paginationToken)
 	if err != nil {
 		return nil, err
 	}
@@ -108,11 +208,19 @@ func (s *ExploreServer) ListLikedYou(ctx context.Context, req *pb.ListLikedYouRe
 }
 
 func (s *ExploreServer) ListNewLikedYou(ctx context.Context, req *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
-	if req.RecipientUserId == "" {
-		return nil, status.Error(codes.InvalidArgument, "recipient_user_id required")
+	recipientID, err := s.userID("recipient_user_id", req.RecipientUserId)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	if err := auth.Authorize(ctx, recipientID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel, err := s.budget(ctx, "ListNewLikedYou")
+	if err != nil {
+		return nil, err
+	}
 	defer cancel()
 
 	// Get pagination token
@@ -121,7 +229,7 @@ func (s *ExploreServer) ListNewLikedYou(ctx context.Context, req *pb.ListLikedYo
 		paginationToken = *req.PaginationToken
 	}
 
-	decisions, nextToken, err := s.repo.ListNewLikedYou(ctx, req.RecipientUserId, paginationToken)
+	decisions, nextToken, err := s.repo.ListNewLikedYou(ctx, recipientID, paginationToken)
 	if err != nil {
 		return nil, err
 	}
@@ -142,14 +250,19 @@ func (s *ExploreServer) ListNewLikedYou(ctx context.Context, req *pb.ListLikedYo
 	return response, nil
 }
 
-func isNumeric(s string) bool {
-	if s == "" {
-		return false
+// userID validates a user ID field of a request and returns its canonical
+// form.
+func (s *ExploreServer) userID(field, id string) (string, error) {
+	if id == "" {
+		return "", apierror.New(codes.InvalidArgument, apierror.ReasonMissingField, field+" required",
+			apierror.WithFieldViolations(apierror.FieldViolation{Field: field, Description: "required"}))
 	}
-	for _, r := range s {
-		if !unicode.IsDigit(r) {
-			return false
-		}
+
+	canonical, err := s.userIDs.Validate(id)
+	if err != nil {
+		return "", apierror.New(codes.InvalidArgument, apierror.ReasonInvalidUserID, field+" is invalid",
+			apierror.WithFieldViolations(apierror.FieldViolation{Field: field, Description: err.Error()}))
 	}
-	return true
+
+	return canonical, nil
 }
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxUserIDLength bounds user IDs when no limit is configured.
+const DefaultMaxUserIDLength = 64
+
+// UserIDValidator checks a user ID and returns its canonical form, which is
+// what gets stored and compared.
+type UserIDValidator interface {
+	Validate(id string) (string, error)
+}
+
+// UserIDFormat names a built-in validator.
+type UserIDFormat string
+
+const (
+	UserIDNumeric UserIDFormat = "numeric"
+	UserIDUUID    UserIDFormat = "uuid"
+	UserIDULID    UserIDFormat = "ulid"
+	UserIDRegex   UserIDFormat = "regex"
+)
+
+// NewUserIDValidator returns the validator for format. pattern is required
+// for UserIDRegex and ignored otherwise. Every validator NFKC-normalises the
+// ID first, so visually identical IDs can't be stored twice, and rejects IDs
+// longer than maxLength characters (DefaultMaxUserIDLength when zero).
+func NewUserIDValidator(format UserIDFormat, pattern string, maxLength int) (UserIDValidator, error) {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxUserIDLength
+	}
+
+	var v UserIDValidator
+	switch format {
+	case UserIDNumeric, "":
+		v = numericUserID{}
+	case UserIDUUID:
+		v = uuidUserID{}
+	case UserIDULID:
+		v = ulidUserID{}
+	case UserIDRegex:
+		if pattern == "" {
+			return nil, errors.New("regex user ID format needs a pattern")
+		}
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID pattern: %w", err)
+		}
+		v = regexUserID{re: re}
+	default:
+		return nil, fmt.Errorf("unknown user ID format %q", format)
+	}
+
+	return normalizedUserID{next: v, maxLength: maxLength}, nil
+}
+
+type normalizedUserID struct {
+	next      UserIDValidator
+	maxLength int
+}
+
+func (v normalizedUserID) Validate(id string) (string, error) {
+	if !utf8.ValidString(id) {
+		return "", errors.New("must be valid UTF-8")
+	}
+
+	id = norm.NFKC.String(id)
+	if n := utf8.RuneCountInString(id); n > v.maxLength {
+		return "", fmt.Errorf("must be at most %d characters", v.maxLength)
+	}
+
+	return v.next.Validate(id)
+}
+
+type numericUserID struct{}
+
+func (numericUserID) Validate(id string) (string, error) {
+	if id == "" {
+		return "", errors.New("must be a non-empty string of digits")
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return "", errors.New("must be a non-empty string of digits")
+		}
+	}
+	return id, nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+type uuidUserID struct{}
+
+// Validate accepts hyphenated UUIDs in either case and returns them in
+// lower case.
+func (uuidUserID) Validate(id string) (string, error) {
+	id = strings.ToLower(id)
+	if !uuidPattern.MatchString(id) {
+		return "", errors.New("must be a UUID")
+	}
+	return id, nil
+}
+
+type ulidUserID struct{}
+
+// Validate accepts ULIDs in either case and returns them in upper case.
+func (ulidUserID) Validate(id string) (string, error) {
+	id = strings.ToUpper(id)
+	if len(id) != 26 || id[0] > '7' {
+		return "", errors.New("must be a ULID")
+	}
+	for i := 0; i < len(id); i++ {
+		// Crockford base32: digits and letters except I, L, O and U
+		c := id[i]
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z') || strings.IndexByte("ILOU", c) >= 0 {
+			return "", errors.New("must be a ULID")
+		}
+	}
+	return id, nil
+}
+
+type regexUserID struct {
+	re *regexp.Regexp
+}
+
+func (v regexUserID) Validate(id string) (string, error) {
+	if id == "" || !v.re.MatchString(id) {
+		return "", fmt.Errorf("must match %s", v.re)
+	}
+	return id, nil
+}
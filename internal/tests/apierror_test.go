@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApiError_ExploreServerValidation(t *testing.T) {
+	svc := server.NewExploreServer(newMemoryDecisions())
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		call           func() error
+		wantReason     string
+		wantViolations []apierror.FieldViolation
+	}{
+		{
+			name: "self decision",
+			call: func() error {
+				_, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "1"})
+				return err
+			},
+			wantReason:     apierror.ReasonSelfDecision,
+			wantViolations: []apierror.FieldViolation{{Field: "recipient_user_id", Description: "must differ from actor_user_id"}},
+		},
+		{
+			name: "non-numeric actor",
+			call: func() error {
+				_, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: "x", RecipientUserId: "1"})
+				return err
+			},
+			wantReason:     apierror.ReasonInvalidUserID,
+			wantViolations: []apierror.FieldViolation{{Field: "actor_user_id", Description: "must be a non-empty string of digits"}},
+		},
+		{
+			name: "empty recipient",
+			call: func() error {
+				_, err := svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{})
+				return err
+			},
+			wantReason:     apierror.ReasonMissingField,
+			wantViolations: []apierror.FieldViolation{{Field: "recipient_user_id", Description: "required"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := apierror.Parse(tt.call())
+			if !ok || d.Code != codes.InvalidArgument {
+				t.Fatalf("apierror.Parse() = %+v, %v, want an InvalidArgument status", d, ok)
+			}
+			if d.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", d.Reason, tt.wantReason)
+			}
+			if !reflect.DeepEqual(d.FieldViolations, tt.wantViolations) {
+				t.Errorf("FieldViolations = %v, want %v", d.FieldViolations, tt.wantViolations)
+			}
+			if d.RetryDelay != 0 {
+				t.Errorf("RetryDelay = %v, want none for a bad request", d.RetryDelay)
+			}
+		})
+	}
+}
+
+func TestApiError_SanitizedDetails(t *testing.T) {
+	sanitize := rpcerror.SanitizeInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/PutDecision"}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   codes.Code
+		wantReason string
+		wantRetry  time.Duration
+	}{
+		{"serialization failure", fmt.Errorf("put: %w", &pq.Error{Code: "40001"}), codes.Aborted, apierror.ReasonConcurrentUpdate, 50 * time.Millisecond},
+		{"connection lost", &pq.Error{Code: "08006"}, codes.Unavailable, apierror.ReasonUnavailable, time.Second},
+		{"internal", status.Error(codes.Internal, "boom"), codes.Internal, apierror.ReasonInternal, 0},
+		{"plain status passes through", status.Error(codes.Canceled, "request cancelled"), codes.Canceled, apierror.ReasonCancelled, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sanitize(context.Background(), nil, info, func(context.Context, any) (any, error) {
+				return nil, tt.err
+			})
+
+			d, _ := apierror.Parse(err)
+			if d.Code != tt.wantCode || d.Reason != tt.wantReason {
+				t.Errorf("Parse() = %v/%q, want %v/%q", d.Code, d.Reason, tt.wantCode, tt.wantReason)
+			}
+			if delay, ok := apierror.RetryDelay(err); delay != tt.wantRetry || ok != (tt.wantRetry > 0) {
+				t.Errorf("RetryDelay() = %v, %v, want %v", delay, ok, tt.wantRetry)
+			}
+		})
+	}
+}
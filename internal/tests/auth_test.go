@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/auth"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func signHS256(t *testing.T, secret []byte, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+
+	set := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("failed to encode JWKS: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	return path
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+
+	v, err := auth.NewVerifier(auth.Config{
+		HMACSecret: secret,
+		JWKSFile:   writeJWKS(t, "key-1", &rsaKey.PublicKey),
+		Issuer:     "explore-test",
+	})
+	if err != nil {
+		t.Fatalf("auth.NewVerifier() error = %v", err)
+	}
+
+	valid := jwt.MapClaims{"sub": "1", "iss": "explore-test", "exp": time.Now().Add(time.Hour).Unix(), "scope": "read service"}
+
+	rs256 := jwt.NewWithClaims(jwt.SigningMethodRS256, valid)
+	rs256.Header["kid"] = "key-1"
+	rsToken, err := rs256.SignedString(rsaKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    auth.Caller
+		wantErr bool
+	}{
+		{
+			name:  "HS256",
+			token: signHS256(t, secret, valid),
+			want:  auth.Caller{Subject: "1", Scopes: []string{"read", "service"}},
+		},
+		{
+			name:  "RS256 from JWKS",
+			token: rsToken,
+			want:  auth.Caller{Subject: "1", Scopes: []string{"read", "service"}},
+		},
+		{
+			name:    "wrong secret",
+			token:   signHS256(t, []byte("other"), valid),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			token:   signHS256(t, secret, jwt.MapClaims{"sub": "1", "iss": "explore-test", "exp": time.Now().Add(-time.Hour).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   signHS256(t, secret, jwt.MapClaims{"sub": "1", "iss": "someone-else", "exp": time.Now().Add(time.Hour).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "no subject",
+			token:   signHS256(t, secret, jwt.MapClaims{"iss": "explore-test", "exp": time.Now().Add(time.Hour).Unix()}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (got.Subject != tt.want.Subject || len(got.Scopes) != len(tt.want.Scopes)) {
+				t.Errorf("Verifier.Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExploreServer_Authorization(t *testing.T) {
+	secret := []byte("test-secret")
+	v, err := auth.NewVerifier(auth.Config{HMACSecret: secret})
+	if err != nil {
+		t.Fatalf("auth.NewVerifier() error = %v", err)
+	}
+
+	s := server.NewExploreServer(newMemoryDecisions())
+	interceptor := auth.UnaryServerInterceptor(v)
+	info := &grpc.UnaryServerInfo{FullMethod: pb.ExploreService_PutDecision_FullMethodName}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return s.PutDecision(ctx, req.(*pb.PutDecisionRequest))
+	}
+
+	withToken := func(claims jwt.MapClaims) context.Context {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs("authorization", "Bearer "+signHS256(t, secret, claims)))
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{
+			name: "own decision",
+			ctx:  withToken(jwt.MapClaims{"sub": "1"}),
+			want: codes.OK,
+		},
+		{
+			name: "on behalf of another user",
+			ctx:  withToken(jwt.MapClaims{"sub": "3"}),
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "service scope",
+			ctx:  withToken(jwt.MapClaims{"sub": "matcher", "scope": auth.ScopeService}),
+			want: codes.OK,
+		},
+		{
+			name: "no token",
+			ctx:  context.Background(),
+			want: codes.Unauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}
+			_, err := interceptor(tt.ctx, req, info, handler)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("PutDecision() code = %v, want %v (err = %v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyStore is a repository.Guarded whose CountLikedYou, Check and
+// decided-set queries fail with err and take delay, as set by the test.
+type flakyStore struct {
+	*memoryDecisions
+
+	mu    sync.Mutex
+	err   error
+	delay time.Duration
+}
+
+func (f *flakyStore) set(err error, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err, f.delay = err, delay
+}
+
+func (f *flakyStore) outcome() error {
+	f.mu.Lock()
+	err, delay := f.err, f.delay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+	return err
+}
+
+func (f *flakyStore) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	if err := f.outcome(); err != nil {
+		return 0, err
+	}
+	return f.memoryDecisions.CountLikedYou(ctx, recipientID)
+}
+
+func (f *flakyStore) DecidedRecipients(context.Context, string) ([]string, error) {
+	return nil, f.outcome()
+}
+
+func (f *flakyStore) FilterDecided(context.Context, string, []string) (map[string]bool, error) {
+	return nil, f.outcome()
+}
+
+func (f *flakyStore) Check(context.Context) error {
+	return f.outcome()
+}
+
+// transitions records a breaker's state changes.
+type transitions struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (tr *transitions) record(from, to repository.BreakerState) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.got = append(tr.got, fmt.Sprintf("%v->%v", from, to))
+}
+
+func (tr *transitions) String() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return fmt.Sprint(tr.got)
+}
+
+func newTestBreaker(store *flakyStore, tr *transitions) *repository.CircuitBreaker {
+	return repository.NewCircuitBreaker(store, repository.BreakerConfig{
+		FailureRate:       0.5,
+		MinRequests:       4,
+		Window:            time.Minute,
+		SlowCallThreshold: 20 * time.Millisecond,
+		OpenTimeout:       50 * time.Millisecond,
+		HalfOpenProbes:    2,
+		OnStateChange:     tr.record,
+	})
+}
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	store := &flakyStore{memoryDecisions: newMemoryDecisions()}
+	tr := &transitions{}
+	b := newTestBreaker(store, tr)
+	ctx := context.Background()
+
+	store.set(driver.ErrBadConn, 0)
+	for range 4 {
+		if _, err := b.CountLikedYou(ctx, "1"); !errors.Is(err, driver.ErrBadConn) {
+			t.Fatalf("CountLikedYou() error = %v, want the store's", err)
+		}
+	}
+	if got := b.State(); got != repository.BreakerOpen {
+		t.Fatalf("State() = %v after 4 failures, want open", got)
+	}
+
+	// Open: calls fail fast without reaching the store.
+	store.set(nil, 0)
+	before := store.called("CountLikedYou")
+	_, err := b.CountLikedYou(ctx, "1")
+	if !errors.Is(err, repository.ErrCircuitOpen) {
+		t.Fatalf("CountLikedYou() error = %v, want ErrCircuitOpen", err)
+	}
+	if store.called("CountLikedYou") != before {
+		t.Error("open breaker let a call through")
+	}
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("code = %v, want Unavailable", code)
+	}
+	if delay, ok := apierror.RetryDelay(err); !ok || delay > 50*time.Millisecond {
+		t.Errorf("RetryDelay() = %v, %v, want at most the 50ms open timeout", delay, ok)
+	}
+
+	// Half-open after the timeout: a failed trial reopens at once.
+	time.Sleep(60 * time.Millisecond)
+	store.set(driver.ErrBadConn, 0)
+	if _, err := b.CountLikedYou(ctx, "1"); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("trial call error = %v, want the store's", err)
+	}
+	if got := b.State(); got != repository.BreakerOpen {
+		t.Fatalf("State() = %v after a failed trial, want open", got)
+	}
+
+	// Health probes are trial calls too, so they alone can close it.
+	time.Sleep(60 * time.Millisecond)
+	store.set(nil, 0)
+	for range 2 {
+		if err := b.Check(ctx); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	if got := b.State(); got != repository.BreakerClosed {
+		t.Fatalf("State() = %v after 2 successful trials, want closed", got)
+	}
+
+	want := "[closed->open open->half-open half-open->open open->half-open half-open->closed]"
+	if got := tr.String(); got != want {
+		t.Errorf("transitions = %s, want %s", got, want)
+	}
+}
+
+func TestCircuitBreaker_Failures(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		delay    time.Duration
+		wantOpen bool
+	}{
+		{name: "connection errors", err: &pq.Error{Code: "08006"}, wantOpen: true},
+		{name: "timeouts", err: context.DeadlineExceeded, wantOpen: true},
+		{name: "slow successes", delay: 30 * time.Millisecond, wantOpen: true},
+		{name: "constraint violations", err: &pq.Error{Code: "23505"}},
+		{name: "client errors", err: status.Error(codes.InvalidArgument, "bad")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &flakyStore{memoryDecisions: newMemoryDecisions()}
+			b := newTestBreaker(store, &transitions{})
+
+			store.set(tt.err, tt.delay)
+			for range 4 {
+				b.FilterDecided(context.Background(), "1", []string{"2"})
+			}
+
+			if got := b.State() == repository.BreakerOpen; got != tt.wantOpen {
+				t.Errorf("open = %v, want %v", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker_ShortDeadlines(t *testing.T) {
+	store := &flakyStore{memoryDecisions: newMemoryDecisions()}
+	b := newTestBreaker(store, &transitions{})
+	svc := server.NewExploreServer(b, server.WithTimeoutPolicy(server.TimeoutPolicy{Default: time.Second}))
+
+	// Callers whose own deadline runs out while the database is slow to
+	// answer say nothing about its health.
+	store.set(context.DeadlineExceeded, 15*time.Millisecond)
+	for range 8 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "1"})
+		b.FilterDecided(ctx, "1", []string{"2"})
+		cancel()
+	}
+	if got := b.State(); got != repository.BreakerClosed {
+		t.Fatalf("State() = %v after callers' deadlines ran out, want closed", got)
+	}
+
+	// The server's own timeout firing does.
+	svc = server.NewExploreServer(b, server.WithTimeoutPolicy(server.TimeoutPolicy{Default: 5 * time.Millisecond}))
+	for range 4 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "1"})
+		cancel()
+	}
+	if got := b.State(); got != repository.BreakerOpen {
+		t.Errorf("State() = %v after server timeouts, want open", got)
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	store := &flakyStore{memoryDecisions: newMemoryDecisions()}
+	b := newTestBreaker(store, &transitions{})
+	ctx := context.Background()
+
+	// 2 failures in 5 calls stays under the 50% rate.
+	for i := range 5 {
+		if i >= 3 {
+			store.set(driver.ErrBadConn, 0)
+		} else {
+			store.set(nil, 0)
+		}
+		b.CountLikedYou(ctx, "1")
+	}
+	if got := b.State(); got != repository.BreakerClosed {
+		t.Fatalf("State() = %v at a 40%% failure rate, want closed", got)
+	}
+
+	// A cancelled call says nothing about the database.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	store.set(context.Canceled, 0)
+	b.CountLikedYou(cancelled, "1")
+	if got := b.State(); got != repository.BreakerClosed {
+		t.Fatalf("State() = %v after a cancelled call, want closed", got)
+	}
+
+	store.set(driver.ErrBadConn, 0)
+	b.CountLikedYou(ctx, "1")
+	if got := b.State(); got != repository.BreakerOpen {
+		t.Errorf("State() = %v at a 50%% failure rate, want open", got)
+	}
+}
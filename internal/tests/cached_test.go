@@ -0,0 +1,362 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+)
+
+// memoryDecisions is an in-memory repository.Decisions used to test
+// decorators without Postgres. countGate, when set, blocks CountLikedYou
+// until it receives a value; countStarted is signalled on entry.
+type memoryDecisions struct {
+	mu           sync.Mutex
+	rows         map[[2]string]models.Decision
+	calls        map[string]int
+	countGate    chan struct{}
+	countStarted chan struct{}
+}
+
+func newMemoryDecisions() *memoryDecisions {
+	return &memoryDecisions{rows: map[[2]string]models.Decision{}, calls: map[string]int{}}
+}
+
+func (m *memoryDecisions) called(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.calls[method]
+}
+
+func (m *memoryDecisions) PutDecision(_ context.Context, d *models.Decision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls["PutDecision"]++
+	now := time.Now()
+	key := [2]string{d.ActorUserId, d.RecipientUserId}
+	row, ok := m.rows[key]
+	if !ok {
+		row = models.Decision{ActorUserId: d.ActorUserId, RecipientUserId: d.RecipientUserId, CreatedAt: now}
+	}
+	d.PreviouslyLiked = ok && row.LikedRecipient
+	row.LikedRecipient = d.LikedRecipient
+	row.UpdatedAt = now
+	m.rows[key] = row
+	d.CreatedAt, d.UpdatedAt = row.CreatedAt, row.UpdatedAt
+	return nil
+}
+
+func (m *memoryDecisions) ListLikedYou(_ context.Context, recipientID string, _ string) ([]models.Decision, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls["ListLikedYou"]++
+	return m.likers(recipientID, false), "", nil
+}
+
+func (m *memoryDecisions) ListNewLikedYou(_ context.Context, recipientID string, _ string) ([]models.Decision, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls["ListNewLikedYou"]++
+	return m.likers(recipientID, true), "", nil
+}
+
+func (m *memoryDecisions) IsMutual(_ context.Context, actorID, recipientID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls["IsMutual"]++
+	return m.rows[[2]string{actorID, recipientID}].LikedRecipient &&
+		m.rows[[2]string{recipientID, actorID}].LikedRecipient, nil
+}
+
+func (m *memoryDecisions) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	if m.countGate != nil {
+		m.countStarted <- struct{}{}
+		<-m.countGate
+	}
+	// like a query, fail once the caller has gone
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls["CountLikedYou"]++
+	return int64(len(m.likers(recipientID, false))), nil
+}
+
+func (m *memoryDecisions) likers(recipientID string, onlyNew bool) []models.Decision {
+	var out []models.Decision
+	for key, row := range m.rows {
+		if key[1] != recipientID || !row.LikedRecipient {
+			continue
+		}
+		if onlyNew && m.rows[[2]string{recipientID, key[0]}].LikedRecipient {
+			continue
+		}
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// memoryRemote is an in-memory cache.Remote.
+type memoryRemote struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func (m *memoryRemote) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.items[key]
+	return v, ok, nil
+}
+
+func (m *memoryRemote) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[key] = value
+	return nil
+}
+
+func (m *memoryRemote) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.items, key)
+	}
+	return nil
+}
+
+func TestCachedDecisionRepository_NoStaleReadAfterPut(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		read  func(r repository.Decisions) (int, error)
+		put   models.Decision
+		dummy []models.Decision
+		want  int
+	}{
+		{
+			name: "count after like",
+			read: func(r repository.Decisions) (int, error) {
+				n, err := r.CountLikedYou(ctx, "2")
+				return int(n), err
+			},
+			put:  models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true},
+			want: 1,
+		},
+		{
+			name: "count after overwrite to pass",
+			read: func(r repository.Decisions) (int, error) {
+				n, err := r.CountLikedYou(ctx, "2")
+				return int(n), err
+			},
+			dummy: []models.Decision{{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}},
+			put:   models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: false},
+			want:  0,
+		},
+		{
+			name: "first page of liked you",
+			read: func(r repository.Decisions) (int, error) {
+				got, _, err := r.ListLikedYou(ctx, "2", "")
+				return len(got), err
+			},
+			dummy: []models.Decision{{ActorUserId: "3", RecipientUserId: "2", LikedRecipient: true}},
+			put:   models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true},
+			want:  2,
+		},
+		{
+			name: "actor's new likes after liking back",
+			read: func(r repository.Decisions) (int, error) {
+				got, _, err := r.ListNewLikedYou(ctx, "1", "")
+				return len(got), err
+			},
+			dummy: []models.Decision{{ActorUserId: "2", RecipientUserId: "1", LikedRecipient: true}},
+			put:   models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true},
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := newMemoryDecisions()
+			for _, v := range tt.dummy {
+				mem.PutDecision(ctx, &v)
+			}
+
+			r := repository.NewCachedDecisionRepository(mem, repository.CacheConfig{
+				CountTTL: time.Hour,
+				ListTTL:  time.Hour,
+				Remote:   &memoryRemote{items: map[string][]byte{}},
+			})
+
+			// warm the cache, then write and read back
+			if _, err := tt.read(r); err != nil {
+				t.Fatalf("CachedDecisionRepository read error = %v", err)
+			}
+			if err := r.PutDecision(ctx, &tt.put); err != nil {
+				t.Fatalf("CachedDecisionRepository.PutDecision() error = %v", err)
+			}
+
+			got, err := tt.read(r)
+			if err != nil {
+				t.Fatalf("CachedDecisionRepository read error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CachedDecisionRepository read = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedDecisionRepository_InFlightLoadIsNotStored(t *testing.T) {
+	ctx := context.Background()
+
+	mem := newMemoryDecisions()
+	mem.countGate = make(chan struct{})
+	mem.countStarted = make(chan struct{}, 2)
+	r := repository.NewCachedDecisionRepository(mem, repository.CacheConfig{CountTTL: time.Hour})
+
+	// a read that starts before the write and finishes after it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.CountLikedYou(ctx, "2")
+	}()
+	<-mem.countStarted
+
+	if err := r.PutDecision(ctx, &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+		t.Fatalf("CachedDecisionRepository.PutDecision() error = %v", err)
+	}
+	mem.countGate <- struct{}{}
+	<-done
+
+	close(mem.countGate)
+	got, err := r.CountLikedYou(ctx, "2")
+	if err != nil {
+		t.Fatalf("CachedDecisionRepository.CountLikedYou() error = %v", err)
+	}
+	if got != 1 {
+		t.Errorf("CachedDecisionRepository.CountLikedYou() = %d, want 1", got)
+	}
+}
+
+func TestCachedDecisionRepository_SharedLoadOutlivesCaller(t *testing.T) {
+	mem := newMemoryDecisions()
+	mem.countGate = make(chan struct{})
+	mem.countStarted = make(chan struct{}, 1)
+	r := repository.NewCachedDecisionRepository(mem, repository.CacheConfig{CountTTL: time.Hour})
+
+	if err := r.PutDecision(context.Background(), &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+		t.Fatalf("CachedDecisionRepository.PutDecision() error = %v", err)
+	}
+
+	// the first caller starts the load, the second joins it
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.CountLikedYou(ctx, "2")
+		first <- err
+	}()
+	<-mem.countStarted
+
+	type result struct {
+		count int64
+		err   error
+	}
+	second := make(chan result, 1)
+	go func() {
+		count, err := r.CountLikedYou(context.Background(), "2")
+		second <- result{count, err}
+	}()
+
+	// the first caller gives up without waiting for the load
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("CachedDecisionRepository.CountLikedYou() after cancel error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("CachedDecisionRepository.CountLikedYou() kept waiting after its context was cancelled")
+	}
+
+	close(mem.countGate)
+	got := <-second
+	if got.err != nil || got.count != 1 {
+		t.Errorf("CachedDecisionRepository.CountLikedYou() = %d, %v, want 1 from the shared load", got.count, got.err)
+	}
+	if n := mem.called("CountLikedYou"); n != 1 {
+		t.Errorf("CountLikedYou reached the repository %d times, want 1", n)
+	}
+}
+
+// primaryReadsRecorder notes whether CountLikedYou was asked to read the
+// primary.
+type primaryReadsRecorder struct {
+	*memoryDecisions
+	primary []bool
+}
+
+func (p *primaryReadsRecorder) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	p.primary = append(p.primary, repository.PrimaryReadsRequested(ctx))
+	return p.memoryDecisions.CountLikedYou(ctx, recipientID)
+}
+
+func TestCachedDecisionRepository_PrimaryReads(t *testing.T) {
+	rec := &primaryReadsRecorder{memoryDecisions: newMemoryDecisions()}
+	r := repository.NewCachedDecisionRepository(rec, repository.CacheConfig{CountTTL: time.Hour})
+
+	// a fill reads wherever the caller would; read-your-writes bypasses the cache
+	if _, err := r.CountLikedYou(context.Background(), "2"); err != nil {
+		t.Fatalf("CachedDecisionRepository.CountLikedYou() error = %v", err)
+	}
+	if _, err := r.CountLikedYou(repository.WithPrimaryReads(context.Background()), "2"); err != nil {
+		t.Fatalf("CachedDecisionRepository.CountLikedYou() error = %v", err)
+	}
+
+	if want := []bool{false, true}; !slices.Equal(rec.primary, want) {
+		t.Errorf("CountLikedYou primary reads = %v, want %v", rec.primary, want)
+	}
+}
+
+func TestCachedDecisionRepository_Hits(t *testing.T) {
+	ctx := context.Background()
+
+	mem := newMemoryDecisions()
+	remote := &memoryRemote{items: map[string][]byte{}}
+	cfg := repository.CacheConfig{CountTTL: time.Hour, Remote: remote}
+
+	first := repository.NewCachedDecisionRepository(mem, cfg)
+	for range 3 {
+		if _, err := first.CountLikedYou(ctx, "2"); err != nil {
+			t.Fatalf("CachedDecisionRepository.CountLikedYou() error = %v", err)
+		}
+	}
+
+	// a second instance is served by the shared remote cache
+	second := repository.NewCachedDecisionRepository(mem, cfg)
+	if _, err := second.CountLikedYou(ctx, "2"); err != nil {
+		t.Fatalf("CachedDecisionRepository.CountLikedYou() error = %v", err)
+	}
+
+	if n := mem.called("CountLikedYou"); n != 1 {
+		t.Errorf("underlying CountLikedYou called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+
+	_ "github.com/lib/pq"
+)
+
+func openUnreachableDB(t *testing.T) *sql.DB {
+	// sql.Open does not connect, so these handles only fail once used
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 user=postgres dbname=explore sslmode=disable connect_timeout=1")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCluster_Reader(t *testing.T) {
+	primary := openUnreachableDB(t)
+	replica := openUnreachableDB(t)
+
+	tests := []struct {
+		name    string
+		cluster *repository.Cluster
+		ctx     context.Context
+		want    *sql.DB
+	}{
+		{
+			name:    "no replicas",
+			cluster: repository.NewCluster(primary),
+			ctx:     context.Background(),
+			want:    primary,
+		},
+		{
+			name:    "healthy replica",
+			cluster: repository.NewCluster(primary, replica),
+			ctx:     context.Background(),
+			want:    replica,
+		},
+		{
+			name:    "read your writes",
+			cluster: repository.NewCluster(primary, replica),
+			ctx:     repository.WithPrimaryReads(context.Background()),
+			want:    primary,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cluster.Reader(tt.ctx); got != tt.want {
+				t.Errorf("Cluster.Reader() = %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCluster_FailoverToPrimary(t *testing.T) {
+	primary := openUnreachableDB(t)
+	replica := openUnreachableDB(t)
+	c := repository.NewCluster(primary, replica)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 50*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Reader(context.Background()) != primary {
+		if time.Now().After(deadline) {
+			t.Fatal("Cluster.Reader() kept returning the unreachable replica")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDecisionRepository_ReplicaFailover(t *testing.T) {
+	dbs, contClose := setupTestShards(t, 2)
+	defer contClose()
+
+	primary, broken := dbs[0], dbs[1]
+	// a replica that is up but fails the query itself
+	if _, err := broken.Exec(`DROP TABLE decisions`); err != nil {
+		t.Fatalf("failed to drop replica table: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("unreachable replica", func(t *testing.T) {
+		replica := openUnreachableDB(t)
+		c := repository.NewCluster(primary, replica)
+		r, err := repository.NewReplicatedDecisionRepository(c)
+		if err != nil {
+			t.Fatalf("failed to init repo error = %v", err)
+		}
+
+		if _, err := r.CountLikedYou(ctx, "2"); err != nil {
+			t.Fatalf("DecisionRepository.CountLikedYou() error = %v, want it served by the primary", err)
+		}
+		if got := c.Reader(ctx); got != primary {
+			t.Errorf("Cluster.Reader() = %p, want the primary once the replica is unreachable", got)
+		}
+	})
+
+	t.Run("failing query", func(t *testing.T) {
+		c := repository.NewCluster(primary, broken)
+		r, err := repository.NewReplicatedDecisionRepository(c)
+		if err != nil {
+			t.Fatalf("failed to init repo error = %v", err)
+		}
+
+		if _, err := r.CountLikedYou(ctx, "2"); err == nil {
+			t.Fatal("DecisionRepository.CountLikedYou() error = nil, want the replica's error")
+		}
+		if got := c.Reader(ctx); got != broken {
+			t.Errorf("Cluster.Reader() = %p, want the replica kept in rotation", got)
+		}
+	})
+}
@@ -36,6 +36,70 @@ func setupTestDB(t *testing.T) (*sql.DB, func()) {
 
 // GetTestInstance starts a PostgreSQL container for testing and returns a connected pg.DB client along with a cleanup function.
 func GetTestInstance(ctx context.Context) (*sql.DB, func()) {
+	dsn, terminate := startTestContainer(ctx)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		log.Fatalf("failed to create test db instance: %v", err)
+	}
+
+	// Return the client and a cleanup function
+	return db, func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing PostgreSQL client: %v", err)
+		}
+		terminate()
+	}
+}
+
+// setupTestShards creates one schema per shard in a single container and
+// returns a client pinned to each schema through its search_path.
+func setupTestShards(t *testing.T, n int) ([]*sql.DB, func()) {
+	ctx := context.Background()
+	dsn, terminate := startTestContainer(ctx)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to create test db instance: %v", err)
+	}
+	defer admin.Close()
+
+	var shards []*sql.DB
+	for i := range n {
+		schema := fmt.Sprintf("shard_%d", i)
+		ddl := fmt.Sprintf(`
+		CREATE SCHEMA %[1]s;
+		CREATE TABLE %[1]s.decisions (
+			actor_user_id TEXT NOT NULL,
+			recipient_user_id TEXT NOT NULL,
+			liked_recipient BOOLEAN NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (actor_user_id, recipient_user_id)
+		);
+		`, schema)
+		if _, err := admin.Exec(ddl); err != nil {
+			t.Fatalf("failed to create shard schema: %v", err)
+		}
+
+		db, err := sql.Open("postgres", dsn+" search_path="+schema)
+		if err != nil {
+			t.Fatalf("failed to create shard db instance: %v", err)
+		}
+		shards = append(shards, db)
+	}
+
+	return shards, func() {
+		for _, db := range shards {
+			db.Close()
+		}
+		terminate()
+	}
+}
+
+// startTestContainer starts a PostgreSQL container and returns its DSN along
+// with a function terminating it.
+func startTestContainer(ctx context.Context) (string, func()) {
 	const psqlVersion = "17"
 	const port = "5432"
 
@@ -67,16 +131,7 @@ func GetTestInstance(ctx context.Context) (*sql.DB, func()) {
 
 	dsn := fmt.Sprintf("host=localhost port=%v user=postgres password=postgres dbname=test_db sslmode=disable", after)
 
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		log.Fatalf("failed to create test db instance: %v", err)
-	}
-
-	// Return the client and a cleanup function
-	return db, func() {
-		if err := db.Close(); err != nil {
-			log.Printf("Error closing PostgreSQL client: %v", err)
-		}
+	return dsn, func() {
 		if err := psqlClient.Terminate(ctx); err != nil {
 			log.Printf("Error terminating PostgreSQL container: %v", err)
 		}
@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadConfig(t *testing.T, args ...string) (config.Loaded, error) {
+	t.Helper()
+	return config.Load(flag.NewFlagSet("test", flag.ContinueOnError), args)
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	loaded, err := loadConfig(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	cfg := loaded.Config
+	if cfg.DB.MaxOpenConns != 25 || cfg.Server.PageSize != 30 || cfg.Server.RequestTimeout != 3*time.Second {
+		t.Errorf("defaults = %+v / %+v, want 25 conns, page size 30, 3s timeout", cfg.DB, cfg.Server)
+	}
+	if loaded.File != "" || loaded.PrintConfig {
+		t.Errorf("Loaded = %+v, want no file and no print", loaded)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		body string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			body: `
+db:
+  host: file-host
+  max_open_conns: 50
+  shard_dsns: [host=a, host=b]
+server:
+  page_size: 10
+  request_timeout: 1s
+`,
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			body: `
+[db]
+host = "file-host"
+max_open_conns = 50
+shard_dsns = ["host=a", "host=b"]
+
+[server]
+page_size = 10
+request_timeout = "1s"
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.body)
+			t.Setenv("DB_HOST", "env-host")
+			t.Setenv("PAGE_SIZE", "20")
+
+			loaded, err := loadConfig(t, "-config", path, "-server.page_size", "40")
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			cfg := loaded.Config
+			if cfg.DB.MaxOpenConns != 50 || cfg.Server.RequestTimeout != time.Second {
+				t.Errorf("file values not applied: %+v", cfg)
+			}
+			if got := strings.Join(cfg.DB.ShardDSNs, ";"); got != "host=a;host=b" {
+				t.Errorf("ShardDSNs = %q, want host=a;host=b", got)
+			}
+			if cfg.DB.Host != "env-host" {
+				t.Errorf("DB.Host = %q, want env to override file", cfg.DB.Host)
+			}
+			if cfg.Server.PageSize != 40 {
+				t.Errorf("Server.PageSize = %d, want flag to override env and file", cfg.Server.PageSize)
+			}
+			if loaded.File != path {
+				t.Errorf("File = %q, want %q", loaded.File, path)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_FileFromEnv(t *testing.T) {
+	t.Setenv(config.FileEnv, writeConfigFile(t, "config.yml", "grpc:\n  port: 6000\n"))
+
+	loaded, err := loadConfig(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Config.GRPC.Port != 6000 {
+		t.Errorf("GRPC.Port = %d, want 6000", loaded.Config.GRPC.Port)
+	}
+}
+
+func TestLoadConfig_DeprecatedPasswordEnv(t *testing.T) {
+	t.Setenv("DB_PASS", "old")
+
+	loaded, err := loadConfig(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Config.DB.Password != "old" {
+		t.Errorf("DB.Password = %q, want DB_PASS to be honoured", loaded.Config.DB.Password)
+	}
+	if len(loaded.Deprecated) != 1 || !strings.Contains(loaded.Deprecated[0], "DB_PASSWORD") {
+		t.Errorf("Deprecated = %q, want a DB_PASSWORD hint", loaded.Deprecated)
+	}
+
+	t.Setenv("DB_PASSWORD", "new")
+	loaded, err = loadConfig(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Config.DB.Password != "new" || len(loaded.Deprecated) != 0 {
+		t.Errorf("DB.Password = %q, Deprecated = %q, want DB_PASSWORD to win silently",
+			loaded.Config.DB.Password, loaded.Deprecated)
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    func(t *testing.T) []string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name: "unknown file key",
+			args: func(t *testing.T) []string {
+				return []string{"-config", writeConfigFile(t, "c.yaml", "db:\n  hots: x\n")}
+			},
+			wantErr: []string{`unknown setting "db.hots"`},
+		},
+		{
+			name:    "unsupported file type",
+			args:    func(t *testing.T) []string { return []string{"-config", writeConfigFile(t, "c.json", "{}")} },
+			wantErr: []string{"unsupported configuration file type"},
+		},
+		{
+			name:    "bad env value",
+			env:     map[string]string{"DB_MAX_OPEN_CONNS": "many"},
+			wantErr: []string{"DB_MAX_OPEN_CONNS"},
+		},
+		{
+			name:    "bad flag value",
+			args:    func(*testing.T) []string { return []string{"-server.request_timeout", "3"} },
+			wantErr: []string{"-server.request_timeout"},
+		},
+		{
+			name:    "unknown method timeout",
+			env:     map[string]string{"METHOD_TIMEOUTS": "PutDecision=1s,DeleteDecision=1s"},
+			wantErr: []string{`server.method_timeouts: unknown method "DeleteDecision"`},
+		},
+		{
+			name:    "retry delays inverted",
+			env:     map[string]string{"DB_RETRY_BASE_DELAY": "1s", "DB_RETRY_MAX_DELAY": "100ms"},
+			wantErr: []string{"db.retry_max_delay"},
+		},
+		{
+			name:    "bad limiter priority",
+			env:     map[string]string{"LIMITER_PRIORITIES": "PutDecision=urgent"},
+			wantErr: []string{`limiter.priorities: PutDecision: unknown priority "urgent"`},
+		},
+		{
+			name:    "bad cors origin",
+			env:     map[string]string{"GRPC_CORS_ALLOWED_ORIGINS": "https://app.example.com,app.example.com"},
+			wantErr: []string{`grpc.cors_allowed_origins: "app.example.com" is not * or an origin`},
+		},
+		{
+			name: "every validation error reported",
+			env: map[string]string{
+				"PAGE_SIZE":         "0",
+				"DB_MAX_IDLE_CONNS": "30",
+				"LOG_LEVEL":         "loud",
+				"TLS_CLIENT_AUTH":   "maybe",
+				"USER_ID_FORMAT":    "email",
+			},
+			wantErr: []string{"server.page_size", "db.max_idle_conns", "logging.level", "tls.client_auth", "server.user_id_format"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var args []string
+			if tt.args != nil {
+				args = tt.args(t)
+			}
+
+			_, err := loadConfig(t, args...)
+			if err == nil {
+				t.Fatal("Load() succeeded, want error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %v, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintConfig_RedactsSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("DB_REPLICA_DSNS", "host=replica password=hunter3")
+	t.Setenv("AUTH_HS256_SECRET", "shh")
+
+	loaded, err := loadConfig(t, "-print-config")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !loaded.PrintConfig {
+		t.Error("PrintConfig = false, want true")
+	}
+
+	var buf bytes.Buffer
+	if err := config.Print(&buf, loaded.Config); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "hunter3", "shh"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, out)
+		}
+	}
+
+	// The output is itself a loadable file once secrets are filled in.
+	var doc map[string]map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("printed configuration is not YAML: %v", err)
+	}
+	if doc["db"]["password"] != "REDACTED" || doc["auth"]["issuer"] != "" {
+		t.Errorf("db.password = %v, auth.issuer = %v", doc["db"]["password"], doc["auth"]["issuer"])
+	}
+
+	path := writeConfigFile(t, "printed.yaml", out)
+	reloaded, err := loadConfig(t, "-config", path)
+	if err != nil {
+		t.Fatalf("reloading printed configuration: %v", err)
+	}
+	if reloaded.Config.Server.PageSize != loaded.Config.Server.PageSize ||
+		reloaded.Config.DB.ConnMaxIdleTime != loaded.Config.DB.ConnMaxIdleTime {
+		t.Errorf("reloaded %+v, want %+v", reloaded.Config, loaded.Config)
+	}
+}
@@ -0,0 +1,295 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deadlineDecisions records the time left on the context of each call.
+type deadlineDecisions struct {
+	*memoryDecisions
+	left map[string]time.Duration
+}
+
+func (d *deadlineDecisions) record(ctx context.Context, method string) {
+	if deadline, ok := ctx.Deadline(); ok {
+		d.left[method] = time.Until(deadline)
+	}
+}
+
+func (d *deadlineDecisions) PutDecision(ctx context.Context, decision *models.Decision) error {
+	d.record(ctx, "PutDecision")
+	return d.memoryDecisions.PutDecision(ctx, decision)
+}
+
+func (d *deadlineDecisions) CountLikedYou(ctx context.Context, recipientID string) (int64, error) {
+	d.record(ctx, "CountLikedYou")
+	return d.memoryDecisions.CountLikedYou(ctx, recipientID)
+}
+
+func TestExploreServer_TimeoutPolicy(t *testing.T) {
+	repo := &deadlineDecisions{memoryDecisions: newMemoryDecisions(), left: map[string]time.Duration{}}
+	svc := server.NewExploreServer(repo, server.WithTimeoutPolicy(server.TimeoutPolicy{
+		Default: 2 * time.Second,
+		Methods: map[string]time.Duration{"PutDecision": 10 * time.Second},
+	}))
+
+	ctx := context.Background()
+	if _, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+		t.Fatalf("PutDecision() error = %v", err)
+	}
+	if _, err := svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "2"}); err != nil {
+		t.Fatalf("CountLikedYou() error = %v", err)
+	}
+
+	if left := repo.left["PutDecision"]; left <= 2*time.Second || left > 10*time.Second {
+		t.Errorf("PutDecision had %v left, want its 10s override", left)
+	}
+	if left := repo.left["CountLikedYou"]; left <= 0 || left > 2*time.Second {
+		t.Errorf("CountLikedYou had %v left, want the 2s default", left)
+	}
+
+	// A shorter client deadline wins over the policy.
+	clientCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	if _, err := svc.CountLikedYou(clientCtx, &pb.CountLikedYouRequest{RecipientUserId: "2"}); err != nil {
+		t.Fatalf("CountLikedYou() error = %v", err)
+	}
+	if left := repo.left["CountLikedYou"]; left > 500*time.Millisecond {
+		t.Errorf("CountLikedYou had %v left, want at most the client's 500ms", left)
+	}
+}
+
+func TestExploreServer_MinBudget(t *testing.T) {
+	repo := newMemoryDecisions()
+	svc := server.NewExploreServer(repo, server.WithTimeoutPolicy(server.TimeoutPolicy{
+		Default:   time.Second,
+		MinBudget: 200 * time.Millisecond,
+	}))
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		wantCode codes.Code
+	}{
+		{name: "enough budget", timeout: time.Second, wantCode: codes.OK},
+		{name: "no client deadline", wantCode: codes.OK},
+		{name: "too little budget", timeout: 50 * time.Millisecond, wantCode: codes.DeadlineExceeded},
+		{name: "already expired", timeout: -time.Second, wantCode: codes.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			before := repo.called("PutDecision")
+			_, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("PutDecision() code = %v, want %v (err %v)", code, tt.wantCode, err)
+			}
+			if tt.wantCode == codes.OK {
+				return
+			}
+
+			if got := apierror.Reason(err); got != apierror.ReasonDeadlineExceeded {
+				t.Errorf("reason = %q, want %q", got, apierror.ReasonDeadlineExceeded)
+			}
+			if repo.called("PutDecision") != before {
+				t.Error("repository was called despite the exhausted budget")
+			}
+		})
+	}
+}
+
+func TestExploreServer_ValidatesBeforeBudget(t *testing.T) {
+	svc := server.NewExploreServer(newMemoryDecisions(), server.WithTimeoutPolicy(server.TimeoutPolicy{
+		Default:   time.Second,
+		MinBudget: 200 * time.Millisecond,
+	}))
+
+	// an invalid request is reported as such whatever its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := map[string]func() error{
+		"PutDecision": func() error {
+			_, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: "x", RecipientUserId: "2"})
+			return err
+		},
+		"CountLikedYou": func() error {
+			_, err := svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "x"})
+			return err
+		},
+		"ListLikedYou": func() error {
+			_, err := svc.ListLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: "x"})
+			return err
+		},
+		"ListNewLikedYou": func() error {
+			_, err := svc.ListNewLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: "x"})
+			return err
+		},
+	}
+	for method, call := range calls {
+		if code := status.Code(call()); code != codes.InvalidArgument {
+			t.Errorf("%s() code = %v, want InvalidArgument", method, code)
+		}
+	}
+}
+
+func TestDecisionRepository_StatementTimeout(t *testing.T) {
+	db, contClose := setupTestDB(t)
+	defer db.Close()
+	defer contClose()
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	if err := r.PutDecision(context.Background(), &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+		t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+	}
+
+	// Hold the row so the next upsert blocks until something gives up.
+	blocker, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocker.Rollback()
+	if _, err := blocker.Exec(`SELECT 1 FROM decisions WHERE actor_user_id = '1' FOR UPDATE`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = r.PutDecision(ctx, &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: false})
+	if err == nil {
+		t.Fatal("PutDecision() succeeded while the row was locked")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("PutDecision() took %v, want it abandoned near the 300ms deadline", elapsed)
+	}
+	if code, _ := rpcerror.Classify(ctx, err); code != codes.DeadlineExceeded {
+		t.Errorf("Classify() = %v, want DeadlineExceeded (err %v)", code, err)
+	}
+
+	// The server-side statement has been cancelled, so the blocked upsert
+	// must not apply once the lock is released.
+	if err := blocker.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var liked bool
+	if err := db.QueryRow(`SELECT liked_recipient FROM decisions WHERE actor_user_id = '1'`).Scan(&liked); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	if !liked {
+		t.Error("abandoned upsert was applied")
+	}
+}
+
+func TestNewConnector_StatementTimeout(t *testing.T) {
+	dsn, terminate := startTestContainer(context.Background())
+	defer terminate()
+
+	connector, err := repository.NewConnector(dsn, 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("repository.NewConnector() error = %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	var timeout string
+	if err := db.QueryRow(`SHOW statement_timeout`).Scan(&timeout); err != nil {
+		t.Fatalf("SHOW statement_timeout failed: %v", err)
+	}
+	if timeout != "250ms" {
+		t.Errorf("statement_timeout = %s, want 250ms", timeout)
+	}
+
+	// without a deadline the connection's timeout still stops the query
+	if _, err := db.Exec(`SELECT pg_sleep(2)`); err == nil {
+		t.Error("pg_sleep(2) succeeded, want it cancelled by statement_timeout")
+	} else if code, _ := rpcerror.Classify(context.Background(), err); code != codes.DeadlineExceeded {
+		t.Errorf("Classify() = %v, want DeadlineExceeded (err %v)", code, err)
+	}
+}
+
+func TestDecisionRepository_StatementTimeoutRaised(t *testing.T) {
+	dsn, terminate := startTestContainer(context.Background())
+	defer terminate()
+
+	// Connections run with the default request timeout, as the server's do.
+	connector, err := repository.NewConnector(dsn, server.DefaultRequestTimeout)
+	if err != nil {
+		t.Fatalf("repository.NewConnector() error = %v", err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE decisions (
+		actor_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		liked_recipient BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW(),
+		PRIMARY KEY (actor_user_id, recipient_user_id)
+	)`); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	r, err := repository.NewDecisionRepository(db, repository.WithStatementTimeout(server.DefaultRequestTimeout))
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+	defer r.Close()
+
+	if err := r.PutDecision(context.Background(), &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+		t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+	}
+
+	// Hold the row for longer than the connection's timeout.
+	blocker, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocker.Rollback()
+	if _, err := blocker.Exec(`SELECT 1 FROM decisions WHERE actor_user_id = '1' FOR UPDATE`); err != nil {
+		t.Fatal(err)
+	}
+	hold := server.DefaultRequestTimeout + 500*time.Millisecond
+	go func() {
+		time.Sleep(hold)
+		blocker.Commit()
+	}()
+
+	// A method timeout above the default lets the upsert wait it out.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*server.DefaultRequestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := r.PutDecision(ctx, &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: false}); err != nil {
+		t.Fatalf("PutDecision() under a %v budget error = %v", 2*server.DefaultRequestTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < hold-100*time.Millisecond {
+		t.Errorf("PutDecision() took %v, want it to wait for the lock", elapsed)
+	}
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/bloom"
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+)
+
+// fakeDecidedSource is an in-memory stand-in for the decisions table.
+type fakeDecidedSource struct {
+	mu      sync.Mutex
+	decided map[string]map[string]bool
+	loads   int
+}
+
+func (f *fakeDecidedSource) put(actorID, recipientID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.decided[actorID] == nil {
+		f.decided[actorID] = map[string]bool{}
+	}
+	f.decided[actorID][recipientID] = true
+}
+
+func (f *fakeDecidedSource) DecidedRecipients(_ context.Context, actorID string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.loads++
+	var ids []string
+	for id := range f.decided[actorID] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeDecidedSource) FilterDecided(_ context.Context, actorID string, recipientIDs []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := map[string]bool{}
+	for _, id := range recipientIDs {
+		if f.decided[actorID][id] {
+			out[id] = true
+		}
+	}
+	return out, nil
+}
+
+func TestBloomFilter_FalsePositiveRate(t *testing.T) {
+	const n = 10_000
+	f := bloom.New(n, 0.01)
+	for i := range n {
+		f.Add("in-" + strconv.Itoa(i))
+	}
+
+	for i := range n {
+		if !f.Test("in-" + strconv.Itoa(i)) {
+			t.Fatalf("bloom.Filter.Test() false negative for in-%d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := range n {
+		if f.Test("out-" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("bloom.Filter false positive rate = %v, want <= 0.02", rate)
+	}
+}
+
+func TestDecidedCache_FilterUndecided(t *testing.T) {
+	src := &fakeDecidedSource{decided: map[string]map[string]bool{}}
+	src.put("1", "2")
+	src.put("1", "3")
+
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{})
+	ctx := context.Background()
+
+	got, err := c.FilterUndecided(ctx, "1", []string{"2", "3", "4", "5"})
+	if err != nil {
+		t.Fatalf("DecidedCache.FilterUndecided() error = %v", err)
+	}
+	if want := []string{"4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecidedCache.FilterUndecided() = %v, want %v", got, want)
+	}
+
+	// a new decision must be visible without reloading the filter
+	src.put("1", "4")
+	c.Add("1", "4")
+
+	undecided, err := c.DefinitelyUndecided(ctx, "1", "4")
+	if err != nil {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+	}
+	if undecided {
+		t.Errorf("DecidedCache.DefinitelyUndecided() = true after Add, want false")
+	}
+
+	if src.loads != 1 {
+		t.Errorf("DecidedCache loaded %d times, want 1", src.loads)
+	}
+}
+
+func TestDecidedCache_Eviction(t *testing.T) {
+	src := &fakeDecidedSource{decided: map[string]map[string]bool{}}
+
+	// each filter sized for 1024 items at 1% is ~1.2KiB, so only one fits
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{MaxBytes: 2000})
+	ctx := context.Background()
+
+	for _, actor := range []string{"1", "2", "3"} {
+		if _, err := c.DefinitelyUndecided(ctx, actor, "9"); err != nil {
+			t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.Evictions != 2 {
+		t.Errorf("DecidedCache.Stats() entries = %d evictions = %d, want 1 and 2", stats.Entries, stats.Evictions)
+	}
+	if stats.Bytes > 2000 {
+		t.Errorf("DecidedCache.Stats() bytes = %d, want <= 2000", stats.Bytes)
+	}
+}
+
+func TestDecidedCache_MaybeDecided(t *testing.T) {
+	src := &fakeDecidedSource{decided: map[string]map[string]bool{}}
+	src.put("1", "2")
+
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{})
+
+	got, err := c.MaybeDecided(context.Background(), "1", []string{"3", "2", "4"})
+	if err != nil {
+		t.Fatalf("DecidedCache.MaybeDecided() error = %v", err)
+	}
+	if want := []string{"2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecidedCache.MaybeDecided() = %v, want %v", got, want)
+	}
+	if stats := c.Stats(); stats.Lookups != 3 || stats.Undecided != 2 || stats.Confirmations != 0 {
+		t.Errorf("DecidedCache.Stats() = %+v, want 3 lookups, 2 undecided and no confirmations", stats)
+	}
+}
+
+func TestDecidedCache_MaxAge(t *testing.T) {
+	src := &fakeDecidedSource{decided: map[string]map[string]bool{}}
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{MaxAge: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	if _, err := c.DefinitelyUndecided(ctx, "1", "2"); err != nil {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+	}
+
+	// a decision stored through another server, so never passed to Add
+	src.put("1", "2")
+	time.Sleep(100 * time.Millisecond)
+
+	undecided, err := c.DefinitelyUndecided(ctx, "1", "2")
+	if err != nil {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+	}
+	if undecided {
+		t.Errorf("DecidedCache.DefinitelyUndecided() = true after MaxAge, want the filter rebuilt")
+	}
+	if stats := c.Stats(); stats.Loads != 2 || stats.Expirations != 1 {
+		t.Errorf("DecidedCache.Stats() loads = %d expirations = %d, want 2 and 1", stats.Loads, stats.Expirations)
+	}
+}
+
+// slowDecidedSource takes delay to list an actor's decisions, or until
+// ctx ends.
+type slowDecidedSource struct {
+	*fakeDecidedSource
+	delay time.Duration
+}
+
+func (s *slowDecidedSource) DecidedRecipients(ctx context.Context, actorID string) ([]string, error) {
+	select {
+	case <-time.After(s.delay):
+		return s.fakeDecidedSource.DecidedRecipients(ctx, actorID)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDecidedCache_LoadTimeout(t *testing.T) {
+	src := &slowDecidedSource{fakeDecidedSource: &fakeDecidedSource{decided: map[string]map[string]bool{}}, delay: 200 * time.Millisecond}
+	src.put("1", "2")
+
+	// A load may take as long as LoadTimeout allows.
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{LoadTimeout: time.Second})
+	undecided, err := c.DefinitelyUndecided(context.Background(), "1", "2")
+	if err != nil {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+	}
+	if undecided {
+		t.Error("DecidedCache.DefinitelyUndecided() = true, want the loaded decision")
+	}
+
+	// and no longer.
+	c = repository.NewDecidedCache(src, repository.DecidedCacheConfig{LoadTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := c.DefinitelyUndecided(context.Background(), "1", "2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("DecidedCache.DefinitelyUndecided() took %v, want the 50ms load timeout", elapsed)
+	}
+}
+
+func TestDecisionRepository_FilterDecided(t *testing.T) {
+	db, contClose := setupTestDB(t)
+	defer db.Close()
+	defer contClose()
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	dummies := []models.Decision{
+		{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true},
+		{ActorUserId: "1", RecipientUserId: "3", LikedRecipient: false},
+		{ActorUserId: "2", RecipientUserId: "4", LikedRecipient: true},
+	}
+	for _, v := range dummies {
+		if err := r.PutDecision(context.Background(), &v); err != nil {
+			t.Fatalf("DecisionRepository.FilterDecided() failed to put dummy decision error = %v", err)
+		}
+	}
+
+	got, err := r.FilterDecided(context.Background(), "1", []string{"2", "3", "4"})
+	if err != nil {
+		t.Fatalf("DecisionRepository.FilterDecided() error = %v", err)
+	}
+	if want := map[string]bool{"2": true, "3": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecisionRepository.FilterDecided() = %v, want %v", got, want)
+	}
+}
@@ -1261,3 +1261,59 @@ func TestDecisionRepository_ListNewLikedYou_Pagination(t *testing.T) {
 		t.Fatalf("cleanup failed: %v", err)
 	}
 }
+
+func TestDecisionRepository_PutDecisionTimestamps(t *testing.T) {
+	db, contClose := setupTestDB(t)
+	defer db.Close()
+	defer contClose()
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	first := &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}
+	if err := r.PutDecision(context.Background(), first); err != nil {
+		t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+	}
+	if first.CreatedAt.IsZero() || !first.UpdatedAt.Equal(first.CreatedAt) {
+		t.Errorf("new decision CreatedAt = %v, UpdatedAt = %v, want equal and set", first.CreatedAt, first.UpdatedAt)
+	}
+
+	second := &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: false}
+	if err := r.PutDecision(context.Background(), second); err != nil {
+		t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) || !second.UpdatedAt.After(second.CreatedAt) {
+		t.Errorf("overwrite CreatedAt = %v, UpdatedAt = %v, want original CreatedAt and later UpdatedAt", second.CreatedAt, second.UpdatedAt)
+	}
+}
+
+func TestDecisionRepository_PutDecisionPreviouslyLiked(t *testing.T) {
+	db, contClose := setupTestDB(t)
+	defer db.Close()
+	defer contClose()
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	steps := []struct {
+		liked, wantPreviouslyLiked bool
+	}{
+		{liked: false, wantPreviouslyLiked: false}, // first decision
+		{liked: true, wantPreviouslyLiked: false},  // pass turned into a like
+		{liked: true, wantPreviouslyLiked: true},   // like repeated
+		{liked: false, wantPreviouslyLiked: true},  // like withdrawn
+	}
+	for i, step := range steps {
+		d := &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: step.liked}
+		if err := r.PutDecision(context.Background(), d); err != nil {
+			t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+		}
+		if d.PreviouslyLiked != step.wantPreviouslyLiked {
+			t.Errorf("put %d: PreviouslyLiked = %v, want %v", i, d.PreviouslyLiked, step.wantPreviouslyLiked)
+		}
+	}
+}
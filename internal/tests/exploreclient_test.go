@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	"github.com/fleimkeipa/grpc-example/pkg/exploreclient"
+	"github.com/fleimkeipa/grpc-example/pkg/exploreclient/exploretest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// callCounter counts calls per method and can fail or delay them.
+type callCounter struct {
+	mu    sync.Mutex
+	calls map[string]int
+
+	// fail, if set, is called with the method and its call number from 1
+	// and returns the error to fail with, if any.
+	fail func(method string, n int) error
+	// delay, likewise, returns how long to hold the call.
+	delay func(method string, n int) time.Duration
+}
+
+func (c *callCounter) intercept(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = map[string]int{}
+	}
+	c.calls[method]++
+	n := c.calls[method]
+	c.mu.Unlock()
+
+	if c.delay != nil {
+		select {
+		case <-time.After(c.delay(method, n)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if c.fail != nil {
+		if err := c.fail(method, n); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+func (c *callCounter) count(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[method]
+}
+
+func TestExploreClient_Methods(t *testing.T) {
+	srv := exploretest.NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	if mutual, err := client.PutDecision(ctx, "1", "2", true); err != nil || mutual {
+		t.Fatalf("PutDecision() = %v, %v, want false, nil", mutual, err)
+	}
+	if mutual, err := client.PutDecision(ctx, "2", "1", true); err != nil || !mutual {
+		t.Fatalf("PutDecision() = %v, %v, want true, nil", mutual, err)
+	}
+	if count, err := client.CountLikedYou(ctx, "2"); err != nil || count != 1 {
+		t.Errorf("CountLikedYou() = %d, %v, want 1", count, err)
+	}
+
+	_, err := client.PutDecision(ctx, "1", "1", true)
+	if d, _ := apierror.Parse(err); d.Code != codes.InvalidArgument || d.Reason != apierror.ReasonSelfDecision {
+		t.Errorf("self decision = %+v, want InvalidArgument %s", d, apierror.ReasonSelfDecision)
+	}
+}
+
+func TestExploreClient_Iterators(t *testing.T) {
+	counter := &callCounter{}
+	srv := exploretest.NewServer(exploretest.WithPageSize(2), exploretest.WithInterceptors(counter.intercept))
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	for _, actor := range []string{"1", "2", "3", "4", "5"} {
+		srv.Put(actor, "9", true)
+	}
+	srv.Put("9", "4", true)
+	srv.Put("6", "9", false)
+
+	collect := func(seq func(func(exploreclient.Liker, error) bool)) []string {
+		var ids []string
+		for liker, err := range seq {
+			if err != nil {
+				t.Fatalf("iteration error = %v", err)
+			}
+			ids = append(ids, liker.ActorID)
+		}
+		return ids
+	}
+
+	if got := collect(client.LikedYou(ctx, "9")); strings.Join(got, ",") != "5,4,3,2,1" {
+		t.Errorf("LikedYou() = %v, want 5,4,3,2,1", got)
+	}
+	if n := counter.count("ListLikedYou"); n != 3 {
+		t.Errorf("ListLikedYou called %d times for 5 likers in pages of 2, want 3", n)
+	}
+	if got := collect(client.NewLikedYou(ctx, "9")); strings.Join(got, ",") != "5,3,2,1" {
+		t.Errorf("NewLikedYou() = %v, want 5,3,2,1 without the liked-back 4", got)
+	}
+
+	// Breaking out fetches no further pages.
+	before := counter.count("ListLikedYou")
+	for range client.LikedYou(ctx, "9") {
+		break
+	}
+	if n := counter.count("ListLikedYou") - before; n != 1 {
+		t.Errorf("early break fetched %d pages, want 1", n)
+	}
+
+	// An error ends the iteration after being yielded once.
+	counter.fail = func(method string, n int) error {
+		if method == "ListLikedYou" {
+			return status.Error(codes.PermissionDenied, "no")
+		}
+		return nil
+	}
+	var errs int
+	for _, err := range client.LikedYou(ctx, "9") {
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("error = %v, want PermissionDenied", err)
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Errorf("yielded %d errors, want 1", errs)
+	}
+}
+
+func TestExploreClient_RetriesAndHedging(t *testing.T) {
+	counter := &callCounter{
+		fail: func(method string, n int) error {
+			if method == "PutDecision" && n <= 2 {
+				return status.Error(codes.Unavailable, "try again")
+			}
+			return nil
+		},
+		delay: func(method string, n int) time.Duration {
+			if method == "CountLikedYou" && n == 1 {
+				return 5 * time.Second
+			}
+			return 0
+		},
+	}
+	srv := exploretest.NewServer(exploretest.WithInterceptors(counter.intercept))
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	if _, err := client.PutDecision(ctx, "1", "2", true); err != nil {
+		t.Fatalf("PutDecision() error = %v, want success on the third attempt", err)
+	}
+	if n := counter.count("PutDecision"); n != 3 {
+		t.Errorf("PutDecision attempts = %d, want 3", n)
+	}
+
+	// The first attempt hangs; the hedge sent after 200ms answers.
+	start := time.Now()
+	if count, err := client.CountLikedYou(ctx, "2"); err != nil || count != 1 {
+		t.Fatalf("CountLikedYou() = %d, %v, want 1", count, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("CountLikedYou() took %v, want the hedged attempt's answer", elapsed)
+	}
+
+	// Other codes aren't retried.
+	counter.fail = func(method string, n int) error {
+		return status.Error(codes.ResourceExhausted, "shed")
+	}
+	before := counter.count("PutDecision")
+	if _, err := client.PutDecision(ctx, "1", "3", true); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("PutDecision() error = %v, want ResourceExhausted", err)
+	}
+	if n := counter.count("PutDecision") - before; n != 1 {
+		t.Errorf("PutDecision attempts = %d, want 1", n)
+	}
+}
+
+func TestExploreClient_HedgesAreNotRetried(t *testing.T) {
+	counter := &callCounter{
+		fail: func(method string, n int) error {
+			return status.Error(codes.Unavailable, "try again")
+		},
+	}
+	srv := exploretest.NewServer(exploretest.WithInterceptors(counter.intercept))
+	defer srv.Close()
+
+	// Each copy of a hedged read is sent once, so a read that keeps
+	// failing costs DefaultHedgingPolicy.MaxAttempts calls in all.
+	if _, err := srv.Client().CountLikedYou(context.Background(), "2"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("CountLikedYou() error = %v, want Unavailable", err)
+	}
+	if n, want := counter.count("CountLikedYou"), exploreclient.DefaultHedgingPolicy.MaxAttempts; n != want {
+		t.Errorf("CountLikedYou attempts = %d, want %d", n, want)
+	}
+
+	// Without hedging, reads are retried instead.
+	client, err := srv.Dial(exploreclient.WithHedging(exploreclient.HedgingPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	before := counter.count("CountLikedYou")
+	if _, err := client.CountLikedYou(context.Background(), "2"); status.Code(err) != codes.Unavailable {
+		t.Fatalf("CountLikedYou() error = %v, want Unavailable", err)
+	}
+	if n := counter.count("CountLikedYou") - before; n != 4 {
+		t.Errorf("unhedged CountLikedYou attempts = %d, want 4", n)
+	}
+}
+
+func TestExploreClient_BearerToken(t *testing.T) {
+	var got atomic.Value
+	srv := exploretest.NewServer(exploretest.WithInterceptors(
+		func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			got.Store(strings.Join(md.Get("authorization"), ","))
+			return handler(ctx, req)
+		}))
+	defer srv.Close()
+
+	// exploretest has no TLS, so the token is only sent once allowed.
+	if _, err := srv.Dial(exploreclient.WithBearerToken("abc")); err == nil {
+		t.Fatal("Dial() with a bearer token and no TLS succeeded, want an error")
+	}
+	client, err := srv.Dial(exploreclient.WithBearerToken("abc"), exploreclient.WithInsecureToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.CountLikedYou(context.Background(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Load() != "Bearer abc" {
+		t.Errorf("authorization = %q, want Bearer abc", got.Load())
+	}
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/explorectl"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// pagedExplore serves n likers of every recipient, actor IDs 1 to n, in
+// pages of size with the offset of the next page as the token.
+type pagedExplore struct {
+	pb.UnimplementedExploreServiceServer
+
+	n, size int
+
+	mu     sync.Mutex
+	tokens []string
+	auth   []string
+}
+
+func (s *pagedExplore) ListLikedYou(ctx context.Context, req *pb.ListLikedYouRequest) (*pb.ListLikedYouResponse, error) {
+	s.mu.Lock()
+	s.tokens = append(s.tokens, req.GetPaginationToken())
+	s.mu.Unlock()
+
+	offset := 0
+	if t := req.GetPaginationToken(); t != "" {
+		var err error
+		if offset, err = strconv.Atoi(t); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "bad token")
+		}
+	}
+
+	resp := &pb.ListLikedYouResponse{}
+	for i := offset; i < min(offset+s.size, s.n); i++ {
+		resp.Likers = append(resp.Likers, &pb.ListLikedYouResponse_Liker{
+			ActorId:       strconv.Itoa(i + 1),
+			UnixTimestamp: uint64(1700000000 + i),
+		})
+	}
+	if offset+s.size < s.n {
+		next := strconv.Itoa(offset + s.size)
+		resp.NextPaginationToken = &next
+	}
+	return resp, nil
+}
+
+func (s *pagedExplore) CountLikedYou(ctx context.Context, req *pb.CountLikedYouRequest) (*pb.CountLikedYouResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.auth = append(s.auth, strings.Join(md.Get("authorization"), ","))
+	s.mu.Unlock()
+
+	return &pb.CountLikedYouResponse{Count: uint64(s.n)}, nil
+}
+
+func (s *pagedExplore) PutDecision(ctx context.Context, req *pb.PutDecisionRequest) (*pb.PutDecisionResponse, error) {
+	if req.GetActorUserId() == req.GetRecipientUserId() {
+		return nil, status.Error(codes.InvalidArgument, "actor and recipient must differ")
+	}
+	return &pb.PutDecisionResponse{MutualLikes: req.GetLikedRecipient()}, nil
+}
+
+func startExplore(t *testing.T, srv pb.ExploreServiceServer) string {
+	t.Helper()
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterExploreServiceServer(grpcServer, srv)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	return lis.Addr().String()
+}
+
+func explorectlRun(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	err := explorectl.Run(context.Background(), args, strings.NewReader(""), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestExplorectl_Listings(t *testing.T) {
+	srv := &pagedExplore{n: 5, size: 2}
+	addr := startExplore(t, srv)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "table",
+			args: []string{"likes", "-config", "", "-addr", addr, "7"},
+			want: "actor_id  liked_at              unix_timestamp\n" +
+				"1         2023-11-14T22:13:20Z  1700000000\n" +
+				"2         2023-11-14T22:13:21Z  1700000001\n" +
+				"3         2023-11-14T22:13:22Z  1700000002\n" +
+				"4         2023-11-14T22:13:23Z  1700000003\n" +
+				"5         2023-11-14T22:13:24Z  1700000004\n",
+		},
+		{
+			name: "csv with limit",
+			args: []string{"likes", "-config", "", "-addr", addr, "-o", "csv", "-limit", "3", "7"},
+			want: "actor_id,liked_at,unix_timestamp\n" +
+				"1,2023-11-14T22:13:20Z,1700000000\n" +
+				"2,2023-11-14T22:13:21Z,1700000001\n" +
+				"3,2023-11-14T22:13:22Z,1700000002\n",
+		},
+		{
+			name: "json",
+			args: []string{"likes", "-config", "", "-addr", addr, "-o", "json", "-limit", "2", "7"},
+			want: "[\n" +
+				`  {"actor_id":"1","liked_at":"2023-11-14T22:13:20Z","unix_timestamp":1700000000},` + "\n" +
+				`  {"actor_id":"2","liked_at":"2023-11-14T22:13:21Z","unix_timestamp":1700000001}` + "\n" +
+				"]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := explorectlRun(t, tt.args...)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("output =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// The first listing followed every token; the limited ones stopped
+	// once they had enough.
+	want := []string{"", "2", "4", "", "2", ""}
+	if strings.Join(srv.tokens, ",") != strings.Join(want, ",") {
+		t.Errorf("tokens requested = %q, want %q", srv.tokens, want)
+	}
+}
+
+func TestExplorectl_Commands(t *testing.T) {
+	addr := startExplore(t, &pagedExplore{n: 3, size: 2})
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		compact bool // compare JSON output without whitespace
+		wantErr string
+	}{
+		{name: "decide", args: []string{"decide", "-o", "json", "1", "2", "like"}, want: `{"mutual_likes":true}` + "\n"},
+		{name: "count", args: []string{"count", "-o", "csv", "2"}, want: "count\n3\n"},
+		{
+			name: "call",
+			args: []string{"call", "CountLikedYou", `{"recipient_user_id":"2"}`},
+			want: `{"count":"3"}`, compact: true,
+		},
+		{name: "server error", args: []string{"decide", "1", "1", "like"}, wantErr: "InvalidArgument: actor and recipient must differ"},
+		{name: "bad decision", args: []string{"decide", "1", "2", "maybe"}, wantErr: `decision must be like or pass, not "maybe"`},
+		{name: "unknown method", args: []string{"call", "Nope"}, wantErr: "want one of ListLikedYou, ListNewLikedYou, CountLikedYou, PutDecision"},
+		{name: "wrong arguments", args: []string{"count"}, wantErr: explorectl.ErrUsage.Error()},
+		{name: "unknown command", args: []string{"delete"}, wantErr: explorectl.ErrUsage.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{tt.args[0], "-config", "", "-addr", addr}, tt.args[1:]...)
+			got, err := explorectlRun(t, args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if tt.compact {
+				var b bytes.Buffer
+				json.Compact(&b, []byte(got))
+				got = b.String()
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplorectl_Profiles(t *testing.T) {
+	srv := &pagedExplore{n: 1, size: 1}
+	addr := startExplore(t, srv)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+current: dev
+profiles:
+  dev:
+    addr: `+addr+`
+    token: dev-token
+  other:
+    addr: 127.0.0.1:1
+    token: other-token
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := [][]string{
+		{"count", "-config", file, "1"},
+		{"count", "-config", file, "-token", "flag-token", "1"},
+		{"count", "-config", file, "-profile", "other", "-addr", addr, "1"},
+	}
+	for _, args := range runs {
+		if _, err := explorectlRun(t, args...); err != nil {
+			t.Fatalf("Run(%q) error = %v", args, err)
+		}
+	}
+
+	t.Setenv(explorectl.TokenEnv, "env-token")
+	if _, err := explorectlRun(t, "count", "-config", "", "-addr", addr, "1"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{"Bearer dev-token", "Bearer flag-token", "Bearer other-token", "Bearer env-token"}
+	if strings.Join(srv.auth, ",") != strings.Join(want, ",") {
+		t.Errorf("authorization = %q, want %q", srv.auth, want)
+	}
+
+	if _, err := explorectlRun(t, "count", "-config", file, "-profile", "prod", "1"); err == nil ||
+		!strings.Contains(err.Error(), `no profile "prod"`) {
+		t.Errorf("Run() error = %v, want the missing profile named", err)
+	}
+}
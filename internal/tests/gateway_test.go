@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/gateway"
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func newTestGateway(t *testing.T, interceptors ...grpc.UnaryServerInterceptor) *httptest.Server {
+	t.Helper()
+
+	svc := server.NewExploreServer(newMemoryDecisions())
+	handler, err := gateway.NewHandler(context.Background(), svc, interceptors...)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func gatewayCall(t *testing.T, ts *httptest.Server, method, path, body string, header http.Header) (*http.Response, map[string]any) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("%s %s returned %q, not JSON: %v", method, path, raw, err)
+	}
+	return resp, decoded
+}
+
+func TestGateway_Routes(t *testing.T) {
+	ts := newTestGateway(t)
+
+	steps := []struct {
+		method, path, body string
+		wantStatus         int
+		want               string // JSON the response must equal, if set
+	}{
+		{
+			method: "POST", path: "/v1/decisions",
+			body:       `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true}`,
+			wantStatus: http.StatusOK, want: `{"mutual_likes":false}`,
+		},
+		{
+			method: "POST", path: "/v1/decisions",
+			body:       `{"actor_user_id":"3","recipient_user_id":"2","liked_recipient":true}`,
+			wantStatus: http.StatusOK, want: `{"mutual_likes":false}`,
+		},
+		{
+			method: "POST", path: "/v1/decisions",
+			body:       `{"actor_user_id":"2","recipient_user_id":"1","liked_recipient":true}`,
+			wantStatus: http.StatusOK, want: `{"mutual_likes":true}`,
+		},
+		{method: "GET", path: "/v1/users/2/likes/count", wantStatus: http.StatusOK, want: `{"count":"2"}`},
+		{method: "GET", path: "/v1/users/2/likes", wantStatus: http.StatusOK},
+		{method: "GET", path: "/v1/users/2/likes/new", wantStatus: http.StatusOK},
+		{
+			method: "POST", path: "/v1/decisions",
+			body:       `{"actor_user_id":"1","recipient_user_id":"1","liked_recipient":true}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{method: "GET", path: "/v1/users/abc/likes", wantStatus: http.StatusBadRequest},
+		{method: "DELETE", path: "/v1/decisions", wantStatus: http.StatusNotImplemented},
+	}
+	for _, step := range steps {
+		resp, got := gatewayCall(t, ts, step.method, step.path, step.body, nil)
+		if resp.StatusCode != step.wantStatus {
+			t.Fatalf("%s %s status = %d, want %d (body %v)", step.method, step.path, resp.StatusCode, step.wantStatus, got)
+		}
+		if step.want != "" {
+			var want map[string]any
+			json.Unmarshal([]byte(step.want), &want)
+			if !jsonEqual(got, want) {
+				t.Errorf("%s %s = %v, want %v", step.method, step.path, got, want)
+			}
+		}
+	}
+
+	_, likes := gatewayCall(t, ts, "GET", "/v1/users/2/likes", "", nil)
+	likers, _ := likes["likers"].([]any)
+	if len(likers) != 2 {
+		t.Fatalf("likes = %v, want 2 likers", likes)
+	}
+	if first, _ := likers[0].(map[string]any); first["actor_id"] == nil || first["unix_timestamp"] == nil {
+		t.Errorf("liker = %v, want actor_id and unix_timestamp", first)
+	}
+
+	// User 1 was liked back, so only user 3 is new.
+	_, newLikes := gatewayCall(t, ts, "GET", "/v1/users/2/likes/new", "", nil)
+	if likers, _ := newLikes["likers"].([]any); len(likers) != 1 {
+		t.Errorf("new likes = %v, want 1 liker", newLikes)
+	}
+
+	_, bad := gatewayCall(t, ts, "POST", "/v1/decisions",
+		`{"actor_user_id":"1","recipient_user_id":"1","liked_recipient":true}`, nil)
+	if bad["code"] != float64(codes.InvalidArgument) || bad["message"] == "" {
+		t.Errorf("error body = %v, want a google.rpc.Status with code %d", bad, codes.InvalidArgument)
+	}
+}
+
+func jsonEqual(a, b map[string]any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestGateway_Interceptors(t *testing.T) {
+	var methods []string
+	record := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		methods = append(methods, info.FullMethod)
+		return handler(ctx, req)
+	}
+	shed := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if strings.HasSuffix(info.FullMethod, "/CountLikedYou") {
+			return nil, apierror.New(codes.Unavailable, apierror.ReasonUnavailable, "down",
+				apierror.WithRetryDelay(1500*time.Millisecond))
+		}
+		return handler(ctx, req)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ts := newTestGateway(t, logging.UnaryServerInterceptor(logger, 1), record, shed)
+
+	resp, _ := gatewayCall(t, ts, "GET", "/v1/users/2/likes", "", http.Header{"X-Request-Id": {"req-42"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != "req-42" {
+		t.Errorf("X-Request-Id = %q, want the request's echoed back", got)
+	}
+
+	resp, body := gatewayCall(t, ts, "GET", "/v1/users/2/likes/count", "", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 1.5s rounded up to 2", got)
+	}
+	if details, _ := body["details"].([]any); len(details) == 0 {
+		t.Errorf("error body = %v, want the status details", body)
+	}
+
+	want := []string{"/explore.ExploreService/ListLikedYou", "/explore.ExploreService/CountLikedYou"}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("interceptors saw %v, want %v", methods, want)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/healthcheck"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func servingStatus(t *testing.T, hs *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if status.Code(err) == codes.NotFound {
+		// not published by the monitor yet
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	if err != nil {
+		t.Fatalf("health.Server.Check() error = %v", err)
+	}
+	return resp.Status
+}
+
+func waitForStatus(t *testing.T, hs *health.Server, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servingStatus(t, hs, service) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("service %q status = %v, want %v", service, servingStatus(t, hs, service), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMonitor_Run(t *testing.T) {
+	const service = "explore.ExploreService"
+
+	var dbDown atomic.Bool
+	probe := func(context.Context) error {
+		if dbDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	hs := health.NewServer()
+	m := healthcheck.NewMonitor(hs, probe, "", service)
+	m.FailureThreshold = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx, 10*time.Millisecond)
+
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_SERVING)
+
+	dbDown.Store(true)
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_NOT_SERVING)
+	waitForStatus(t, hs, "", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	dbDown.Store(false)
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_SERVING)
+
+	// once shutting down, a healthy probe must not flip the status back
+	hs.Shutdown()
+	time.Sleep(50 * time.Millisecond)
+	if got := servingStatus(t, hs, service); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status after Shutdown() = %v, want NOT_SERVING", got)
+	}
+}
+
+func TestMonitor_ConclusiveAndRecheck(t *testing.T) {
+	const service = "explore.ExploreService"
+
+	var open atomic.Bool
+	probe := func(context.Context) error {
+		if open.Load() {
+			return healthcheck.Conclusive(errors.New("circuit breaker open"))
+		}
+		return nil
+	}
+
+	hs := health.NewServer()
+	m := healthcheck.NewMonitor(hs, probe, service)
+	m.FailureThreshold = 100
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The interval is far beyond the test, so only Recheck probes again.
+	go m.Run(ctx, time.Hour)
+
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_SERVING)
+
+	open.Store(true)
+	m.Recheck()
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	open.Store(false)
+	m.Recheck()
+	waitForStatus(t, hs, service, healthpb.HealthCheckResponse_SERVING)
+}
@@ -0,0 +1,402 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/importer"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/schema"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+)
+
+func numericIDs(t *testing.T) server.UserIDValidator {
+	t.Helper()
+
+	ids, err := server.NewUserIDValidator(server.UserIDNumeric, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// readAll returns the records of input and the lines of the invalid ones.
+func readAll(t *testing.T, input string, format importer.Format, from importer.Position) ([]importer.Record, []int64) {
+	t.Helper()
+
+	r, err := importer.NewReader(strings.NewReader(input), format, numericIDs(t), from)
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+
+	var records []importer.Record
+	var invalid []int64
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records, invalid
+		}
+		var recErr *importer.RecordError
+		if errors.As(err, &recErr) {
+			invalid = append(invalid, recErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestImportReader_Formats(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	updated := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format importer.Format
+		input  string
+	}{
+		{
+			name:   "jsonl",
+			format: importer.JSONL,
+			input: `{"actor_user_id":"１","recipient_user_id":"2","liked_recipient":true,"created_at":"2021-03-04T05:06:07Z","updated_at":1640995200}` + "\n" +
+				"\n" +
+				`{"actor_user_id":"3","recipient_user_id":"2","liked_recipient":false,"created_at":1614834367}`,
+		},
+		{
+			name:   "csv",
+			format: importer.CSV,
+			input: "recipient_user_id,actor_user_id,created_at,liked_recipient,updated_at\n" +
+				"2,１,2021-03-04T05:06:07Z,true,1640995200\r\n" +
+				"2,3,1614834367,false,\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, invalid := readAll(t, tt.input, tt.format, importer.Position{})
+			if len(invalid) > 0 || len(records) != 2 {
+				t.Fatalf("read %d records with invalid lines %v, want 2 valid", len(records), invalid)
+			}
+
+			first, second := records[0], records[1]
+			if first.ActorUserId != "1" || first.RecipientUserId != "2" || !first.LikedRecipient {
+				t.Errorf("first = %+v, want the canonical actor 1 liking 2", first.Decision)
+			}
+			if !first.CreatedAt.Equal(created) || !first.UpdatedAt.Equal(updated) {
+				t.Errorf("first timestamps = %v, %v, want %v, %v", first.CreatedAt, first.UpdatedAt, created, updated)
+			}
+			if second.LikedRecipient || !second.UpdatedAt.Equal(created) {
+				t.Errorf("second = %+v, want a pass updated at its creation", second.Decision)
+			}
+			if wantLine := int64(3); second.Line != wantLine {
+				t.Errorf("second record on line %d, want %d", second.Line, wantLine)
+			}
+		})
+	}
+}
+
+func TestImportReader_Invalid(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+	}{
+		{name: "bad actor", line: `{"actor_user_id":"x","recipient_user_id":"2","liked_recipient":true,"created_at":1}`, wantErr: "actor_user_id"},
+		{name: "self", line: `{"actor_user_id":"2","recipient_user_id":"2","liked_recipient":true,"created_at":1}`, wantErr: "must differ"},
+		{name: "no decision", line: `{"actor_user_id":"1","recipient_user_id":"2","created_at":1}`, wantErr: "liked_recipient is required"},
+		{name: "no created_at", line: `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true}`, wantErr: "created_at is required"},
+		{name: "bad timestamp", line: `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true,"created_at":"yesterday"}`, wantErr: "RFC 3339"},
+		{name: "updated first", line: `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true,"created_at":10,"updated_at":5}`, wantErr: "before created_at"},
+		{name: "future", line: `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true,"created_at":"` + future + `"}`, wantErr: "future"},
+		{name: "unknown field", line: `{"actor_id":"1","recipient_user_id":"2","liked_recipient":true,"created_at":1}`, wantErr: "unknown field"},
+		{name: "not JSON", line: `actor,recipient`, wantErr: "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := importer.NewReader(strings.NewReader(tt.line+"\n"), importer.JSONL, numericIDs(t), importer.Position{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = r.Next()
+			var recErr *importer.RecordError
+			if !errors.As(err, &recErr) || recErr.Line != 1 || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Next() error = %v, want a record error on line 1 containing %q", err, tt.wantErr)
+			}
+			// reading carries on after an invalid record
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("Next() after the invalid record = %v, want EOF", err)
+			}
+		})
+	}
+
+	// A quoted CSV field spanning lines is one row, and lines are counted
+	// through it.
+	input := "actor_user_id,recipient_user_id,liked_recipient,created_at\n" +
+		"\"1\nx\",2,true,1\n" +
+		"1,2,true\n" +
+		"3,2,true,1\n"
+	records, invalid := readAll(t, input, importer.CSV, importer.Position{})
+	if len(records) != 1 || records[0].Line != 5 {
+		t.Errorf("records = %+v, want one on line 5", records)
+	}
+	if len(invalid) != 2 || invalid[0] != 2 || invalid[1] != 4 {
+		t.Errorf("invalid lines = %v, want [2 4]", invalid)
+	}
+
+	for _, header := range []string{"actor_user_id,recipient_user_id,liked_recipient\n", "actor_user_id,recipient,liked_recipient,created_at\n"} {
+		if _, err := importer.NewReader(strings.NewReader(header), importer.CSV, numericIDs(t), importer.Position{}); err == nil {
+			t.Errorf("NewReader(%q) succeeded, want a header error", header)
+		}
+	}
+}
+
+func TestImportReader_Resume(t *testing.T) {
+	input := "actor_user_id,recipient_user_id,liked_recipient,created_at\n" +
+		"1,9,true,1\n" +
+		"2,9,true,2\n" +
+		"3,9,true,3\n"
+
+	r, err := importer.NewReader(strings.NewReader(input), importer.CSV, numericIDs(t), importer.Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	pos := r.Pos()
+	if pos.Line != 2 || pos.Offset != int64(strings.Index(input, "2,9")) {
+		t.Fatalf("Pos() = %+v, want line 2 at the start of the second row", pos)
+	}
+
+	// Seekable and streamed sources resume alike, below the header.
+	path := filepath.Join(t.TempDir(), "decisions.csv")
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for name, src := range map[string]io.Reader{"file": f, "stream": strings.NewReader(input)} {
+		r, err := importer.NewReader(src, importer.CSV, numericIDs(t), pos)
+		if err != nil {
+			t.Fatalf("%s: NewReader() error = %v", name, err)
+		}
+		rec, err := r.Next()
+		if err != nil || rec.ActorUserId != "2" || rec.Line != 3 {
+			t.Errorf("%s: first record after resuming = %+v, %v, want actor 2 on line 3", name, rec, err)
+		}
+	}
+}
+
+func TestImporter_DryRun(t *testing.T) {
+	input := `{"actor_user_id":"1","recipient_user_id":"2","liked_recipient":true,"created_at":1}` + "\n" +
+		`{"actor_user_id":"1","recipient_user_id":"1","liked_recipient":true,"created_at":1}` + "\n" +
+		`{"actor_user_id":"2","recipient_user_id":"1","liked_recipient":true,"created_at":2}` + "\n" +
+		`{"actor_user_id":"x","recipient_user_id":"1","liked_recipient":true,"created_at":2}` + "\n"
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+	run := func(maxErrors int64) (importer.Report, error) {
+		im, err := importer.New(nil, repository.ShardMap{}, importer.Config{
+			Format:     importer.JSONL,
+			UserIDs:    numericIDs(t),
+			BatchSize:  1,
+			MaxErrors:  maxErrors,
+			DryRun:     true,
+			Checkpoint: checkpoint,
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return im.Run(context.Background(), "decisions.jsonl", strings.NewReader(input))
+	}
+
+	// Every invalid record is reported before the dry run fails.
+	report, err := run(1)
+	if err == nil || !strings.Contains(err.Error(), "2 invalid records") {
+		t.Errorf("Run() error = %v, want 2 invalid records over the limit", err)
+	}
+	if report.Records != 2 || report.Invalid != 2 || report.Inserted != 0 || report.Line != 4 {
+		t.Errorf("report = %+v, want 2 valid and 2 invalid records through line 4 and nothing written", report)
+	}
+
+	if _, err := run(2); err != nil {
+		t.Errorf("Run() with 2 errors allowed error = %v", err)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("a dry run wrote the checkpoint: %v", err)
+	}
+
+	if _, err := importer.New(nil, repository.ShardMap{}, importer.Config{Format: importer.JSONL, UserIDs: numericIDs(t)}); err == nil {
+		t.Error("New() without a database succeeded outside a dry run")
+	}
+}
+
+// importRun imports input into dbs with a checkpoint at checkpoint.
+func importRun(t *testing.T, dbs []*sql.DB, shardMap repository.ShardMap, checkpoint, input string) (importer.Report, error) {
+	t.Helper()
+
+	im, err := importer.New(dbs, shardMap, importer.Config{
+		Format:     importer.CSV,
+		UserIDs:    numericIDs(t),
+		BatchSize:  2,
+		Checkpoint: checkpoint,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return im.Run(context.Background(), "decisions.csv", strings.NewReader(input))
+}
+
+type importedRow struct {
+	liked              bool
+	createdAt, updated int64
+}
+
+func importedRows(t *testing.T, db *sql.DB) map[string]importedRow {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT actor_user_id || '>' || recipient_user_id, liked_recipient,
+		EXTRACT(EPOCH FROM created_at)::BIGINT, EXTRACT(EPOCH FROM updated_at)::BIGINT FROM decisions`)
+	if err != nil {
+		t.Fatalf("failed to read decisions: %v", err)
+	}
+	defer rows.Close()
+
+	got := map[string]importedRow{}
+	for rows.Next() {
+		var pair string
+		var row importedRow
+		if err := rows.Scan(&pair, &row.liked, &row.createdAt, &row.updated); err != nil {
+			t.Fatal(err)
+		}
+		got[pair] = row
+	}
+	return got
+}
+
+func TestImporter_Merge(t *testing.T) {
+	layouts := []struct {
+		name  string
+		setup func(t *testing.T) (*sql.DB, func())
+	}{
+		{name: "on conflict", setup: setupTestDB},
+		{name: "locked", setup: func(t *testing.T) (*sql.DB, func()) {
+			return setupPartitionedDB(t, schema.Options{HashPartitions: 4, TimePartitions: true, MonthsAhead: 1})
+		}},
+	}
+
+	// 1>2 is liked, passed on and liked again, within and across batches;
+	// 3>2 already exists with a later decision than the import's.
+	input := "actor_user_id,recipient_user_id,liked_recipient,created_at,updated_at\n" +
+		"1,2,true,100,100\n" +
+		"1,2,false,100,200\n" +
+		"3,2,false,50,60\n" +
+		"1,2,true,300,400\n" +
+		"4,2,true,10,10\n"
+
+	for _, layout := range layouts {
+		t.Run(layout.name, func(t *testing.T) {
+			db, contClose := layout.setup(t)
+			defer contClose()
+
+			if _, err := db.Exec(`INSERT INTO decisions (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+				VALUES ('3', '2', true, to_timestamp(70), to_timestamp(90))`); err != nil {
+				t.Fatalf("failed to insert existing decision: %v", err)
+			}
+
+			checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+			report, err := importRun(t, []*sql.DB{db}, repository.ShardMap{}, checkpoint, input)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if report.Records != 5 || report.Inserted != 2 || report.Updated != 2 {
+				t.Errorf("report = %+v, want 5 records, 2 pairs inserted and 2 updated", report)
+			}
+
+			want := map[string]importedRow{
+				"1>2": {liked: true, createdAt: 100, updated: 400},
+				"3>2": {liked: true, createdAt: 50, updated: 90},
+				"4>2": {liked: true, createdAt: 10, updated: 10},
+			}
+			got := importedRows(t, db)
+			for pair, row := range want {
+				if got[pair] != row {
+					t.Errorf("%s = %+v, want %+v", pair, got[pair], row)
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("decisions has %d rows, want %d", len(got), len(want))
+			}
+
+			// Importing again from scratch changes nothing; resuming the
+			// finished import reads nothing.
+			os.Remove(checkpoint)
+			if _, err := importRun(t, []*sql.DB{db}, repository.ShardMap{}, checkpoint, input); err != nil {
+				t.Fatalf("second Run() error = %v", err)
+			}
+			report, err = importRun(t, []*sql.DB{db}, repository.ShardMap{}, checkpoint, input)
+			if err != nil || !report.Resumed || report.Records != 5 {
+				t.Errorf("resumed Run() = %+v, %v, want the finished checkpoint's counts", report, err)
+			}
+			got = importedRows(t, db)
+			for pair, row := range want {
+				if got[pair] != row {
+					t.Errorf("after re-import %s = %+v, want %+v", pair, got[pair], row)
+				}
+			}
+		})
+	}
+}
+
+func TestImporter_ResumeAndShards(t *testing.T) {
+	dbs, contClose := setupTestShards(t, 2)
+	defer contClose()
+
+	shardMap := repository.EvenShardMap(1024, 2)
+	input := "actor_user_id,recipient_user_id,liked_recipient,created_at\n" +
+		"1,2,true,1\n" +
+		"2,3,true,2\n" +
+		"3,4,true,3\n" +
+		"x,5,true,4\n" +
+		"5,6,true,5\n"
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// The invalid row stops the import after the first batch of two.
+	if _, err := importRun(t, dbs, shardMap, checkpoint, input); err == nil || !strings.Contains(err.Error(), "line 5") {
+		t.Fatalf("Run() error = %v, want the invalid record on line 5", err)
+	}
+
+	fixed := strings.Replace(input, "x,5", "4,5", 1)
+	report, err := importRun(t, dbs, shardMap, checkpoint, fixed)
+	if err != nil {
+		t.Fatalf("resumed Run() error = %v", err)
+	}
+	if !report.Resumed || report.Records != 5 || report.Inserted != 5 {
+		t.Errorf("report = %+v, want a resumed import of 5 records in all", report)
+	}
+
+	for _, recipient := range []string{"2", "3", "4", "5", "6"} {
+		db := dbs[shardMap.ShardFor(recipient)]
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM decisions WHERE recipient_user_id = $1`, recipient).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("shard owning %s has %d of its decisions, want 1", recipient, n)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/limiter"
+	"github.com/fleimkeipa/grpc-example/internal/metrics"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter_Priorities(t *testing.T) {
+	l := limiter.New(limiter.Config{InitialLimit: 10, MinLimit: 10, MaxLimit: 10, Backoff: 0.5})
+
+	// Fill the limit with as many calls of each priority as it admits,
+	// lowest first: low gets 60%, normal up to 80% and high the rest.
+	tests := []struct {
+		priority limiter.Priority
+		want     int
+	}{
+		{limiter.PriorityLow, 6},
+		{limiter.PriorityNormal, 2},
+		{limiter.PriorityHigh, 2},
+	}
+	for _, tt := range tests {
+		admitted := 0
+		for {
+			if _, ok := l.Acquire(tt.priority); !ok {
+				break
+			}
+			admitted++
+		}
+		if admitted != tt.want {
+			t.Errorf("%v priority admitted %d more calls, want %d", tt.priority, admitted, tt.want)
+		}
+	}
+
+	if got := l.InFlight(); got != 10 {
+		t.Errorf("InFlight() = %d, want 10", got)
+	}
+}
+
+func TestLimiter_AIMD(t *testing.T) {
+	l := limiter.New(limiter.Config{InitialLimit: 8, MinLimit: 2, MaxLimit: 9, Backoff: 0.5})
+
+	run := func(n int, overloaded bool) {
+		var done []func(bool)
+		for range n {
+			release, ok := l.Acquire(limiter.PriorityHigh)
+			if !ok {
+				t.Fatalf("call rejected at limit %d", l.Limit())
+			}
+			done = append(done, release)
+		}
+		for _, release := range done {
+			release(overloaded)
+		}
+	}
+
+	run(1, true)
+	if got := l.Limit(); got != 4 {
+		t.Fatalf("Limit() after overload = %d, want 4", got)
+	}
+
+	run(1, true)
+	run(1, true)
+	if got := l.Limit(); got != 2 {
+		t.Fatalf("Limit() = %d, want it held at MinLimit 2", got)
+	}
+
+	// A call alone under the limit says nothing about capacity.
+	l2 := limiter.New(limiter.Config{InitialLimit: 8, MinLimit: 2, MaxLimit: 9, Backoff: 0.5})
+	for range 20 {
+		release, _ := l2.Acquire(limiter.PriorityHigh)
+		release(false)
+	}
+	if got := l2.Limit(); got != 8 {
+		t.Errorf("Limit() after idle successes = %d, want 8", got)
+	}
+
+	// Successes at full use grow the limit by about one per limit's worth
+	// of calls, up to MaxLimit.
+	for range 30 {
+		run(l.Limit(), false)
+	}
+	if got := l.Limit(); got != 9 {
+		t.Errorf("Limit() after sustained successes = %d, want MaxLimit 9", got)
+	}
+}
+
+type shedCounter map[string]int
+
+func (c shedCounter) CallShed(method, priority string) {
+	c[method+" "+priority]++
+}
+
+func TestLimiter_Interceptor(t *testing.T) {
+	const (
+		put    = "/explore.ExploreService/PutDecision"
+		list   = "/explore.ExploreService/ListLikedYou"
+		health = "/grpc.health.v1.Health/Check"
+	)
+
+	tests := []struct {
+		name       string
+		rejectCode codes.Code
+		wantCode   codes.Code
+	}{
+		{name: "default code", wantCode: codes.ResourceExhausted},
+		{name: "unavailable", rejectCode: codes.Unavailable, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shed := shedCounter{}
+			l := limiter.New(limiter.Config{
+				InitialLimit: 2,
+				MinLimit:     2,
+				MaxLimit:     2,
+				Backoff:      0.5,
+				RejectCode:   tt.rejectCode,
+				RetryAfter:   2 * time.Second,
+				Priorities: map[string]limiter.Priority{
+					"PutDecision":  limiter.PriorityHigh,
+					"ListLikedYou": limiter.PriorityLow,
+				},
+			}, limiter.WithObserver(shed), limiter.ExemptMethods("/grpc.health.v1.Health/"))
+			interceptor := l.UnaryServerInterceptor()
+
+			// Hold one slot with a call that blocks until released.
+			release := make(chan struct{})
+			started := make(chan struct{})
+			go interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: put},
+				func(context.Context, any) (any, error) {
+					close(started)
+					<-release
+					return nil, nil
+				})
+			<-started
+
+			call := func(method string) error {
+				_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+					func(context.Context, any) (any, error) { return "ok", nil })
+				return err
+			}
+
+			// Low priority may use one slot of two, which is taken.
+			err := call(list)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("ListLikedYou code = %v, want %v", code, tt.wantCode)
+			}
+			d, _ := apierror.Parse(err)
+			if d.Reason != apierror.CodeReason(tt.wantCode) || d.RetryDelay != 2*time.Second || d.Metadata["priority"] != "low" {
+				t.Errorf("details = %+v, want reason %s, 2s retry delay and low priority", d, apierror.CodeReason(tt.wantCode))
+			}
+			if shed[list+" low"] != 1 {
+				t.Errorf("observer saw %v, want one shed ListLikedYou", shed)
+			}
+
+			if err := call(put); err != nil {
+				t.Errorf("PutDecision error = %v, want it admitted", err)
+			}
+			if err := call(health); err != nil {
+				t.Errorf("health check error = %v, want it exempt", err)
+			}
+
+			close(release)
+		})
+	}
+}
+
+func TestLimiter_ReleasesOnFailureAndPanic(t *testing.T) {
+	l := limiter.New(limiter.Config{InitialLimit: 4, MinLimit: 1, MaxLimit: 4, Backoff: 0.5})
+	interceptor := l.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+
+	// A deadline hit by the handler is overload and halves the limit.
+	_, err := interceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, context.DeadlineExceeded
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the handler's", err)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Limit() = %d, want 2 after a deadline", got)
+	}
+
+	// A client error isn't.
+	interceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "bad")
+	})
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Limit() = %d, want 2 after a client error", got)
+	}
+
+	// Nor is a call an open circuit breaker rejected, although it is
+	// Unavailable.
+	store := &flakyStore{memoryDecisions: newMemoryDecisions()}
+	store.set(driver.ErrBadConn, 0)
+	b := newTestBreaker(store, &transitions{})
+	for range 4 {
+		b.CountLikedYou(context.Background(), "1")
+	}
+	_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, _ any) (any, error) {
+		return b.CountLikedYou(ctx, "1")
+	})
+	if !errors.Is(err, repository.ErrCircuitOpen) {
+		t.Fatalf("error = %v, want ErrCircuitOpen", err)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Limit() = %d, want 2 after a circuit breaker rejection", got)
+	}
+
+	func() {
+		defer func() { recover() }()
+		interceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+			panic("boom")
+		})
+	}()
+	if got := l.InFlight(); got != 0 {
+		t.Errorf("InFlight() = %d after a panic, want 0", got)
+	}
+}
+
+func TestLimiter_ShortDeadlines(t *testing.T) {
+	l := limiter.New(limiter.Config{InitialLimit: 4, MinLimit: 1, MaxLimit: 4, Backoff: 0.5})
+	interceptor := l.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+	svc := server.NewExploreServer(newMemoryDecisions(), server.WithTimeoutPolicy(server.TimeoutPolicy{
+		Default:   time.Second,
+		MinBudget: 200 * time.Millisecond,
+	}))
+
+	// A caller turned away for having too little time left isn't overload.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := interceptor(ctx, nil, info, func(ctx context.Context, _ any) (any, error) {
+		return svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "1"})
+	})
+	if reason := apierror.Reason(err); reason != apierror.ReasonDeadlineExceeded {
+		t.Fatalf("error = %v, want the budget rejection", err)
+	}
+	if got := l.Limit(); got != 4 {
+		t.Errorf("Limit() = %d, want 4 after a budget rejection", got)
+	}
+
+	// Nor is a call that ran out of the caller's own deadline.
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, _ any) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the caller's deadline", err)
+	}
+	if got := l.Limit(); got != 4 {
+		t.Errorf("Limit() = %d, want 4 after the caller's deadline", got)
+	}
+
+	// The server's own, shorter timeout firing is.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, _ any) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the server's deadline", err)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Limit() = %d, want 2 after the server's deadline", got)
+	}
+}
+
+func TestMetrics_Limiter(t *testing.T) {
+	m := metrics.New()
+	l := limiter.New(limiter.Config{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, Backoff: 0.5}, limiter.WithObserver(m))
+	m.WatchLimiter(l)
+	interceptor := l.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+
+	interceptor(context.Background(), nil, info, func(ctx context.Context, _ any) (any, error) {
+		// Shed while the only slot is taken.
+		return interceptor(ctx, nil, info, func(context.Context, any) (any, error) { return nil, nil })
+	})
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`explore_limiter_limit 1`,
+		`explore_limiter_in_flight 0`,
+		`explore_limiter_shed_total{method="/explore.ExploreService/CountLikedYou",priority="normal"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
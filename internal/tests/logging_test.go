@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// logLines decodes the JSON lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("invalid log line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLogging_UnaryServerInterceptor(t *testing.T) {
+	req := &pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "2"}
+	info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/PutDecision"}
+	callCtx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 4242}})
+
+	tests := []struct {
+		name       string
+		requestID  string
+		sampleRate float64
+		handlerErr error
+		wantLogged bool
+		wantLevel  string
+	}{
+		{name: "success logged", requestID: "req-1", sampleRate: 1, wantLogged: true, wantLevel: "INFO"},
+		{name: "success sampled out", requestID: "req-2", sampleRate: 0, wantLogged: false},
+		{name: "caller error always logged", sampleRate: 0, handlerErr: status.Error(codes.InvalidArgument, "bad"), wantLogged: true, wantLevel: "WARN"},
+		{name: "server error always logged", sampleRate: 0, handlerErr: status.Error(codes.Internal, "boom"), wantLogged: true, wantLevel: "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := logging.New(&buf, logging.Config{Format: logging.FormatJSON})
+			if err != nil {
+				t.Fatalf("logging.New() error = %v", err)
+			}
+
+			ctx := callCtx
+			if tt.requestID != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(logging.RequestIDHeader, tt.requestID))
+			}
+
+			var seenID string
+			_, _ = logging.UnaryServerInterceptor(logger, tt.sampleRate)(ctx, req, info, func(ctx context.Context, _ any) (any, error) {
+				seenID = logging.RequestID(ctx)
+				logging.FromContext(ctx).InfoContext(ctx, "inside handler")
+				return nil, tt.handlerErr
+			})
+
+			if tt.requestID != "" && seenID != tt.requestID {
+				t.Errorf("RequestID() = %q, want %q", seenID, tt.requestID)
+			}
+			if tt.requestID == "" && len(seenID) != 32 {
+				t.Errorf("generated RequestID() = %q, want 32 hex characters", seenID)
+			}
+
+			lines := logLines(t, &buf)
+			if got := lines[0]; got["msg"] != "inside handler" || got["request_id"] != seenID || got["actor_user_id"] != "1" || got["peer"] != "10.0.0.7:4242" {
+				t.Errorf("handler log line = %v, want request-scoped attributes", got)
+			}
+
+			if !tt.wantLogged {
+				if len(lines) != 1 {
+					t.Errorf("logged %d lines, want only the handler's", len(lines))
+				}
+				return
+			}
+			if len(lines) != 2 {
+				t.Fatalf("logged %d lines, want 2", len(lines))
+			}
+			if got := lines[1]; got["msg"] != "rpc finished" || got["level"] != tt.wantLevel || got["method"] != info.FullMethod {
+				t.Errorf("call log line = %v, want level %s", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLogging_RedactUserIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{RedactUserIDs: true, RedactKey: []byte("k")})
+	if err != nil {
+		t.Fatalf("logging.New() error = %v", err)
+	}
+
+	logger.Info("first", logging.KeyActorUserID, "12345", "other", "12345")
+	logger.Info("second", logging.KeyRecipientUserID, "12345")
+
+	lines := logLines(t, &buf)
+	actor, _ := lines[0][logging.KeyActorUserID].(string)
+	if actor == "12345" || !strings.HasPrefix(actor, "h:") {
+		t.Errorf("actor_user_id = %q, want a redacted hash", actor)
+	}
+	if lines[0]["other"] != "12345" {
+		t.Errorf("other = %v, want non-ID attributes untouched", lines[0]["other"])
+	}
+	if lines[1][logging.KeyRecipientUserID] != actor {
+		t.Errorf("recipient_user_id = %v, want the same hash %q for the same ID", lines[1][logging.KeyRecipientUserID], actor)
+	}
+}
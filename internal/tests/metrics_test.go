@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/metrics"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetrics_ExploreServer(t *testing.T) {
+	m := metrics.New()
+	svc := server.NewExploreServer(newMemoryDecisions(), server.WithObserver(m))
+	interceptor := m.UnaryServerInterceptor()
+
+	put := func(actor, recipient string, liked bool) error {
+		info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/PutDecision"}
+		_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, _ any) (any, error) {
+			return svc.PutDecision(ctx, &pb.PutDecisionRequest{
+				ActorUserId:     actor,
+				RecipientUserId: recipient,
+				LikedRecipient:  liked,
+			})
+		})
+		return err
+	}
+
+	steps := []struct {
+		actor, recipient string
+		liked            bool
+		wantCode         codes.Code
+	}{
+		{"1", "2", true, codes.OK},
+		{"2", "1", true, codes.OK},  // match
+		{"2", "1", true, codes.OK},  // overwrite, not a new match
+		{"3", "1", false, codes.OK}, // pass
+		{"1", "3", true, codes.OK},
+		{"3", "1", true, codes.OK}, // pass turned into a like, a match
+		{"1", "1", true, codes.InvalidArgument},
+	}
+	for _, step := range steps {
+		if err := put(step.actor, step.recipient, step.liked); status.Code(err) != step.wantCode {
+			t.Fatalf("PutDecision(%s, %s) code = %v, want %v", step.actor, step.recipient, status.Code(err), step.wantCode)
+		}
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`explore_decisions_total{decision="like"} 5`,
+		`explore_decisions_total{decision="pass"} 1`,
+		`explore_decision_overwrites_total 2`,
+		`explore_matches_total 2`,
+		`explore_rpc_requests_total{code="OK",method="/explore.ExploreService/PutDecision"} 6`,
+		`explore_rpc_requests_total{code="InvalidArgument",method="/explore.ExploreService/PutDecision"} 1`,
+		`explore_rpc_duration_seconds_count{method="/explore.ExploreService/PutDecision"} 7`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestMetrics_WatchDB(t *testing.T) {
+	m := metrics.New()
+	m.WatchDB("primary", openUnreachableDB(t))
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`go_sql_open_connections{db_name="primary"} 0`,
+		`go_sql_in_use_connections{db_name="primary"} 0`,
+		`go_sql_wait_count_total{db_name="primary"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestMetrics_WatchDecidedCache(t *testing.T) {
+	src := &fakeDecidedSource{decided: map[string]map[string]bool{}}
+	src.put("1", "2")
+
+	c := repository.NewDecidedCache(src, repository.DecidedCacheConfig{MaxBytes: 2000})
+	m := metrics.New()
+	m.WatchDecidedCache(c)
+
+	ctx := context.Background()
+	if _, err := c.FilterUndecided(ctx, "1", []string{"2", "3"}); err != nil {
+		t.Fatalf("DecidedCache.FilterUndecided() error = %v", err)
+	}
+	// only one filter fits, so loading a second evicts the first
+	if _, err := c.DefinitelyUndecided(ctx, "2", "1"); err != nil {
+		t.Fatalf("DecidedCache.DefinitelyUndecided() error = %v", err)
+	}
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`explore_decided_cache_entries 1`,
+		`explore_decided_cache_lookups_total 3`,
+		`explore_decided_cache_undecided_total 2`,
+		`explore_decided_cache_confirmations_total 1`,
+		`explore_decided_cache_loads_total 2`,
+		`explore_decided_cache_evictions_total 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	if !strings.Contains(body, "explore_decided_cache_bytes ") {
+		t.Errorf("metrics missing explore_decided_cache_bytes")
+	}
+}
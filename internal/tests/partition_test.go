@@ -0,0 +1,346 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/schema"
+
+	"github.com/lib/pq"
+)
+
+var hashPartitionName = regexp.MustCompile(`^decisions_p(\d+)`)
+
+// scannedHashPartitions returns, for every table alias in the plan of
+// query, the set of hash partitions it scans. Repository statements are
+// prepared, so after five executions Postgres may switch to a generic plan
+// that can't prune at planning time; the plan is taken from a named
+// statement forced onto its generic plan, where pruning happens as the
+// executor starts.
+func scannedHashPartitions(t *testing.T, db *sql.DB, query string, args ...any) map[string]map[string]bool {
+	t.Helper()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get a connection: %v", err)
+	}
+	defer conn.Close()
+	defer conn.ExecContext(ctx, `DEALLOCATE ALL; RESET plan_cache_mode`)
+
+	if _, err := conn.ExecContext(ctx, `SET plan_cache_mode = force_generic_plan`); err != nil {
+		t.Fatalf("failed to force generic plans: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PREPARE pruned AS "+query); err != nil {
+		t.Fatalf("PREPARE failed: %v", err)
+	}
+	execute := "EXECUTE pruned(" + sqlLiterals(t, args) + ")"
+	for range 6 {
+		if _, err := conn.ExecContext(ctx, execute); err != nil {
+			t.Fatalf("EXECUTE failed: %v", err)
+		}
+	}
+
+	var raw string
+	if err := conn.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+execute).Scan(&raw); err != nil {
+		t.Fatalf("EXPLAIN failed: %v", err)
+	}
+
+	var plans []struct {
+		Plan map[string]any `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(raw), &plans); err != nil {
+		t.Fatalf("failed to parse EXPLAIN output: %v", err)
+	}
+
+	scanned := map[string]map[string]bool{}
+	var walk func(node map[string]any)
+	walk = func(node map[string]any) {
+		if rel, ok := node["Relation Name"].(string); ok {
+			if m := hashPartitionName.FindStringSubmatch(rel); m != nil {
+				alias, _ := node["Alias"].(string)
+				if scanned[alias] == nil {
+					scanned[alias] = map[string]bool{}
+				}
+				scanned[alias][m[1]] = true
+			}
+		}
+		children, _ := node["Plans"].([]any)
+		for _, child := range children {
+			walk(child.(map[string]any))
+		}
+	}
+	walk(plans[0].Plan)
+
+	return scanned
+}
+
+// sqlLiterals renders args as a comma-separated list of SQL literals.
+func sqlLiterals(t *testing.T, args []any) string {
+	t.Helper()
+
+	literals := make([]string, len(args))
+	for i, arg := range args {
+		if v, ok := arg.(driver.Valuer); ok {
+			var err error
+			if arg, err = v.Value(); err != nil {
+				t.Fatalf("failed to render %v: %v", arg, err)
+			}
+		}
+		switch v := arg.(type) {
+		case string:
+			literals[i] = pq.QuoteLiteral(v)
+		case bool:
+			literals[i] = strconv.FormatBool(v)
+		default:
+			t.Fatalf("can't render %T as SQL", arg)
+		}
+	}
+	return strings.Join(literals, ", ")
+}
+
+// partitionsFor merges the partitions scanned under alias and its
+// per-partition child aliases (alias_1, alias_2, ...).
+func partitionsFor(scanned map[string]map[string]bool, alias string) map[string]bool {
+	merged := map[string]bool{}
+	for a, parts := range scanned {
+		if a == alias || strings.HasPrefix(a, alias+"_") {
+			for p := range parts {
+				merged[p] = true
+			}
+		}
+	}
+	return merged
+}
+
+func setupPartitionedDB(t *testing.T, opts schema.Options) (*sql.DB, func()) {
+	db, close := GetTestInstance(context.Background())
+
+	if err := schema.Apply(context.Background(), db, opts); err != nil {
+		t.Fatalf("failed to create partitioned schema: %v", err)
+	}
+
+	return db, close
+}
+
+func TestDecisionRepository_PartitionPruning(t *testing.T) {
+	layouts := []struct {
+		name string
+		opts schema.Options
+	}{
+		{name: "hash", opts: schema.Options{HashPartitions: 8}},
+		{name: "hash and time", opts: schema.Options{HashPartitions: 8, TimePartitions: true, MonthsAhead: 2}},
+	}
+
+	// Every recipient-keyed query must touch exactly one hash partition for
+	// the alias filtered on recipient_user_id. listDecidedRecipients is keyed
+	// by actor alone and is expected to visit every partition, and the d2 side
+	// of ListNewLikedYou is probed once per liker, so neither is listed.
+	queries := []struct {
+		name  string
+		alias string
+		args  []any
+		time  bool // only runs with time sub-partitions
+	}{
+		{name: "countLikedYou", alias: "decisions", args: []any{"2"}},
+		{name: "checkMutualLikes", alias: "decisions", args: []any{"1", "2"}},
+		{name: "filterDecided", alias: "decisions", args: []any{"1", pq.Array([]string{"2"})}},
+		{name: "filterLiked", alias: "decisions", args: []any{"1", pq.Array([]string{"2"})}},
+		{name: "listLikedYou", alias: "decisions", args: []any{"2"}},
+		{name: "listLikedYouPage", alias: "decisions", args: []any{"2", "2030-01-01 00:00:00"}},
+		{name: "listNewLikedYou", alias: "d1", args: []any{"2"}},
+		{name: "listNewLikedYouPage", alias: "d1", args: []any{"2", "2030-01-01 00:00:00"}},
+		{name: "updateDecision", alias: "decisions", args: []any{"1", "2", true}, time: true},
+	}
+
+	statements := repository.Statements()
+
+	for _, layout := range layouts {
+		t.Run(layout.name, func(t *testing.T) {
+			db, contClose := setupPartitionedDB(t, layout.opts)
+			defer contClose()
+
+			for _, q := range queries {
+				if q.time && !layout.opts.TimePartitions {
+					continue
+				}
+				t.Run(q.name, func(t *testing.T) {
+					scanned := scannedHashPartitions(t, db, statements[q.name], q.args...)
+					if got := partitionsFor(scanned, q.alias); len(got) != 1 {
+						t.Errorf("%s scans hash partitions %v for %s, want exactly one", q.name, got, q.alias)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDecisionRepository_TimePartitionedUpsert(t *testing.T) {
+	db, contClose := setupPartitionedDB(t, schema.Options{HashPartitions: 4, TimePartitions: true, MonthsAhead: 1})
+	defer contClose()
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	ctx := context.Background()
+	for i, liked := range []bool{true, false, true} {
+		d := &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: liked}
+		if err := r.PutDecision(ctx, d); err != nil {
+			t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+		}
+		if overwrote := d.UpdatedAt.After(d.CreatedAt); overwrote != (i > 0) {
+			t.Errorf("put %d: UpdatedAt after CreatedAt = %v, want %v", i, overwrote, i > 0)
+		}
+		if want := i == 1; d.PreviouslyLiked != want {
+			t.Errorf("put %d: PreviouslyLiked = %v, want %v", i, d.PreviouslyLiked, want)
+		}
+	}
+
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM decisions WHERE actor_user_id = '1' AND recipient_user_id = '2'`).Scan(&rows); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if rows != 1 {
+		t.Errorf("time-partitioned upsert left %d rows, want 1", rows)
+	}
+
+	count, err := r.CountLikedYou(ctx, "2")
+	if err != nil {
+		t.Fatalf("DecisionRepository.CountLikedYou() error = %v", err)
+	}
+	if count != 1 {
+		t.Errorf("DecisionRepository.CountLikedYou() = %d, want 1", count)
+	}
+}
+
+func TestEnsureTimePartitions_MovesDefaultRows(t *testing.T) {
+	opts := schema.Options{HashPartitions: 2, TimePartitions: true, MonthsAhead: 1}
+	db, contClose := setupPartitionedDB(t, opts)
+	defer contClose()
+
+	// a row for a month without a partition yet lands in DEFAULT
+	later := time.Now().UTC().AddDate(0, 6, 0)
+	if _, err := db.Exec(`INSERT INTO decisions (actor_user_id, recipient_user_id, liked_recipient, created_at, updated_at)
+		VALUES ('1', '2', true, $1, $1)`, later); err != nil {
+		t.Fatalf("failed to insert decision: %v", err)
+	}
+
+	if err := schema.EnsureTimePartitions(context.Background(), db, opts, later); err != nil {
+		t.Fatalf("schema.EnsureTimePartitions() error = %v", err)
+	}
+
+	var partition string
+	if err := db.QueryRow(`SELECT tableoid::regclass::text FROM decisions WHERE actor_user_id = '1'`).Scan(&partition); err != nil {
+		t.Fatalf("failed to find the decision: %v", err)
+	}
+	if want := later.Format("200601"); !strings.HasSuffix(partition, "_"+want) {
+		t.Errorf("decision is in %s, want the %s partition", partition, want)
+	}
+}
+
+func TestConvertToPartitioned(t *testing.T) {
+	for _, layout := range []struct {
+		name string
+		opts schema.Options
+	}{
+		{name: "hash", opts: schema.Options{HashPartitions: 4}},
+		// time sub-partitions drop the unique key ON CONFLICT relies on
+		{name: "hash and time", opts: schema.Options{HashPartitions: 4, TimePartitions: true, MonthsAhead: 1}},
+	} {
+		t.Run(layout.name, func(t *testing.T) {
+			db, contClose := setupTestDB(t)
+			defer contClose()
+
+			r, err := repository.NewDecisionRepository(db)
+			if err != nil {
+				t.Fatalf("failed to init repo error = %v", err)
+			}
+
+			ctx := context.Background()
+			dummies := []models.Decision{
+				{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true},
+				{ActorUserId: "2", RecipientUserId: "1", LikedRecipient: true},
+				{ActorUserId: "3", RecipientUserId: "2", LikedRecipient: false},
+			}
+			for _, v := range dummies {
+				if err := r.PutDecision(ctx, &v); err != nil {
+					t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+				}
+			}
+
+			if err := schema.ConvertToPartitioned(ctx, db, layout.opts, 2); err != nil {
+				t.Fatalf("schema.ConvertToPartitioned() error = %v", err)
+			}
+
+			var partitioned bool
+			if err := db.QueryRow(`SELECT relkind = 'p' FROM pg_class WHERE oid = 'decisions'::regclass`).Scan(&partitioned); err != nil {
+				t.Fatalf("failed to inspect decisions: %v", err)
+			}
+			if !partitioned {
+				t.Fatalf("decisions is not partitioned after conversion")
+			}
+
+			var partitions []string
+			rows, err := db.Query(`SELECT relid::regclass::text FROM pg_partition_tree('decisions') WHERE level = 1 ORDER BY 1`)
+			if err != nil {
+				t.Fatalf("failed to list partitions: %v", err)
+			}
+			for rows.Next() {
+				var name string
+				rows.Scan(&name)
+				partitions = append(partitions, name)
+			}
+			rows.Close()
+			if want := []string{"decisions_p0", "decisions_p1", "decisions_p2", "decisions_p3"}; !slices.Equal(partitions, want) {
+				t.Errorf("partitions after conversion = %v, want %v", partitions, want)
+			}
+
+			// statements prepared before the swap must follow the renamed
+			// table, switching to the locked upsert when the key is gone
+			for _, d := range []models.Decision{
+				{ActorUserId: "4", RecipientUserId: "2", LikedRecipient: true},
+				{ActorUserId: "3", RecipientUserId: "2", LikedRecipient: true},
+			} {
+				if err := r.PutDecision(ctx, &d); err != nil {
+					t.Fatalf("DecisionRepository.PutDecision() after conversion error = %v", err)
+				}
+			}
+
+			var rowsFor3 int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM decisions WHERE actor_user_id = '3'`).Scan(&rowsFor3); err != nil {
+				t.Fatal(err)
+			}
+			if rowsFor3 != 1 {
+				t.Errorf("pair (3, 2) has %d rows after conversion, want 1", rowsFor3)
+			}
+
+			count, err := r.CountLikedYou(ctx, "2")
+			if err != nil {
+				t.Fatalf("DecisionRepository.CountLikedYou() error = %v", err)
+			}
+			if count != 3 {
+				t.Errorf("DecisionRepository.CountLikedYou() = %d, want 3", count)
+			}
+
+			mutual, err := r.IsMutual(ctx, "1", "2")
+			if err != nil {
+				t.Fatalf("DecisionRepository.IsMutual() error = %v", err)
+			}
+			if !mutual {
+				t.Errorf("DecisionRepository.IsMutual() = false after conversion, want true")
+			}
+		})
+	}
+}
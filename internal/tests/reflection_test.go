@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/descriptor"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestReflection(t *testing.T) {
+	grpcServer := grpc.NewServer()
+	pb.RegisterExploreServiceServer(grpcServer, server.NewExploreServer(newMemoryDecisions()))
+	reflection.Register(grpcServer)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	t.Run("v1", func(t *testing.T) {
+		stream, err := reflectionv1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Send(&reflectionv1.ServerReflectionRequest{
+			MessageRequest: &reflectionv1.ServerReflectionRequest_ListServices{},
+		})
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var services []string
+		for _, s := range resp.GetListServicesResponse().GetService() {
+			services = append(services, s.GetName())
+		}
+		if !slices.Contains(services, "explore.ExploreService") {
+			t.Errorf("services = %v, want explore.ExploreService", services)
+		}
+
+		stream.Send(&reflectionv1.ServerReflectionRequest{
+			MessageRequest: &reflectionv1.ServerReflectionRequest_FileContainingSymbol{
+				FileContainingSymbol: "explore.ExploreService",
+			},
+		})
+		resp, err = stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := resp.GetFileDescriptorResponse().GetFileDescriptorProto()
+		if len(files) == 0 {
+			t.Fatalf("response = %v, want file descriptors", resp)
+		}
+		var fd descriptorpb.FileDescriptorProto
+		if err := proto.Unmarshal(files[0], &fd); err != nil || fd.GetName() != "proto/explore.proto" {
+			t.Errorf("file = %q, %v, want proto/explore.proto", fd.GetName(), err)
+		}
+	})
+
+	t.Run("v1alpha", func(t *testing.T) {
+		stream, err := reflectionv1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Send(&reflectionv1alpha.ServerReflectionRequest{
+			MessageRequest: &reflectionv1alpha.ServerReflectionRequest_ListServices{},
+		})
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("v1alpha ListServices error = %v", err)
+		}
+		if len(resp.GetListServicesResponse().GetService()) == 0 {
+			t.Errorf("response = %v, want services", resp)
+		}
+	})
+}
+
+func TestDescriptor(t *testing.T) {
+	set := descriptor.Set()
+
+	// Building a registry checks every import precedes its importer.
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("NewFiles() error = %v", err)
+	}
+	if _, err := files.FindDescriptorByName("explore.ExploreService"); err != nil {
+		t.Errorf("ExploreService missing: %v", err)
+	}
+	if _, err := files.FindFileByPath("google/api/annotations.proto"); err != nil {
+		t.Errorf("imports missing: %v", err)
+	}
+
+	h := descriptor.Handler()
+	tests := []struct {
+		query      string
+		wantStatus int
+		decode     func([]byte, proto.Message) error
+	}{
+		{query: "", wantStatus: 200, decode: proto.Unmarshal},
+		{query: "?format=json", wantStatus: 200, decode: func(b []byte, m proto.Message) error { return protojson.Unmarshal(b, m) }},
+		{query: "?format=yaml", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/descriptor"+tt.query, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET /descriptor%s status = %d, want %d", tt.query, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.decode == nil {
+			continue
+		}
+		var got descriptorpb.FileDescriptorSet
+		if err := tt.decode(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("GET /descriptor%s body error = %v", tt.query, err)
+		} else if !proto.Equal(&got, set) {
+			t.Errorf("GET /descriptor%s differs from Set()", tt.query)
+		}
+	}
+}
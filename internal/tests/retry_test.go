@@ -0,0 +1,364 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc/codes"
+)
+
+// faultConnector opens lib/pq connections that fail queries on demand: each
+// queued fault fails the next query whose text contains its match, once.
+// It also counts statement preparations per query.
+type faultConnector struct {
+	base driver.Connector
+
+	mu       sync.Mutex
+	faults   []fault
+	prepared map[string]int
+}
+
+type fault struct {
+	match string
+	err   error
+}
+
+func newFaultConnector(t *testing.T, dsn string) *faultConnector {
+	base, err := pq.NewConnector(dsn)
+	if err != nil {
+		t.Fatalf("failed to create connector: %v", err)
+	}
+	return &faultConnector{base: base, prepared: map[string]int{}}
+}
+
+// inject queues err for the next n queries containing match.
+func (c *faultConnector) inject(match string, err error, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for range n {
+		c.faults = append(c.faults, fault{match: match, err: err})
+	}
+}
+
+// pending returns the number of queued faults not yet hit.
+func (c *faultConnector) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.faults)
+}
+
+func (c *faultConnector) preparations(match string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for query, count := range c.prepared {
+		if strings.Contains(query, match) {
+			n += count
+		}
+	}
+	return n
+}
+
+func (c *faultConnector) take(query string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, f := range c.faults {
+		if strings.Contains(query, f.match) {
+			c.faults = append(c.faults[:i], c.faults[i+1:]...)
+			return f.err
+		}
+	}
+	return nil
+}
+
+func (c *faultConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: conn, c: c}, nil
+}
+
+func (c *faultConnector) Driver() driver.Driver {
+	return c.base.Driver()
+}
+
+type faultConn struct {
+	driver.Conn
+	c *faultConnector
+}
+
+func (fc *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := fc.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	fc.c.mu.Lock()
+	fc.c.prepared[query]++
+	fc.c.mu.Unlock()
+
+	return &faultStmt{Stmt: stmt, c: fc.c, query: query}, nil
+}
+
+func (fc *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return fc.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (fc *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := fc.c.take(query); err != nil {
+		return nil, err
+	}
+	return fc.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (fc *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := fc.c.take(query); err != nil {
+		return nil, err
+	}
+	return fc.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (fc *faultConn) ResetSession(ctx context.Context) error {
+	return fc.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+type faultStmt struct {
+	driver.Stmt
+	c     *faultConnector
+	query string
+}
+
+func (fs *faultStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := fs.c.take(fs.query); err != nil {
+		return nil, err
+	}
+	return fs.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
+
+func (fs *faultStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := fs.c.take(fs.query); err != nil {
+		return nil, err
+	}
+	return fs.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+// setupFaultyDB starts Postgres with the decisions table and returns a
+// client whose queries fail as injected into the connector.
+func setupFaultyDB(t *testing.T) (*sql.DB, *faultConnector, func()) {
+	dsn, terminate := startTestContainer(context.Background())
+
+	fc := newFaultConnector(t, dsn)
+	db := sql.OpenDB(fc)
+
+	schema := `
+	CREATE TABLE decisions (
+		actor_user_id TEXT NOT NULL,
+		recipient_user_id TEXT NOT NULL,
+		liked_recipient BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW(),
+		PRIMARY KEY (actor_user_id, recipient_user_id)
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	return db, fc, func() {
+		db.Close()
+		terminate()
+	}
+}
+
+var fastRetries = repository.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestDecisionRepository_Retry(t *testing.T) {
+	db, fc, cleanup := setupFaultyDB(t)
+	defer cleanup()
+
+	r, err := repository.NewDecisionRepository(db, repository.WithRetryPolicy(fastRetries))
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	const upsert = "INSERT INTO decisions"
+	const list = "SELECT actor_user_id"
+
+	tests := []struct {
+		name        string
+		match       string
+		err         error
+		faults      int
+		timeout     time.Duration
+		wantErr     bool
+		wantCode    codes.Code
+		wantPending int
+	}{
+		{name: "serialization failure", match: upsert, err: &pq.Error{Code: "40001"}, faults: 2},
+		{name: "deadlock", match: upsert, err: &pq.Error{Code: "40P01"}, faults: 1},
+		{name: "connection failure", match: upsert, err: &pq.Error{Code: "08006"}, faults: 1},
+		{name: "read retried", match: list, err: &pq.Error{Code: "40001"}, faults: 2},
+		{name: "with deadline", match: upsert, err: &pq.Error{Code: "40001"}, faults: 1, timeout: time.Second},
+		{
+			name: "attempts exhausted", match: upsert, err: &pq.Error{Code: "40001"}, faults: 4,
+			wantErr: true, wantCode: codes.Aborted, wantPending: 1,
+		},
+		{
+			name: "permanent error not retried", match: upsert, err: &pq.Error{Code: "22P02"}, faults: 2,
+			wantErr: true, wantCode: codes.Internal, wantPending: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			fc.inject(tt.match, tt.err, tt.faults)
+			defer func() {
+				for fc.take(tt.match) != nil {
+				}
+			}()
+
+			if tt.match == list {
+				_, _, err = r.ListLikedYou(ctx, "2", "")
+			} else {
+				err = r.PutDecision(ctx, &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true})
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				var pqErr *pq.Error
+				if !errors.As(err, &pqErr) || pqErr.Code != tt.err.(*pq.Error).Code {
+					t.Errorf("error = %v, want the injected %v", err, tt.err)
+				}
+				if code, _ := rpcerror.Classify(ctx, err); code != tt.wantCode {
+					t.Errorf("Classify() = %v, want %v", code, tt.wantCode)
+				}
+			}
+			if got := fc.pending(); got != tt.wantPending {
+				t.Errorf("%d faults left, want %d", got, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestDecisionRepository_RetryRespectsDeadline(t *testing.T) {
+	db, fc, cleanup := setupFaultyDB(t)
+	defer cleanup()
+
+	r, err := repository.NewDecisionRepository(db, repository.WithRetryPolicy(repository.RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    10 * time.Second,
+	}))
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	// A backoff longer than the time left ends the call rather than
+	// sleeping past the deadline.
+	fc.inject("INSERT INTO decisions", &pq.Error{Code: "40001"}, 5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = r.PutDecision(ctx, &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true})
+	if err == nil {
+		t.Fatal("PutDecision() succeeded, want the injected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("PutDecision() took %v, want it to give up within the 200ms deadline", elapsed)
+	}
+}
+
+func TestDecisionRepository_ReprepareStaleStatements(t *testing.T) {
+	db, fc, cleanup := setupFaultyDB(t)
+	defer cleanup()
+
+	r, err := repository.NewDecisionRepository(db, repository.WithRetryPolicy(fastRetries))
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+	defer r.Close()
+
+	const upsert = "INSERT INTO decisions"
+	before := fc.preparations(upsert)
+
+	for _, stale := range []error{
+		&pq.Error{Code: "26000", Message: `prepared statement "1" does not exist`},
+		&pq.Error{Code: "0A000", Message: "cached plan must not change result type"},
+	} {
+		fc.inject(upsert, stale, 1)
+		if err := r.PutDecision(context.Background(), &models.Decision{ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true}); err != nil {
+			t.Fatalf("PutDecision() after %v error = %v", stale, err)
+		}
+	}
+
+	if got := fc.preparations(upsert); got < before+2 {
+		t.Errorf("upsert prepared %d times, want at least %d after two stale plans", got, before+2)
+	}
+}
+
+func TestDecisionRepository_ConcurrentReprepare(t *testing.T) {
+	db, fc, cleanup := setupFaultyDB(t)
+	defer cleanup()
+
+	// Enough attempts that a call hitting several faults in a row still
+	// gets through.
+	policy := fastRetries
+	policy.MaxAttempts = 10
+	r, err := repository.NewDecisionRepository(db, repository.WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+	defer r.Close()
+
+	// Each stale plan replaces every statement, closing them under the
+	// calls still using the old ones; those calls retry on the new ones.
+	const upsert = "INSERT INTO decisions"
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for w := range 8 {
+		wg.Go(func() {
+			for i := range 25 {
+				if i%5 == 0 {
+					fc.inject(upsert, &pq.Error{Code: "26000", Message: `prepared statement "1" does not exist`}, 1)
+				}
+				d := &models.Decision{ActorUserId: "1", RecipientUserId: string(rune('a' + w)), LikedRecipient: i%2 == 0}
+				if err := r.PutDecision(context.Background(), d); err != nil {
+					errs <- err
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("PutDecision() during concurrent reprepares error = %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/rpcerror"
+
+	"github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRpcError_Classify(t *testing.T) {
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-deadlineCtx.Done()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		err          error
+		wantCode     codes.Code
+		wantSanitize bool
+	}{
+		{"unique violation", context.Background(), &pq.Error{Code: "23505"}, codes.AlreadyExists, true},
+		{"serialization failure", context.Background(), &pq.Error{Code: "40001"}, codes.Aborted, true},
+		{"deadlock", context.Background(), &pq.Error{Code: "40P01"}, codes.Aborted, true},
+		{"connection failure", context.Background(), &pq.Error{Code: "08006"}, codes.Unavailable, true},
+		{"admin shutdown", context.Background(), &pq.Error{Code: "57P01"}, codes.Unavailable, true},
+		{"statement timeout", deadlineCtx, &pq.Error{Code: "57014"}, codes.DeadlineExceeded, true},
+		{"other postgres error", context.Background(), &pq.Error{Code: "42P01"}, codes.Internal, true},
+		{"wrapped postgres error", context.Background(), fmt.Errorf("put: %w", &pq.Error{Code: "40001"}), codes.Aborted, true},
+		{"repository error", context.Background(), &repository.QueryError{Op: "failed to put decision", Err: &pq.Error{Code: "23505"}}, codes.AlreadyExists, true},
+		{"bad connection", context.Background(), driver.ErrBadConn, codes.Unavailable, true},
+		{"internal status", context.Background(), status.Error(codes.Internal, "pq: relation missing"), codes.Internal, true},
+		{"plain error", context.Background(), errors.New("boom"), codes.Internal, true},
+		{"invalid argument", context.Background(), status.Error(codes.InvalidArgument, "bad id"), codes.InvalidArgument, false},
+		{"permission denied", context.Background(), status.Error(codes.PermissionDenied, "no"), codes.PermissionDenied, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, sanitize := rpcerror.Classify(tt.ctx, tt.err)
+			if code != tt.wantCode || sanitize != tt.wantSanitize {
+				t.Errorf("Classify() = (%v, %v), want (%v, %v)", code, sanitize, tt.wantCode, tt.wantSanitize)
+			}
+		})
+	}
+}
+
+func TestRpcError_Interceptors(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{})
+	if err != nil {
+		t.Fatalf("logging.New() error = %v", err)
+	}
+
+	chain := []grpc.UnaryServerInterceptor{
+		logging.UnaryServerInterceptor(logger, 0),
+		rpcerror.SanitizeInterceptor(),
+		rpcerror.RecoverInterceptor(),
+	}
+	call := func(handler grpc.UnaryHandler) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(logging.RequestIDHeader, "corr-1"))
+		info := &grpc.UnaryServerInfo{FullMethod: "/explore.ExploreService/CountLikedYou"}
+		for i := len(chain) - 1; i >= 0; i-- {
+			next, interceptor := handler, chain[i]
+			handler = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		_, err := handler(ctx, nil)
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		handler  grpc.UnaryHandler
+		wantCode codes.Code
+		wantMsg  string
+		leaked   string
+	}{
+		{
+			name:     "panic",
+			handler:  func(context.Context, any) (any, error) { panic("nil map write") },
+			wantCode: codes.Internal,
+			wantMsg:  "internal error (correlation id: corr-1)",
+			leaked:   "nil map write",
+		},
+		{
+			name: "postgres error",
+			handler: func(context.Context, any) (any, error) {
+				return nil, fmt.Errorf("count: %w", &pq.Error{Code: "40001", Message: "could not serialize access"})
+			},
+			wantCode: codes.Aborted,
+			wantMsg:  "conflicting concurrent update, retry the call (correlation id: corr-1)",
+			leaked:   "could not serialize",
+		},
+		{
+			name: "client error",
+			handler: func(context.Context, any) (any, error) {
+				return nil, status.Error(codes.InvalidArgument, "recipient_user_id required")
+			},
+			wantCode: codes.InvalidArgument,
+			wantMsg:  "recipient_user_id required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			err := call(tt.handler)
+			st := status.Convert(err)
+			if st.Code() != tt.wantCode || st.Message() != tt.wantMsg {
+				t.Errorf("error = %v: %q, want %v: %q", st.Code(), st.Message(), tt.wantCode, tt.wantMsg)
+			}
+			if tt.leaked != "" && !strings.Contains(buf.String(), tt.leaked) {
+				t.Errorf("server log does not contain the internal detail %q", tt.leaked)
+			}
+		})
+	}
+}
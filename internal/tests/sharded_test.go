@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+)
+
+func TestShardMap_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		shardMap repository.ShardMap
+		shards   int
+		wantErr  bool
+	}{
+		{
+			name:     "even",
+			shardMap: repository.EvenShardMap(1024, 3),
+			shards:   3,
+			wantErr:  false,
+		},
+		{
+			name: "gap",
+			shardMap: repository.ShardMap{Buckets: 4, Ranges: []repository.ShardRange{
+				{From: 0, To: 1, Shard: 0},
+				{From: 3, To: 3, Shard: 1},
+			}},
+			shards:  2,
+			wantErr: true,
+		},
+		{
+			name: "overlap",
+			shardMap: repository.ShardMap{Buckets: 4, Ranges: []repository.ShardRange{
+				{From: 0, To: 2, Shard: 0},
+				{From: 2, To: 3, Shard: 1},
+			}},
+			shards:  2,
+			wantErr: true,
+		},
+		{
+			name: "unknown shard",
+			shardMap: repository.ShardMap{Buckets: 2, Ranges: []repository.ShardRange{
+				{From: 0, To: 1, Shard: 2},
+			}},
+			shards:  2,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.shardMap.Validate(tt.shards); (err != nil) != tt.wantErr {
+				t.Errorf("ShardMap.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestShardMap_ShardFor(t *testing.T) {
+	m := repository.EvenShardMap(1024, 4)
+
+	counts := make([]int, 4)
+	for i := range 10_000 {
+		id := strconv.Itoa(i)
+		shard := m.ShardFor(id)
+		if again := m.ShardFor(id); again != shard {
+			t.Fatalf("ShardMap.ShardFor(%q) = %d then %d", id, shard, again)
+		}
+		counts[shard]++
+	}
+
+	for shard, n := range counts {
+		if n < 2000 || n > 3000 {
+			t.Errorf("ShardMap.ShardFor() placed %d of 10000 ids on shard %d", n, shard)
+		}
+	}
+}
+
+// idsOnShards returns numeric user IDs owned by shard 0 and shard 1.
+func idsOnShards(m repository.ShardMap) (string, string) {
+	var first, second string
+	for i := 1; first == "" || second == ""; i++ {
+		id := strconv.Itoa(i)
+		switch m.ShardFor(id) {
+		case 0:
+			if first == "" {
+				first = id
+			}
+		case 1:
+			if second == "" {
+				second = id
+			}
+		}
+	}
+	return first, second
+}
+
+func TestShardedDecisionRepository(t *testing.T) {
+	dbs, contClose := setupTestShards(t, 2)
+	defer contClose()
+
+	var shards []*repository.DecisionRepository
+	for _, db := range dbs {
+		r, err := repository.NewDecisionRepository(db)
+		if err != nil {
+			t.Fatalf("failed to init shard repo error = %v", err)
+		}
+		shards = append(shards, r)
+	}
+
+	shardMap := repository.EvenShardMap(1024, 2)
+	r, err := repository.NewShardedDecisionRepository(shards, shardMap)
+	if err != nil {
+		t.Fatalf("failed to init sharded repo error = %v", err)
+	}
+
+	ctx := context.Background()
+	a, b := idsOnShards(shardMap)
+	c := "999999"
+
+	dummies := []models.Decision{
+		{ActorUserId: a, RecipientUserId: b, LikedRecipient: true}, // stored on b's shard
+		{ActorUserId: b, RecipientUserId: a, LikedRecipient: true}, // stored on a's shard
+		{ActorUserId: c, RecipientUserId: b, LikedRecipient: true},
+	}
+	for _, v := range dummies {
+		if err := r.PutDecision(ctx, &v); err != nil {
+			t.Fatalf("ShardedDecisionRepository.PutDecision() error = %v", err)
+		}
+	}
+
+	// each row must live only on its recipient's shard
+	var onShard int
+	if err := dbs[0].QueryRow(`SELECT COUNT(*) FROM decisions WHERE recipient_user_id = $1`, b).Scan(&onShard); err != nil {
+		t.Fatalf("failed to count shard rows: %v", err)
+	}
+	if onShard != 0 {
+		t.Errorf("shard 0 holds %d rows for recipient %s owned by shard 1", onShard, b)
+	}
+
+	mutual, err := r.IsMutual(ctx, a, b)
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.IsMutual() error = %v", err)
+	}
+	if !mutual {
+		t.Errorf("ShardedDecisionRepository.IsMutual() = false across shards, want true")
+	}
+
+	count, err := r.CountLikedYou(ctx, b)
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.CountLikedYou() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("ShardedDecisionRepository.CountLikedYou() = %d, want 2", count)
+	}
+
+	// a and b are mutual, so only c is new for b
+	got, _, err := r.ListNewLikedYou(ctx, b, "")
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.ListNewLikedYou() error = %v", err)
+	}
+	var actors []string
+	for _, d := range got {
+		actors = append(actors, d.ActorUserId)
+	}
+	sort.Strings(actors)
+	if len(actors) != 1 || actors[0] != c {
+		t.Errorf("ShardedDecisionRepository.ListNewLikedYou() = %v, want [%s]", actors, c)
+	}
+
+	// b has never decided about c, so the cache answers for c without
+	// asking c's shard and only a is looked up
+	decided := repository.NewDecidedCache(r, repository.DecidedCacheConfig{})
+	r.UseDecidedCache(decided)
+	got, _, err = r.ListNewLikedYou(ctx, b, "")
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.ListNewLikedYou() with decided cache error = %v", err)
+	}
+	if len(got) != 1 || got[0].ActorUserId != c {
+		t.Errorf("ShardedDecisionRepository.ListNewLikedYou() with decided cache = %v, want [%s]", got, c)
+	}
+	if stats := decided.Stats(); stats.Lookups != 2 || stats.Undecided != 1 {
+		t.Errorf("DecidedCache.Stats() lookups = %d undecided = %d, want 2 and 1", stats.Lookups, stats.Undecided)
+	}
+}
+
+func TestShardedDecisionRepository_ListNewLikedYouBounded(t *testing.T) {
+	dbs, contClose := setupTestShards(t, 2)
+	defer contClose()
+
+	var shards []*repository.DecisionRepository
+	for _, db := range dbs {
+		r, err := repository.NewDecisionRepository(db, repository.WithPageSize(2))
+		if err != nil {
+			t.Fatalf("failed to init shard repo error = %v", err)
+		}
+		shards = append(shards, r)
+	}
+	r, err := repository.NewShardedDecisionRepository(shards, repository.EvenShardMap(1024, 2))
+	if err != nil {
+		t.Fatalf("failed to init sharded repo error = %v", err)
+	}
+	ctx := context.Background()
+
+	// twelve likers, the ten most recent liked back
+	const recipient = "1000"
+	for i := 1; i <= 12; i++ {
+		liker := strconv.Itoa(i)
+		if err := r.PutDecision(ctx, &models.Decision{ActorUserId: liker, RecipientUserId: recipient, LikedRecipient: true}); err != nil {
+			t.Fatalf("ShardedDecisionRepository.PutDecision() error = %v", err)
+		}
+		if i > 2 {
+			if err := r.PutDecision(ctx, &models.Decision{ActorUserId: recipient, RecipientUserId: liker, LikedRecipient: true}); err != nil {
+				t.Fatalf("ShardedDecisionRepository.PutDecision() error = %v", err)
+			}
+		}
+	}
+
+	// the first call gives up after four pages of liked-back likers
+	got, token, err := r.ListNewLikedYou(ctx, recipient, "")
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.ListNewLikedYou() error = %v", err)
+	}
+	if len(got) != 0 || token == "" {
+		t.Fatalf("ShardedDecisionRepository.ListNewLikedYou() = %d decisions, token %q, want an empty page to continue from", len(got), token)
+	}
+
+	got, token, err = r.ListNewLikedYou(ctx, recipient, token)
+	if err != nil {
+		t.Fatalf("ShardedDecisionRepository.ListNewLikedYou() error = %v", err)
+	}
+	var actors []string
+	for _, d := range got {
+		actors = append(actors, d.ActorUserId)
+	}
+	if want := []string{"2", "1"}; !slices.Equal(actors, want) || token != "" {
+		t.Errorf("ShardedDecisionRepository.ListNewLikedYou() = %v, token %q, want %v and no token", actors, token, want)
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/auth"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/internal/tlsutil"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues throwaway certificates for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "explore test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for the given serial and SANs.
+func (ca *testCA) issue(t *testing.T, serial int64, dnsNames []string, uris []string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "explore test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	for _, raw := range uris {
+		u, _ := url.Parse(raw)
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestTLSServer_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	serverCert, serverKey := ca.issue(t, 2, []string{"localhost"}, nil)
+	writeFile(t, certFile, serverCert)
+	writeFile(t, keyFile, serverKey)
+	writeFile(t, caFile, ca.pem)
+
+	tlsServer, err := tlsutil.NewServer(tlsutil.ServerConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientCAFile:   caFile,
+		ClientAuth:     tlsutil.ClientAuthRequire,
+		AllowedSANs:    map[string]string{"spiffe://explore/matcher": "matcher"},
+		ReloadInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("tlsutil.NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tlsServer.Watch(ctx)
+
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: []byte("unused")})
+	if err != nil {
+		t.Fatalf("auth.NewVerifier() error = %v", err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsServer.Config())),
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(verifier, auth.PeerIdentities(tlsServer.Identity))),
+	)
+	pb.RegisterExploreServiceServer(grpcServer, server.NewExploreServer(newMemoryDecisions()))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// dial returns the serial of the server certificate seen and the call error
+	dial := func(clientCert, clientKey []byte) (int64, error) {
+		var serial int64
+		cfg := &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+			VerifyConnection: func(state tls.ConnectionState) error {
+				serial = state.PeerCertificates[0].SerialNumber.Int64()
+				return nil
+			},
+		}
+		if clientCert != nil {
+			pair, err := tls.X509KeyPair(clientCert, clientKey)
+			if err != nil {
+				t.Fatalf("failed to load client certificate: %v", err)
+			}
+			cfg.Certificates = []tls.Certificate{pair}
+		}
+
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+		if err != nil {
+			t.Fatalf("grpc.NewClient() error = %v", err)
+		}
+		defer conn.Close()
+
+		callCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		// acting for user 1 needs the service scope granted to mapped identities
+		_, err = pb.NewExploreServiceClient(conn).PutDecision(callCtx, &pb.PutDecisionRequest{
+			ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true,
+		})
+		return serial, err
+	}
+
+	matcherCert, matcherKey := ca.issue(t, 10, nil, []string{"spiffe://explore/matcher"})
+	rogueCert, rogueKey := ca.issue(t, 11, nil, []string{"spiffe://explore/rogue"})
+
+	if _, err := dial(matcherCert, matcherKey); err != nil {
+		t.Errorf("mTLS call with allowed SAN failed: %v", err)
+	}
+	if _, err := dial(rogueCert, rogueKey); err == nil {
+		t.Errorf("mTLS call with unlisted SAN succeeded, want failure")
+	}
+	if _, err := dial(nil, nil); err == nil {
+		t.Errorf("call without client certificate succeeded, want failure")
+	}
+
+	// rotate the server certificate and wait for new handshakes to use it
+	rotatedCert, rotatedKey := ca.issue(t, 3, []string{"localhost"}, nil)
+	writeFile(t, certFile, rotatedCert)
+	writeFile(t, keyFile, rotatedKey)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serial, err := dial(matcherCert, matcherKey)
+		if err == nil && serial == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server still presents certificate %d after rotation (err = %v)", serial, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
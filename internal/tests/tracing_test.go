@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/repository"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/internal/tracing"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// recordSpans installs a tracer provider that keeps ended spans in memory
+// for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	if _, err := tracing.Setup(context.Background(), tracing.Config{}); err != nil {
+		t.Fatalf("tracing.Setup() error = %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		provider.Shutdown(context.Background())
+	})
+
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing_IncomingTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	pb.RegisterExploreServiceServer(grpcServer, server.NewExploreServer(newMemoryDecisions()))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	defer conn.Close()
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-"+traceID+"-"+spanID+"-01")
+	if _, err := pb.NewExploreServiceClient(conn).CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "1"}); err != nil {
+		t.Fatalf("CountLikedYou() error = %v", err)
+	}
+	grpcServer.GracefulStop()
+
+	var serverSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() == trace.SpanKindServer {
+			serverSpan = span
+		}
+	}
+	if serverSpan == nil {
+		t.Fatalf("no server span recorded")
+	}
+	if got := serverSpan.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("server span trace ID = %s, want %s", got, traceID)
+	}
+	if got := serverSpan.Parent().SpanID().String(); got != spanID {
+		t.Errorf("server span parent = %s, want %s", got, spanID)
+	}
+	if !strings.Contains(serverSpan.Name(), "CountLikedYou") {
+		t.Errorf("server span name = %q, want the RPC method", serverSpan.Name())
+	}
+}
+
+func TestTracing_FileExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterFile,
+		File:        path,
+		ServiceName: "explore-test",
+	})
+	if err != nil {
+		t.Fatalf("tracing.Setup() error = %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "file-exported")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read spans: %v", err)
+	}
+	for _, want := range []string{`"Name":"file-exported"`, `"explore-test"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("exported spans missing %s", want)
+		}
+	}
+}
+
+func TestDecisionRepository_QuerySpans(t *testing.T) {
+	db, contClose := setupTestDB(t)
+	defer db.Close()
+	defer contClose()
+
+	recorder := recordSpans(t)
+
+	r, err := repository.NewDecisionRepository(db)
+	if err != nil {
+		t.Fatalf("failed to init repo error = %v", err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "rpc")
+	for _, actor := range []string{"1", "2", "3"} {
+		if err := r.PutDecision(ctx, &models.Decision{ActorUserId: actor, RecipientUserId: "9", LikedRecipient: true}); err != nil {
+			t.Fatalf("DecisionRepository.PutDecision() error = %v", err)
+		}
+	}
+	if _, _, err := r.ListLikedYou(ctx, "9", ""); err != nil {
+		t.Fatalf("DecisionRepository.ListLikedYou() error = %v", err)
+	}
+	parent.End()
+
+	wantRows := map[string]int64{"decisions.putDecision": 1, "decisions.listLikedYou": 3}
+	seen := map[string]int{}
+	for _, span := range recorder.Ended() {
+		want, ok := wantRows[span.Name()]
+		if !ok {
+			continue
+		}
+		seen[span.Name()]++
+
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s parent = %s, want the caller's span", span.Name(), span.Parent().SpanID())
+		}
+		if rows, _ := spanAttr(span, "db.rows"); rows.AsInt64() != want {
+			t.Errorf("%s db.rows = %d, want %d", span.Name(), rows.AsInt64(), want)
+		}
+		if name, _ := spanAttr(span, "db.statement.name"); !strings.HasSuffix(span.Name(), name.AsString()) {
+			t.Errorf("%s db.statement.name = %q", span.Name(), name.AsString())
+		}
+	}
+	if seen["decisions.putDecision"] != 3 || seen["decisions.listLikedYou"] != 1 {
+		t.Errorf("recorded query spans = %v, want 3 putDecision and 1 listLikedYou", seen)
+	}
+}
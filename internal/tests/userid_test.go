@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+)
+
+func TestUserIDValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    server.UserIDFormat
+		pattern   string
+		maxLength int
+		id        string
+		want      string
+		wantErr   bool
+	}{
+		{name: "numeric", format: server.UserIDNumeric, id: "12345", want: "12345"},
+		{name: "numeric rejects letters", format: server.UserIDNumeric, id: "12a", wantErr: true},
+		{name: "numeric rejects non-ASCII digits", format: server.UserIDNumeric, id: "١٢", wantErr: true},
+		{name: "numeric normalises fullwidth digits", format: server.UserIDNumeric, id: "１２", want: "12"},
+		{name: "numeric too long", format: server.UserIDNumeric, maxLength: 4, id: "12345", wantErr: true},
+		{name: "uuid lower-cased", format: server.UserIDUUID, id: "3F2504E0-4F89-11D3-9A0C-0305E82C3301", want: "3f2504e0-4f89-11d3-9a0c-0305e82c3301"},
+		{name: "uuid without hyphens", format: server.UserIDUUID, id: "3f2504e04f8911d39a0c0305e82c3301", wantErr: true},
+		{name: "ulid upper-cased", format: server.UserIDULID, id: "01arz3ndektsv4rrffq69g5fav", want: "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+		{name: "ulid overflow", format: server.UserIDULID, id: "81ARZ3NDEKTSV4RRFFQ69G5FAV", wantErr: true},
+		{name: "ulid excluded letter", format: server.UserIDULID, id: "01ARZ3NDEKTSV4RRFFQ69G5FAU", wantErr: true},
+		{name: "regex", format: server.UserIDRegex, pattern: `u_[a-z0-9]+`, id: "u_42", want: "u_42"},
+		{name: "regex is anchored", format: server.UserIDRegex, pattern: `u_[a-z0-9]+`, id: "xu_42", wantErr: true},
+		{name: "invalid UTF-8", format: server.UserIDRegex, pattern: `.+`, id: "\xff", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := server.NewUserIDValidator(tt.format, tt.pattern, tt.maxLength)
+			if err != nil {
+				t.Fatalf("NewUserIDValidator() error = %v", err)
+			}
+
+			got, err := v.Validate(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Validate(%q) = %q, want %q", tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewUserIDValidator_Config(t *testing.T) {
+	for _, tt := range []struct {
+		format  server.UserIDFormat
+		pattern string
+	}{
+		{format: "email"},
+		{format: server.UserIDRegex},
+		{format: server.UserIDRegex, pattern: "("},
+	} {
+		if _, err := server.NewUserIDValidator(tt.format, tt.pattern, 0); err == nil {
+			t.Errorf("NewUserIDValidator(%q, %q) error = nil, want an error", tt.format, tt.pattern)
+		}
+	}
+}
+
+func TestExploreServer_UserIDsValidatedUniformly(t *testing.T) {
+	repo := newMemoryDecisions()
+	uuids, err := server.NewUserIDValidator(server.UserIDUUID, "", 0)
+	if err != nil {
+		t.Fatalf("NewUserIDValidator() error = %v", err)
+	}
+	svc := server.NewExploreServer(repo, server.WithUserIDValidator(uuids))
+	ctx := context.Background()
+
+	const (
+		actor     = "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+		recipient = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	)
+
+	if _, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: strings.ToUpper(actor), RecipientUserId: recipient, LikedRecipient: true}); err != nil {
+		t.Fatalf("PutDecision() error = %v", err)
+	}
+
+	// the upper-case actor was stored in canonical form
+	resp, err := svc.ListLikedYou(ctx, &pb.ListLikedYouRequest{RecipientUserId: strings.ToUpper(recipient)})
+	if err != nil {
+		t.Fatalf("ListLikedYou() error = %v", err)
+	}
+	if len(resp.Likers) != 1 || resp.Likers[0].ActorId != actor {
+		t.Errorf("ListLikedYou() likers = %v, want the canonical actor ID", resp.Likers)
+	}
+
+	// reads used to accept any non-empty string
+	if _, err := svc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: "junk"}); apierror.Reason(err) != apierror.ReasonInvalidUserID {
+		t.Errorf("CountLikedYou(junk) reason = %q, want %q", apierror.Reason(err), apierror.ReasonInvalidUserID)
+	}
+	if _, err := svc.PutDecision(ctx, &pb.PutDecisionRequest{ActorUserId: actor, RecipientUserId: strings.ToUpper(actor)}); apierror.Reason(err) != apierror.ReasonSelfDecision {
+		t.Errorf("PutDecision(self) reason = %q, want %q", apierror.Reason(err), apierror.ReasonSelfDecision)
+	}
+}
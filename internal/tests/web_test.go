@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/logging"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/internal/web"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newTestWebServer serves the gRPC port as main does with gRPC-Web on:
+// over HTTP/1.1 and HTTP/2 without TLS.
+func newTestWebServer(t *testing.T, cors web.CORS, interceptors ...grpc.UnaryServerInterceptor) *httptest.Server {
+	t.Helper()
+
+	svc := server.NewExploreServer(newMemoryDecisions())
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	pb.RegisterExploreServiceServer(grpcServer, svc)
+
+	handler, err := web.NewHandler(grpcServer, svc, cors, interceptors...)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(handler)
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestWeb_Protocols(t *testing.T) {
+	ts := newTestWebServer(t, web.CORS{})
+	ctx := context.Background()
+
+	conn, err := grpc.NewClient(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = pb.NewExploreServiceClient(conn).PutDecision(ctx, &pb.PutDecisionRequest{
+		ActorUserId: "1", RecipientUserId: "2", LikedRecipient: true,
+	})
+	if err != nil {
+		t.Fatalf("native gRPC PutDecision() error = %v", err)
+	}
+
+	url := ts.URL + pb.ExploreService_CountLikedYou_FullMethodName
+	clients := map[string]*connect.Client[pb.CountLikedYouRequest, pb.CountLikedYouResponse]{
+		"connect":      connect.NewClient[pb.CountLikedYouRequest, pb.CountLikedYouResponse](ts.Client(), url),
+		"connect json": connect.NewClient[pb.CountLikedYouRequest, pb.CountLikedYouResponse](ts.Client(), url, connect.WithProtoJSON()),
+		"grpc-web":     connect.NewClient[pb.CountLikedYouRequest, pb.CountLikedYouResponse](ts.Client(), url, connect.WithGRPCWeb()),
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			resp, err := client.CallUnary(ctx, connect.NewRequest(&pb.CountLikedYouRequest{RecipientUserId: "2"}))
+			if err != nil {
+				t.Fatalf("CountLikedYou() error = %v", err)
+			}
+			if resp.Msg.GetCount() != 1 {
+				t.Errorf("count = %d, want 1", resp.Msg.GetCount())
+			}
+		})
+	}
+
+	// A browser needs nothing but fetch for Connect with JSON.
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"recipient_user_id":"2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != `{"count":"1"}` {
+		t.Errorf("POST = %d %s, want 200 {\"count\":\"1\"}", resp.StatusCode, body)
+	}
+}
+
+func TestWeb_ErrorsAndInterceptors(t *testing.T) {
+	var methods []string
+	record := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		methods = append(methods, info.FullMethod)
+		return handler(ctx, req)
+	}
+	shed := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if info.FullMethod == pb.ExploreService_ListNewLikedYou_FullMethodName {
+			return nil, apierror.New(codes.ResourceExhausted, apierror.ReasonResourceExhausted, "busy",
+				apierror.WithRetryDelay(time.Second))
+		}
+		return handler(ctx, req)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts := newTestWebServer(t, web.CORS{}, logging.UnaryServerInterceptor(logger, 1), record, shed)
+	ctx := context.Background()
+
+	put := connect.NewClient[pb.PutDecisionRequest, pb.PutDecisionResponse](
+		ts.Client(), ts.URL+pb.ExploreService_PutDecision_FullMethodName, connect.WithGRPCWeb())
+	req := connect.NewRequest(&pb.PutDecisionRequest{ActorUserId: "1", RecipientUserId: "1", LikedRecipient: true})
+	req.Header().Set("X-Request-Id", "req-7")
+	_, err := put.CallUnary(ctx, req)
+
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) || cerr.Code() != connect.CodeInvalidArgument {
+		t.Fatalf("self decision error = %v, want InvalidArgument", err)
+	}
+	if got := cerr.Meta().Get("X-Request-Id"); got != "req-7" {
+		t.Errorf("X-Request-Id = %q, want the request's echoed back", got)
+	}
+	if !hasDetail[*errdetails.ErrorInfo](cerr) {
+		t.Errorf("details = %v, want an ErrorInfo", cerr.Details())
+	}
+
+	list := connect.NewClient[pb.ListLikedYouRequest, pb.ListLikedYouResponse](
+		ts.Client(), ts.URL+pb.ExploreService_ListNewLikedYou_FullMethodName)
+	_, err = list.CallUnary(ctx, connect.NewRequest(&pb.ListLikedYouRequest{RecipientUserId: "2"}))
+	if connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Fatalf("shed call error = %v, want ResourceExhausted", err)
+	}
+	if !errors.As(err, &cerr) || !hasDetail[*errdetails.RetryInfo](cerr) {
+		t.Errorf("details = %v, want a RetryInfo", cerr.Details())
+	}
+
+	want := []string{pb.ExploreService_PutDecision_FullMethodName, pb.ExploreService_ListNewLikedYou_FullMethodName}
+	if strings.Join(methods, ",") != strings.Join(want, ",") {
+		t.Errorf("interceptors saw %v, want %v", methods, want)
+	}
+}
+
+func hasDetail[T any](err *connect.Error) bool {
+	for _, d := range err.Details() {
+		if v, _ := d.Value(); v != nil {
+			if _, ok := v.(T); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestWeb_CORS(t *testing.T) {
+	ts := newTestWebServer(t, web.CORS{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: time.Hour})
+	url := ts.URL + pb.ExploreService_CountLikedYou_FullMethodName
+
+	preflight := func(origin string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, url, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight("https://app.example.com")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("preflight status = %d, want 204", resp.StatusCode)
+	}
+	h := resp.Header
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		!strings.Contains(h.Get("Access-Control-Allow-Headers"), "X-Grpc-Web") ||
+		h.Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("preflight headers = %v", h)
+	}
+
+	if got := preflight("https://evil.example.com").Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("other origin allowed as %q", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"recipient_user_id":"2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://app.example.com")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "Grpc-Status") {
+		t.Errorf("response = %d %v, want 200 with exposed headers", resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,244 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientAuth selects how client certificates are treated.
+type ClientAuth string
+
+const (
+	// ClientAuthNone never asks for a client certificate.
+	ClientAuthNone ClientAuth = "none"
+	// ClientAuthRequest verifies a client certificate when one is sent.
+	ClientAuthRequest ClientAuth = "request"
+	// ClientAuthRequire rejects clients without a valid certificate (mTLS).
+	ClientAuthRequire ClientAuth = "require"
+)
+
+// ServerConfig describes the listener's certificates.
+type ServerConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the CAs client certificates must chain to.
+	ClientCAFile string
+	ClientAuth   ClientAuth
+	// AllowedSANs maps client certificate SANs (DNS names, URIs such as
+	// SPIFFE IDs, or email addresses) to service identities. When non-empty,
+	// client certificates without a listed SAN are rejected.
+	AllowedSANs map[string]string
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration
+}
+
+// Server serves a tls.Config whose certificate and client CAs are reloaded
+// whenever the files on disk change.
+type Server struct {
+	cfg ServerConfig
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func NewServer(cfg ServerConfig) (*Server, error) {
+	if cfg.ClientAuth == "" {
+		cfg.ClientAuth = ClientAuthNone
+	}
+	if cfg.ClientAuth != ClientAuthNone && cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("client auth %q needs a client CA file", cfg.ClientAuth)
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = time.Minute
+	}
+
+	s := &Server{cfg: cfg}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Config returns the tls.Config to serve gRPC with. Each handshake picks
+// up the most recently loaded certificate and client CAs.
+func (s *Server) Config() *tls.Config {
+	return s.config("h2")
+}
+
+// HTTPConfig is Config for an HTTP server, which also speaks HTTP/1.1.
+func (s *Server) HTTPConfig() *tls.Config {
+	return s.config("h2", "http/1.1")
+}
+
+func (s *Server) config(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				Certificates:     []tls.Certificate{*s.cert},
+				ClientCAs:        s.clientCA,
+				NextProtos:       nextProtos,
+				VerifyConnection: s.verifyConnection,
+			}
+			switch s.cfg.ClientAuth {
+			case ClientAuthRequest:
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			case ClientAuthRequire:
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Watch reloads the certificate files whenever they change, until ctx is
+// cancelled. A failed reload keeps the previous certificates.
+func (s *Server) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.load(); err != nil {
+				slog.Error("failed to reload certificates", "component", "tls", "error", err)
+				continue
+			}
+			slog.Info("reloaded certificates", "component", "tls")
+		}
+	}
+}
+
+// Identity maps the verified client certificate on ctx's connection to its
+// service identity.
+func (s *Server) Identity(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return "", false
+	}
+
+	return s.identity(info.State.PeerCertificates[0])
+}
+
+func (s *Server) identity(cert *x509.Certificate) (string, bool) {
+	for _, san := range sans(cert) {
+		if id, ok := s.cfg.AllowedSANs[san]; ok {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 || len(s.cfg.AllowedSANs) == 0 {
+		return nil
+	}
+
+	if _, ok := s.identity(state.PeerCertificates[0]); !ok {
+		return fmt.Errorf("client certificate SANs %v are not allowed", sans(state.PeerCertificates[0]))
+	}
+	return nil
+}
+
+func (s *Server) load() error {
+	cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if s.cfg.ClientCAFile != "" {
+		if pool, err = LoadCertPool(s.cfg.ClientCAFile); err != nil {
+			return err
+		}
+	}
+
+	modTimes := make(map[string]time.Time)
+	for _, path := range s.files() {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cert = &cert
+	s.clientCA = pool
+	s.modTimes = modTimes
+
+	return nil
+}
+
+func (s *Server) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, path := range s.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) files() []string {
+	files := []string{s.cfg.CertFile, s.cfg.KeyFile}
+	if s.cfg.ClientCAFile != "" {
+		files = append(files, s.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// LoadCertPool reads PEM encoded CA certificates from path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+func sans(cert *x509.Certificate) []string {
+	out := append([]string{}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		out = append(out, u.String())
+	}
+	out = append(out, cert.EmailAddresses...)
+	return out
+}
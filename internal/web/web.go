@@ -0,0 +1,284 @@
+// Package web serves the ExploreService to browsers, over gRPC-Web and the
+// Connect protocol, on the same port as native gRPC. Native gRPC requests
+// are passed to the grpc.Server untouched; the others are decoded by
+// connect-go and run through the same interceptors as gRPC.
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/inprocess"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"connectrpc.com/connect"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// CORS sets which browser origins may call the service.
+type CORS struct {
+	// AllowedOrigins are the origins, e.g. https://app.example.com, sent
+	// CORS headers. "*" allows any origin; none disables CORS.
+	AllowedOrigins []string
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// allowedHeaders are the request headers browsers may send: those of the
+// gRPC-Web and Connect protocols and those the service reads.
+var allowedHeaders = strings.Join([]string{
+	"Content-Type",
+	"Connect-Protocol-Version",
+	"Connect-Timeout-Ms",
+	"Connect-Accept-Encoding",
+	"Connect-Content-Encoding",
+	"Grpc-Timeout",
+	"Grpc-Accept-Encoding",
+	"X-Grpc-Web",
+	"X-User-Agent",
+	"Authorization",
+	"X-Request-Id",
+	"X-Read-Your-Writes",
+}, ", ")
+
+// exposedHeaders are the response headers browser code may read.
+var exposedHeaders = strings.Join([]string{
+	"Grpc-Status",
+	"Grpc-Message",
+	"Grpc-Status-Details-Bin",
+	"Grpc-Encoding",
+	"Connect-Content-Encoding",
+	"X-Request-Id",
+}, ", ")
+
+// NewHandler returns a handler for the gRPC port. HTTP/2 requests with a
+// gRPC content type go to native; gRPC-Web and Connect requests for srv
+// run through interceptors, outermost first, as over gRPC. Errors keep
+// their code and details in either protocol.
+//
+// Every RPC of the service must have a route here; NewHandler fails if one
+// is missing, e.g. a streaming RPC added to explore.proto without one.
+func NewHandler(native http.Handler, srv pb.ExploreServiceServer, cors CORS,
+	interceptors ...grpc.UnaryServerInterceptor) (http.Handler, error) {
+	in := inprocess.New(srv, interceptors...)
+
+	routes := map[string]http.Handler{
+		pb.ExploreService_ListLikedYou_FullMethodName: connect.NewUnaryHandler(
+			pb.ExploreService_ListLikedYou_FullMethodName,
+			unary(pb.ExploreService_ListLikedYou_FullMethodName, in.ListLikedYou)),
+		pb.ExploreService_ListNewLikedYou_FullMethodName: connect.NewUnaryHandler(
+			pb.ExploreService_ListNewLikedYou_FullMethodName,
+			unary(pb.ExploreService_ListNewLikedYou_FullMethodName, in.ListNewLikedYou)),
+		pb.ExploreService_CountLikedYou_FullMethodName: connect.NewUnaryHandler(
+			pb.ExploreService_CountLikedYou_FullMethodName,
+			unary(pb.ExploreService_CountLikedYou_FullMethodName, in.CountLikedYou)),
+		pb.ExploreService_PutDecision_FullMethodName: connect.NewUnaryHandler(
+			pb.ExploreService_PutDecision_FullMethodName,
+			unary(pb.ExploreService_PutDecision_FullMethodName, in.PutDecision)),
+	}
+
+	service := "/" + pb.ExploreService_ServiceDesc.ServiceName + "/"
+	for _, m := range pb.ExploreService_ServiceDesc.Methods {
+		if routes[service+m.MethodName] == nil {
+			return nil, fmt.Errorf("web: no route for %s%s", service, m.MethodName)
+		}
+	}
+	for _, s := range pb.ExploreService_ServiceDesc.Streams {
+		if routes[service+s.StreamName] == nil {
+			return nil, fmt.Errorf("web: no route for stream %s%s", service, s.StreamName)
+		}
+	}
+
+	mux := http.NewServeMux()
+	for path, h := range routes {
+		mux.Handle(path, h)
+	}
+	browser := cors.wrap(withPeer(mux))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && isGRPC(r.Header.Get("Content-Type")) {
+			native.ServeHTTP(w, r)
+			return
+		}
+		browser.ServeHTTP(w, r)
+	}), nil
+}
+
+// isGRPC reports whether contentType is that of native gRPC, which
+// gRPC-Web's application/grpc-web is not.
+func isGRPC(contentType string) bool {
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")
+}
+
+// unary adapts call to connect-go. The request headers become incoming
+// metadata and whatever the interceptors set with grpc.SetHeader or
+// grpc.SetTrailer is sent back, on errors too.
+func unary[Req, Res any](fullMethod string, call func(context.Context, *Req) (*Res, error)) func(
+	context.Context, *connect.Request[Req]) (*connect.Response[Res], error) {
+	return func(ctx context.Context, req *connect.Request[Req]) (*connect.Response[Res], error) {
+		stream := &transportStream{method: fullMethod}
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+		ctx = metadata.NewIncomingContext(ctx, incomingMetadata(req.Header()))
+
+		res, err := call(ctx, req.Msg)
+		if err != nil {
+			cerr := connectError(err)
+			setHeaders(cerr.Meta(), stream.header)
+			setHeaders(cerr.Meta(), stream.trailer)
+			return nil, cerr
+		}
+
+		resp := connect.NewResponse(res)
+		setHeaders(resp.Header(), stream.header)
+		setHeaders(resp.Trailer(), stream.trailer)
+		return resp, nil
+	}
+}
+
+// incomingMetadata returns the headers as gRPC metadata, leaving out those
+// of the protocols themselves as the gRPC server does.
+func incomingMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range h {
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, "connect-") || key == "te" {
+			continue
+		}
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				decoded, err := connect.DecodeBinaryHeader(v)
+				if err != nil {
+					continue
+				}
+				v = string(decoded)
+			}
+			md.Append(key, v)
+		}
+	}
+	return md
+}
+
+func setHeaders(h http.Header, md metadata.MD) {
+	for key, values := range md {
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = connect.EncodeBinaryHeader([]byte(v))
+			}
+			h.Add(key, v)
+		}
+	}
+}
+
+// connectError converts a gRPC status error, keeping its details.
+func connectError(err error) *connect.Error {
+	var cerr *connect.Error
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+
+	st := status.Convert(err)
+	cerr = connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, d := range st.Proto().GetDetails() {
+		if detail, err := connect.NewErrorDetail(d); err == nil {
+			cerr.AddDetail(detail)
+		}
+	}
+	return cerr
+}
+
+// transportStream collects the headers and trailers set by a call.
+type transportStream struct {
+	method  string
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string { return s.method }
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+// withPeer adds the caller's address and TLS state to the request context
+// as the gRPC server does, for the call log and certificate identities.
+func withPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := &peer.Peer{Addr: remoteAddr(r.RemoteAddr)}
+		if r.TLS != nil {
+			p.AuthInfo = credentials.TLSInfo{
+				State:          *r.TLS,
+				CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(peer.NewContext(r.Context(), p)))
+	})
+}
+
+type remoteAddr string
+
+func (a remoteAddr) Network() string { return "tcp" }
+func (a remoteAddr) String() string  { return string(a) }
+
+// wrap answers preflight requests from allowed origins and adds CORS
+// headers to their other requests. Requests from other origins pass
+// through without them, so browsers refuse the responses.
+func (c CORS) wrap(next http.Handler) http.Handler {
+	if len(c.AllowedOrigins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", "GET, POST")
+			h.Set("Access-Control-Allow-Headers", allowedHeaders)
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Expose-Headers", exposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c CORS) allowed(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
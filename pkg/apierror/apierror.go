@@ -0,0 +1,164 @@
+// Package apierror builds and decodes the structured details attached to
+// errors returned by the explore service: a google.rpc.ErrorInfo reason on
+// every error, google.rpc.BadRequest field violations on invalid requests
+// and google.rpc.RetryInfo on errors worth retrying.
+package apierror
+
+import (
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain is the ErrorInfo domain of every reason below.
+const Domain = "explore.ExploreService"
+
+// ErrorInfo reasons.
+const (
+	ReasonSelfDecision      = "SELF_DECISION"
+	ReasonInvalidUserID     = "INVALID_USER_ID"
+	ReasonMissingField      = "MISSING_FIELD"
+	ReasonInvalidArgument   = "INVALID_ARGUMENT"
+	ReasonUnauthenticated   = "UNAUTHENTICATED"
+	ReasonPermissionDenied  = "PERMISSION_DENIED"
+	ReasonAlreadyExists     = "ALREADY_EXISTS"
+	ReasonConcurrentUpdate  = "CONCURRENT_UPDATE"
+	ReasonUnavailable       = "UNAVAILABLE"
+	ReasonDeadlineExceeded  = "DEADLINE_EXCEEDED"
+	ReasonCancelled         = "CANCELLED"
+	ReasonResourceExhausted = "RESOURCE_EXHAUSTED"
+	ReasonInternal          = "INTERNAL"
+)
+
+// FieldViolation names one invalid request field.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Details is everything a client can read off an explore error.
+type Details struct {
+	Code    codes.Code
+	Message string
+
+	Reason   string
+	Metadata map[string]string
+
+	FieldViolations []FieldViolation
+
+	// RetryDelay is how long to wait before retrying; zero when the
+	// server did not suggest a retry.
+	RetryDelay time.Duration
+}
+
+// Option adds a detail to an error built by New.
+type Option func(*[]protoadapt.MessageV1)
+
+// WithFieldViolations attaches a google.rpc.BadRequest.
+func WithFieldViolations(violations ...FieldViolation) Option {
+	return func(details *[]protoadapt.MessageV1) {
+		br := &errdetails.BadRequest{}
+		for _, v := range violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		*details = append(*details, br)
+	}
+}
+
+// WithMetadata sets the ErrorInfo metadata.
+func WithMetadata(metadata map[string]string) Option {
+	return func(details *[]protoadapt.MessageV1) {
+		(*details)[0].(*errdetails.ErrorInfo).Metadata = metadata
+	}
+}
+
+// WithRetryDelay attaches a google.rpc.RetryInfo.
+func WithRetryDelay(d time.Duration) Option {
+	return func(details *[]protoadapt.MessageV1) {
+		*details = append(*details, &errdetails.RetryInfo{RetryDelay: durationpb.New(d)})
+	}
+}
+
+// New returns a status error carrying an ErrorInfo with reason, plus any
+// details added by opts.
+func New(code codes.Code, reason, msg string, opts ...Option) error {
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: Domain}}
+	for _, opt := range opts {
+		opt(&details)
+	}
+
+	st, err := status.New(code, msg).WithDetails(details...)
+	if err != nil {
+		// only fails for a non-error code, which callers never pass
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// Parse decodes err. ok is false when err carries no gRPC status, in which
+// case only Code (Unknown) and Message are set.
+func Parse(err error) (d Details, ok bool) {
+	st, ok := status.FromError(err)
+
+	d = Details{Code: st.Code(), Message: st.Message()}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			d.Reason = detail.GetReason()
+			d.Metadata = detail.GetMetadata()
+		case *errdetails.BadRequest:
+			for _, v := range detail.GetFieldViolations() {
+				d.FieldViolations = append(d.FieldViolations, FieldViolation{Field: v.GetField(), Description: v.GetDescription()})
+			}
+		case *errdetails.RetryInfo:
+			d.RetryDelay = detail.GetRetryDelay().AsDuration()
+		}
+	}
+	return d, ok
+}
+
+// Reason returns the ErrorInfo reason of err, or "" if it has none.
+func Reason(err error) string {
+	d, _ := Parse(err)
+	return d.Reason
+}
+
+// RetryDelay reports whether the server suggested retrying err and after
+// how long.
+func RetryDelay(err error) (time.Duration, bool) {
+	d, _ := Parse(err)
+	return d.RetryDelay, d.RetryDelay > 0
+}
+
+// CodeReason is the reason used for errors that have no more specific one.
+func CodeReason(code codes.Code) string {
+	switch code {
+	case codes.InvalidArgument:
+		return ReasonInvalidArgument
+	case codes.Unauthenticated:
+		return ReasonUnauthenticated
+	case codes.PermissionDenied:
+		return ReasonPermissionDenied
+	case codes.AlreadyExists:
+		return ReasonAlreadyExists
+	case codes.Aborted:
+		return ReasonConcurrentUpdate
+	case codes.Unavailable:
+		return ReasonUnavailable
+	case codes.DeadlineExceeded:
+		return ReasonDeadlineExceeded
+	case codes.Canceled:
+		return ReasonCancelled
+	case codes.ResourceExhausted:
+		return ReasonResourceExhausted
+	default:
+		return ReasonInternal
+	}
+}
@@ -0,0 +1,416 @@
+// Package exploreclient is the Go client of the ExploreService. It wraps
+// the generated stub with plain Go types, iterators that follow pagination
+// tokens, retries of unavailable calls and hedging of slow reads.
+//
+// Errors are the gRPC status errors of the server; apierror.Parse reads
+// their reason, field violations and retry delay.
+package exploreclient
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
+
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultServiceConfig is the service config New dials with when reads
+// are hedged. PutDecision, which the server treats as an idempotent
+// upsert, is retried on UNAVAILABLE with exponential backoff.
+// RESOURCE_EXHAUSTED from load shedding is returned instead, as retrying
+// it at once adds to the overload. Retry throttling stops retries when
+// most calls fail. The hedged reads have no retry policy: each copy would
+// be retried on its own, and the hedge already sends the next copy when
+// one fails with UNAVAILABLE.
+const DefaultServiceConfig = `{
+  "methodConfig": [
+    {
+      "name": [{"service": "explore.ExploreService"}],
+      "retryPolicy": {
+        "maxAttempts": 4,
+        "initialBackoff": "0.1s",
+        "maxBackoff": "2s",
+        "backoffMultiplier": 2,
+        "retryableStatusCodes": ["UNAVAILABLE"]
+      }
+    },
+    {
+      "name": [
+        {"service": "explore.ExploreService", "method": "ListLikedYou"},
+        {"service": "explore.ExploreService", "method": "ListNewLikedYou"},
+        {"service": "explore.ExploreService", "method": "CountLikedYou"}
+      ]
+    }
+  ],
+  "retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+}`
+
+// UnhedgedServiceConfig is the service config New dials with when reads
+// aren't hedged: every RPC is retried as DefaultServiceConfig retries
+// PutDecision.
+const UnhedgedServiceConfig = `{
+  "methodConfig": [
+    {
+      "name": [{"service": "explore.ExploreService"}],
+      "retryPolicy": {
+        "maxAttempts": 4,
+        "initialBackoff": "0.1s",
+        "maxBackoff": "2s",
+        "backoffMultiplier": 2,
+        "retryableStatusCodes": ["UNAVAILABLE"]
+      }
+    }
+  ],
+  "retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+}`
+
+// HedgingPolicy sends extra copies of a read that is slow to answer, and
+// takes the first answer. grpc-go ignores hedgingPolicy in service
+// configs, so the client does it itself.
+type HedgingPolicy struct {
+	// MaxAttempts is how many copies of a call may be sent in all; 1 or
+	// less turns hedging off.
+	MaxAttempts int
+	// Delay is how long to wait for an answer before sending the next
+	// copy. A copy failing with UNAVAILABLE sends the next one at once.
+	Delay time.Duration
+}
+
+// DefaultHedgingPolicy hedges ListLikedYou, ListNewLikedYou and
+// CountLikedYou after 200ms, with up to 3 copies.
+var DefaultHedgingPolicy = HedgingPolicy{MaxAttempts: 3, Delay: 200 * time.Millisecond}
+
+// Liker is someone who liked a user.
+type Liker struct {
+	ActorID string
+	LikedAt time.Time
+}
+
+// Page is one page of likers, most recent first. NextPageToken fetches
+// the next page and is empty on the last.
+type Page struct {
+	Likers        []Liker
+	NextPageToken string
+}
+
+// Client calls the ExploreService. It is safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn // nil when the caller owns the connection
+	rpc  pb.ExploreServiceClient
+}
+
+type options struct {
+	creds         credentials.TransportCredentials
+	token         string
+	insecureToken bool
+	serviceConfig string
+	hedging       HedgingPolicy
+	dialOptions   []grpc.DialOption
+}
+
+// Option configures New.
+type Option func(*options)
+
+// WithInsecure connects without TLS, e.g. to a local server.
+func WithInsecure() Option {
+	return func(o *options) {
+		o.creds = insecure.NewCredentials()
+	}
+}
+
+// WithTransportCredentials replaces the default, TLS verified against the
+// system's root certificates, e.g. to trust a private CA or present a
+// client certificate.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithBearerToken sends token in the authorization header of every call.
+// New refuses to send it without TLS unless WithInsecureToken is given.
+func WithBearerToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithInsecureToken lets WithBearerToken send its token over a connection
+// without TLS, where anyone on the path can read it, e.g. to a local
+// server.
+func WithInsecureToken() Option {
+	return func(o *options) {
+		o.insecureToken = true
+	}
+}
+
+// WithServiceConfig dials with config instead of DefaultServiceConfig or
+// UnhedgedServiceConfig. A retry policy for the hedged reads multiplies
+// the copies of a call, as each one is retried on its own.
+func WithServiceConfig(config string) Option {
+	return func(o *options) {
+		o.serviceConfig = config
+	}
+}
+
+// WithHedging hedges reads with p instead of DefaultHedgingPolicy.
+func WithHedging(p HedgingPolicy) Option {
+	return func(o *options) {
+		o.hedging = p
+	}
+}
+
+// WithDialOptions adds options to those New dials with; they are applied
+// last and win.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// New connects to the server at target, e.g. "explore.example.com:443".
+// The connection is made lazily, on the first call.
+func New(target string, opts ...Option) (*Client, error) {
+	o := options{
+		creds:   credentials.NewTLS(nil),
+		hedging: DefaultHedgingPolicy,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.serviceConfig == "" {
+		o.serviceConfig = DefaultServiceConfig
+		if o.hedging.MaxAttempts <= 1 {
+			o.serviceConfig = UnhedgedServiceConfig
+		}
+	}
+	if o.token != "" && o.creds.Info().SecurityProtocol == "insecure" && !o.insecureToken {
+		return nil, errors.New("exploreclient: refusing to send a bearer token without TLS; add WithInsecureToken to allow it")
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(o.creds),
+		grpc.WithDefaultServiceConfig(o.serviceConfig),
+		grpc.WithUnaryInterceptor(hedge(o.hedging)),
+	}
+	if o.token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(bearerToken{o.token, o.insecureToken}))
+	}
+	dialOptions = append(dialOptions, o.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, rpc: pb.NewExploreServiceClient(conn)}, nil
+}
+
+// NewFromConn returns a client using cc, which the caller dials, e.g.
+// with grpc.WithDefaultServiceConfig(DefaultServiceConfig) and Hedge, and
+// closes. Reads aren't hedged unless cc was dialed with Hedge; without it,
+// UnhedgedServiceConfig retries them.
+func NewFromConn(cc grpc.ClientConnInterface) *Client {
+	return &Client{rpc: pb.NewExploreServiceClient(cc)}
+}
+
+// Close closes the connection New made. It does nothing for a client
+// from NewFromConn.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// PutDecision records whether actorID likes recipientID and reports
+// whether the two now like each other.
+func (c *Client) PutDecision(ctx context.Context, actorID, recipientID string, liked bool) (mutual bool, err error) {
+	resp, err := c.rpc.PutDecision(ctx, &pb.PutDecisionRequest{
+		ActorUserId:     actorID,
+		RecipientUserId: recipientID,
+		LikedRecipient:  liked,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetMutualLikes(), nil
+}
+
+// CountLikedYou counts the users who like recipientID.
+func (c *Client) CountLikedYou(ctx context.Context, recipientID string) (uint64, error) {
+	resp, err := c.rpc.CountLikedYou(ctx, &pb.CountLikedYouRequest{RecipientUserId: recipientID})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetCount(), nil
+}
+
+// ListLikedYou returns the page of users who like recipientID starting at
+// pageToken, or the first page for "".
+func (c *Client) ListLikedYou(ctx context.Context, recipientID, pageToken string) (Page, error) {
+	return page(c.rpc.ListLikedYou(ctx, listRequest(recipientID, pageToken)))
+}
+
+// ListNewLikedYou is ListLikedYou without the users recipientID likes
+// back.
+func (c *Client) ListNewLikedYou(ctx context.Context, recipientID, pageToken string) (Page, error) {
+	return page(c.rpc.ListNewLikedYou(ctx, listRequest(recipientID, pageToken)))
+}
+
+// LikedYou iterates over every user who likes recipientID, most recent
+// first, fetching pages as the loop needs them:
+//
+//	for liker, err := range client.LikedYou(ctx, "42") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// A failed fetch is yielded once as an error and ends the iteration.
+func (c *Client) LikedYou(ctx context.Context, recipientID string) iter.Seq2[Liker, error] {
+	return paginate(ctx, recipientID, c.ListLikedYou)
+}
+
+// NewLikedYou is LikedYou over ListNewLikedYou.
+func (c *Client) NewLikedYou(ctx context.Context, recipientID string) iter.Seq2[Liker, error] {
+	return paginate(ctx, recipientID, c.ListNewLikedYou)
+}
+
+func paginate(ctx context.Context, recipientID string,
+	list func(ctx context.Context, recipientID, pageToken string) (Page, error)) iter.Seq2[Liker, error] {
+	return func(yield func(Liker, error) bool) {
+		token := ""
+		for {
+			p, err := list(ctx, recipientID, token)
+			if err != nil {
+				yield(Liker{}, err)
+				return
+			}
+			for _, l := range p.Likers {
+				if !yield(l, nil) {
+					return
+				}
+			}
+			if p.NextPageToken == "" {
+				return
+			}
+			token = p.NextPageToken
+		}
+	}
+}
+
+func listRequest(recipientID, pageToken string) *pb.ListLikedYouRequest {
+	req := &pb.ListLikedYouRequest{RecipientUserId: recipientID}
+	if pageToken != "" {
+		req.PaginationToken = &pageToken
+	}
+	return req
+}
+
+func page(resp *pb.ListLikedYouResponse, err error) (Page, error) {
+	if err != nil {
+		return Page{}, err
+	}
+
+	p := Page{NextPageToken: resp.GetNextPaginationToken()}
+	for _, l := range resp.GetLikers() {
+		p.Likers = append(p.Likers, Liker{
+			ActorID: l.GetActorId(),
+			LikedAt: time.Unix(int64(l.GetUnixTimestamp()), 0).UTC(),
+		})
+	}
+	return p, nil
+}
+
+// hedgedMethods are the reads a HedgingPolicy applies to.
+var hedgedMethods = map[string]bool{
+	pb.ExploreService_ListLikedYou_FullMethodName:    true,
+	pb.ExploreService_ListNewLikedYou_FullMethodName: true,
+	pb.ExploreService_CountLikedYou_FullMethodName:   true,
+}
+
+// Hedge returns the interceptor New dials with to hedge reads with p, for
+// connections made by the caller.
+func Hedge(p HedgingPolicy) grpc.UnaryClientInterceptor {
+	return hedge(p)
+}
+
+func hedge(p HedgingPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if p.MaxAttempts <= 1 || !hedgedMethods[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		// Losing attempts are cancelled when the call returns.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			reply proto.Message
+			err   error
+		}
+		results := make(chan result, p.MaxAttempts)
+		started, pending := 0, 0
+		send := func() {
+			started++
+			pending++
+			go func() {
+				// each attempt decodes into its own message
+				r := reply.(proto.Message).ProtoReflect().New().Interface()
+				results <- result{r, invoker(ctx, method, req, r, cc, opts...)}
+			}()
+		}
+
+		send()
+		timer := time.NewTimer(p.Delay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				if started < p.MaxAttempts {
+					send()
+					timer.Reset(p.Delay)
+				}
+
+			case r := <-results:
+				pending--
+				switch {
+				case r.err == nil:
+					proto.Merge(reply.(proto.Message), r.reply)
+					return nil
+				case status.Code(r.err) != codes.Unavailable:
+					return r.err
+				case started < p.MaxAttempts:
+					send()
+					timer.Reset(p.Delay)
+				case pending == 0:
+					return r.err
+				}
+			}
+		}
+	}
+}
+
+// bearerToken sends a bearer token, only over TLS unless insecure is set.
+type bearerToken struct {
+	token    string
+	insecure bool
+}
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return !t.insecure
+}
@@ -0,0 +1,231 @@
+// Package exploretest runs an in-memory ExploreService for unit tests of
+// code using exploreclient. It serves the real request handling, so
+// validation, error details and mutual-like logic are as in production,
+// over a bufconn listener with no network or database:
+//
+//	srv := exploretest.NewServer()
+//	defer srv.Close()
+//	srv.Put("1", "2", true)
+//	count, err := srv.Client().CountLikedYou(ctx, "2")
+package exploretest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fleimkeipa/grpc-example/internal/models"
+	"github.com/fleimkeipa/grpc-example/internal/server"
+	"github.com/fleimkeipa/grpc-example/pkg/apierror"
+	"github.com/fleimkeipa/grpc-example/pkg/exploreclient"
+	pb "github.com/fleimkeipa/grpc-example/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// DefaultPageSize is the page size unless WithPageSize sets another.
+const DefaultPageSize = 30
+
+// Server is an ExploreService listening in memory.
+type Server struct {
+	lis    *bufconn.Listener
+	grpc   *grpc.Server
+	store  *store
+	client *exploreclient.Client
+}
+
+type options struct {
+	pageSize     int
+	interceptors []grpc.UnaryServerInterceptor
+}
+
+// Option configures NewServer.
+type Option func(*options)
+
+// WithPageSize sets how many likers a page holds, e.g. 2 to test
+// pagination with few decisions.
+func WithPageSize(n int) Option {
+	return func(o *options) {
+		o.pageSize = n
+	}
+}
+
+// WithInterceptors runs every call through interceptors, outermost first,
+// e.g. to fail or delay some calls.
+func WithInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// NewServer starts a server. Close stops it.
+func NewServer(opts ...Option) *Server {
+	o := options{pageSize: DefaultPageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := &Server{
+		lis:   bufconn.Listen(1 << 20),
+		grpc:  grpc.NewServer(grpc.ChainUnaryInterceptor(o.interceptors...)),
+		store: &store{pageSize: o.pageSize, rows: make(map[[2]string]*models.Decision)},
+	}
+	pb.RegisterExploreServiceServer(s.grpc, server.NewExploreServer(s.store))
+	go s.grpc.Serve(s.lis)
+
+	client, err := s.Dial()
+	if err != nil {
+		panic(fmt.Sprintf("exploretest: dial: %v", err)) // only fails for a bad default service config
+	}
+	s.client = client
+
+	return s
+}
+
+// Client returns a client of the server with the default options.
+func (s *Server) Client() *exploreclient.Client {
+	return s.client
+}
+
+// Dial returns a new client of the server with opts, e.g. a different
+// service config. The caller closes it.
+func (s *Server) Dial(opts ...exploreclient.Option) (*exploreclient.Client, error) {
+	opts = append([]exploreclient.Option{
+		exploreclient.WithInsecure(),
+		exploreclient.WithDialOptions(s.DialOptions()...),
+	}, opts...)
+	return exploreclient.New("passthrough:///exploretest", opts...)
+}
+
+// DialOptions connect a grpc.ClientConn of the caller's own to the server;
+// the target is ignored.
+func (s *Server) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.lis.DialContext(ctx)
+		}),
+	}
+}
+
+// Put stores a decision as PutDecision would, without a call.
+func (s *Server) Put(actorID, recipientID string, liked bool) {
+	s.store.PutDecision(context.Background(), &models.Decision{
+		ActorUserId:     actorID,
+		RecipientUserId: recipientID,
+		LikedRecipient:  liked,
+	})
+}
+
+// Close stops the server and closes the client from Client.
+func (s *Server) Close() {
+	s.client.Close()
+	s.grpc.Stop()
+}
+
+// store keeps decisions in memory with the semantics of the Postgres
+// repository: likers most recent first, paged by a created_at cursor.
+type store struct {
+	pageSize int
+
+	mu   sync.Mutex
+	rows map[[2]string]*models.Decision
+	last time.Time
+}
+
+// now returns the time, strictly after the last it returned, so every
+// decision has its own created_at.
+func (s *store) now() time.Time {
+	now := time.Now()
+	if !now.After(s.last) {
+		now = s.last.Add(time.Microsecond)
+	}
+	s.last = now
+	return now
+}
+
+func (s *store) PutDecision(_ context.Context, d *models.Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key := [2]string{d.ActorUserId, d.RecipientUserId}
+	row, ok := s.rows[key]
+	if !ok {
+		row = &models.Decision{ActorUserId: d.ActorUserId, RecipientUserId: d.RecipientUserId, CreatedAt: now}
+		s.rows[key] = row
+	}
+	d.PreviouslyLiked = ok && row.LikedRecipient
+	row.LikedRecipient = d.LikedRecipient
+	row.UpdatedAt = now
+
+	d.CreatedAt, d.UpdatedAt = row.CreatedAt, row.UpdatedAt
+	return nil
+}
+
+func (s *store) ListLikedYou(_ context.Context, recipientID, paginationToken string) ([]models.Decision, string, error) {
+	return s.list(recipientID, paginationToken, false)
+}
+
+func (s *store) ListNewLikedYou(_ context.Context, recipientID, paginationToken string) ([]models.Decision, string, error) {
+	return s.list(recipientID, paginationToken, true)
+}
+
+func (s *store) list(recipientID, paginationToken string, onlyNew bool) ([]models.Decision, string, error) {
+	var cursor time.Time
+	if paginationToken != "" {
+		var err error
+		if cursor, err = time.Parse(time.RFC3339Nano, paginationToken); err != nil {
+			return nil, "", apierror.New(codes.InvalidArgument, apierror.ReasonInvalidArgument, "invalid pagination token")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var likers []models.Decision
+	for _, d := range s.rows {
+		if d.RecipientUserId != recipientID || !d.LikedRecipient {
+			continue
+		}
+		if paginationToken != "" && !d.CreatedAt.Before(cursor) {
+			continue
+		}
+		if back := s.rows[[2]string{recipientID, d.ActorUserId}]; onlyNew && back != nil && back.LikedRecipient {
+			continue
+		}
+		likers = append(likers, *d)
+	}
+	slices.SortFunc(likers, func(a, b models.Decision) int { return b.CreatedAt.Compare(a.CreatedAt) })
+
+	if len(likers) <= s.pageSize {
+		return likers, "", nil
+	}
+	likers = likers[:s.pageSize]
+	return likers, likers[s.pageSize-1].CreatedAt.Format(time.RFC3339Nano), nil
+}
+
+func (s *store) IsMutual(_ context.Context, actorID, recipientID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	there, back := s.rows[[2]string{actorID, recipientID}], s.rows[[2]string{recipientID, actorID}]
+	return there != nil && there.LikedRecipient && back != nil && back.LikedRecipient, nil
+}
+
+func (s *store) CountLikedYou(_ context.Context, recipientID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, d := range s.rows {
+		if d.RecipientUserId == recipientID && d.LikedRecipient {
+			n++
+		}
+	}
+	return n, nil
+}
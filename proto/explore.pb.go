@@ -11,6 +11,7 @@ import (
 	sync "sync"
 	unsafe "unsafe"
 
+	_ "google.golang.org/genproto/googleapis/api/annotations"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 )
@@ -374,7 +375,7 @@ var File_proto_explore_proto protoreflect.FileDescriptor
 
 const file_proto_explore_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/explore.proto\x12\aexplore\"\x86\x01\n" +
+	"\x13proto/explore.proto\x12\aexplore\x1a\x1cgoogle/api/annotations.proto\"\x86\x01\n" +
 	"\x13ListLikedYouRequest\x12*\n" +
 	"\x11recipient_user_id\x18\x01 \x01(\tR\x0frecipientUserId\x12.\n" +
 	"\x10pagination_token\x18\x02 \x01(\tH\x00R\x0fpaginationToken\x88\x01\x01B\x13\n" +
@@ -395,12 +396,12 @@ const file_proto_explore_proto_rawDesc = "" +
 	"\x11recipient_user_id\x18\x02 \x01(\tR\x0frecipientUserId\x12'\n" +
 	"\x0fliked_recipient\x18\x03 \x01(\bR\x0elikedRecipient\"8\n" +
 	"\x13PutDecisionResponse\x12!\n" +
-	"\fmutual_likes\x18\x01 \x01(\bR\vmutualLikes2\xc7\x02\n" +
-	"\x0eExploreService\x12K\n" +
-	"\fListLikedYou\x12\x1c.explore.ListLikedYouRequest\x1a\x1d.explore.ListLikedYouResponse\x12N\n" +
-	"\x0fListNewLikedYou\x12\x1c.explore.ListLikedYouRequest\x1a\x1d.explore.ListLikedYouResponse\x12N\n" +
-	"\rCountLikedYou\x12\x1d.explore.CountLikedYouRequest\x1a\x1e.explore.CountLikedYouResponse\x12H\n" +
-	"\vPutDecision\x12\x1b.explore.PutDecisionRequest\x1a\x1c.explore.PutDecisionResponseB2Z0github.com/fleimkeipa/grpc-example/proto;exploreb\x06proto3"
+	"\fmutual_likes\x18\x01 \x01(\bR\vmutualLikes2\xf3\x03\n" +
+	"\x0eExploreService\x12x\n" +
+	"\fListLikedYou\x12\x1c.explore.ListLikedYouRequest\x1a\x1d.explore.ListLikedYouResponse\"+\x82\xd3\xe4\x93\x02%\x12#/v1/users/{recipient_user_id}/likes\x12\x7f\n" +
+	"\x0fListNewLikedYou\x12\x1c.explore.ListLikedYouRequest\x1a\x1d.explore.ListLikedYouResponse\"/\x82\xd3\xe4\x93\x02)\x12'/v1/users/{recipient_user_id}/likes/new\x12\x81\x01\n" +
+	"\rCountLikedYou\x12\x1d.explore.CountLikedYouRequest\x1a\x1e.explore.CountLikedYouResponse\"1\x82\xd3\xe4\x93\x02+\x12)/v1/users/{recipient_user_id}/likes/count\x12b\n" +
+	"\vPutDecision\x12\x1b.explore.PutDecisionRequest\x1a\x1c.explore.PutDecisionResponse\"\x18\x82\xd3\xe4\x93\x02\x12:\x01*\"\r/v1/decisionsB2Z0github.com/fleimkeipa/grpc-example/proto;exploreb\x06proto3"
 
 var (
 	file_proto_explore_proto_rawDescOnce sync.Once
@@ -29,9 +29,13 @@ const (
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ExploreServiceClient interface {
+	// List all users who liked the recipient
 	ListLikedYou(ctx context.Context, in *ListLikedYouRequest, opts ...grpc.CallOption) (*ListLikedYouResponse, error)
+	// List all users who liked the recipient excluding those who have been liked in return
 	ListNewLikedYou(ctx context.Context, in *ListLikedYouRequest, opts ...grpc.CallOption) (*ListLikedYouResponse, error)
+	// Count the number of users who liked the recipient
 	CountLikedYou(ctx context.Context, in *CountLikedYouRequest, opts ...grpc.CallOption) (*CountLikedYouResponse, error)
+	// Record the decision of the actor to like or pass the recipient
 	PutDecision(ctx context.Context, in *PutDecisionRequest, opts ...grpc.CallOption) (*PutDecisionResponse, error)
 }
 
@@ -87,9 +91,13 @@ func (c *exploreServiceClient) PutDecision(ctx context.Context, in *PutDecisionR
 // All implementations must embed UnimplementedExploreServiceServer
 // for forward compatibility.
 type ExploreServiceServer interface {
+	// List all users who liked the recipient
 	ListLikedYou(context.Context, *ListLikedYouRequest) (*ListLikedYouResponse, error)
+	// List all users who liked the recipient excluding those who have been liked in return
 	ListNewLikedYou(context.Context, *ListLikedYouRequest) (*ListLikedYouResponse, error)
+	// Count the number of users who liked the recipient
 	CountLikedYou(context.Context, *CountLikedYouRequest) (*CountLikedYouResponse, error)
+	// Record the decision of the actor to like or pass the recipient
 	PutDecision(context.Context, *PutDecisionRequest) (*PutDecisionResponse, error)
 	mustEmbedUnimplementedExploreServiceServer()
 }